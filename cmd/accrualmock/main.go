@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/gophermart/internal/accrualmock"
+)
+
+const timeoutServerShutdown = time.Second * 10
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	ctx, cancelCtx := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancelCtx()
+
+	zapl, err := zap.NewProduction()
+	if err != nil {
+		return fmt.Errorf("failed to initialize logger err: %w ", err)
+	}
+	log := zapl.Sugar()
+
+	cfg := accrualmock.GetConfig()
+	log.Infof("config %+v", cfg)
+
+	var store accrualmock.Storage = accrualmock.NewMemory()
+	if cfg.DSN != "" {
+		pg, err := accrualmock.NewPostgres(ctx, cfg.DSN)
+		if err != nil {
+			return fmt.Errorf("failed to initialize DB err: %w", err)
+		}
+		defer pg.Close()
+		store = pg
+	}
+
+	s := accrualmock.NewService(store, log, cfg.RateLimit)
+	go s.Run(ctx, cfg.ProcessInterval)
+
+	srv := &http.Server{
+		Addr:    cfg.Address,
+		Handler: s,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errs <- fmt.Errorf("listen and serve has failed: %w", err)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err := <-errs:
+		return err
+	}
+
+	shutdownCtx, cancelShutdownCtx := context.WithTimeout(context.Background(), timeoutServerShutdown)
+	defer cancelShutdownCtx()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("server shutdown err: %w", err)
+	}
+
+	return nil
+}
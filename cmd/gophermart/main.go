@@ -63,8 +63,14 @@ func run() (err error) {
 	componentsErrs := make(chan error, 1)
 
 	// Get config
-	cfg := config.GetConfig()
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get config err: %w", err)
+	}
 	log.Infof("config %+v", cfg)
+	for _, w := range cfg.Warnings {
+		log.Warn(w)
+	}
 
 	// Init DB
 	db, err := db.NewDB(ctx, cfg.DSN, log)
@@ -72,22 +78,21 @@ func run() (err error) {
 		return fmt.Errorf("failed to initialize DB err: %w", err)
 	}
 
-	wg.Add(1)
-	go func() {
-		defer log.Info("closed DB")
-		defer wg.Done()
-		<-ctx.Done()
-
-		db.Close()
-	}()
+	ms, err := db.CheckLedger(ctx)
+	if err != nil {
+		log.Errorf("failed to check ledger err: %v", err)
+	}
+	for _, m := range ms {
+		log.Warnf("user %s current balance %s doesn't match ledger %s", m.UserID, m.Balance, m.Ledger)
+	}
 
 	// Init Handlers
-	hashc, err := security.NewHashController()
+	hashc, err := security.NewHashController(cfg.PasswordHash)
 	if err != nil {
 		return fmt.Errorf("failed to initialize hashcontroller err: %w", err)
 	}
 
-	h, err := server.NewHandlers(cfg.Key, db, log, cfg.TokenExp, hashc)
+	h, err := server.NewHandlers(*cfg, db, log, hashc)
 	if err != nil {
 		return fmt.Errorf("failed to initialize handlers err: %w", err)
 	}
@@ -112,6 +117,9 @@ func run() (err error) {
 		if err := srv.Shutdown(shutdownTimeoutCtx); err != nil {
 			log.Errorf("an error occurred during server shutdown: %v", err)
 		}
+
+		db.Close()
+		log.Info("closed DB")
 	}()
 
 	select {
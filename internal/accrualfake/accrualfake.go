@@ -0,0 +1,196 @@
+// Package accrualfake is an in-process accrual service for tests and local development.
+// It serves GET /api/orders/{number} and answers every order with its script.
+package accrualfake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+const (
+	StatusRegistered = "REGISTERED"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+)
+
+// Step is one scripted answer. The zero HTTP status means 200 with the order status and the accrual,
+// other statuses are answered with the body as it is.
+type Step struct {
+	Status     string
+	Body       string
+	RetryAfter string
+	Delay      time.Duration
+	Code       int
+	Accrual    models.Amount
+}
+
+func Registered() Step {
+	return Step{Status: StatusRegistered}
+}
+
+func Processing() Step {
+	return Step{Status: StatusProcessing}
+}
+
+func Invalid() Step {
+	return Step{Status: StatusInvalid}
+}
+
+func Processed(accrual models.Amount) Step {
+	return Step{Status: StatusProcessed, Accrual: accrual}
+}
+
+// NotRegistered answers 204 as the service does for the orders it does not know.
+func NotRegistered() Step {
+	return Step{Code: http.StatusNoContent}
+}
+
+// TooManyRequests answers 429, the retryAfter is sent as the Retry-After header as it is.
+func TooManyRequests(retryAfter string) Step {
+	return Step{
+		Code:       http.StatusTooManyRequests,
+		RetryAfter: retryAfter,
+		Body:       "No more than 10 requests per minute allowed",
+	}
+}
+
+func InternalError() Step {
+	return Step{Code: http.StatusInternalServerError, Body: "internal server error"}
+}
+
+// Slow delays the answer of the step.
+func Slow(d time.Duration, s Step) Step {
+	s.Delay = d
+	return s
+}
+
+type script struct {
+	steps []Step
+	next  int
+}
+
+// Service answers the orders with their scripts, the last step of a script is repeated.
+// The orders without a script are not registered.
+type Service struct {
+	mx      *sync.Mutex
+	scripts map[string]*script
+	calls   map[string]int
+	router  *chi.Mux
+}
+
+func New() *Service {
+	s := &Service{
+		mx:      &sync.Mutex{},
+		scripts: make(map[string]*script),
+		calls:   make(map[string]int),
+		router:  chi.NewRouter(),
+	}
+
+	s.router.Get("/api/orders/{number}", s.getOrder)
+
+	return s
+}
+
+// NewServer starts the service on a local port, the caller closes the server.
+func NewServer() (*Service, *httptest.Server) {
+	s := New()
+	return s, httptest.NewServer(s)
+}
+
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// Script replaces the answers for the order.
+func (s *Service) Script(number string, steps ...Step) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.scripts[number] = &script{steps: steps}
+}
+
+// Calls returns the number of requests for the order.
+func (s *Service) Calls(number string) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.calls[number]
+}
+
+func (s *Service) step(number string) Step {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.calls[number]++
+
+	sc, ok := s.scripts[number]
+	if !ok || len(sc.steps) == 0 {
+		return NotRegistered()
+	}
+
+	st := sc.steps[sc.next]
+	if sc.next < len(sc.steps)-1 {
+		sc.next++
+	}
+	return st
+}
+
+func (s *Service) getOrder(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+	st := s.step(number)
+
+	if st.Delay > 0 {
+		select {
+		case <-time.After(st.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if st.Code != 0 && st.Code != http.StatusOK {
+		if st.RetryAfter != "" {
+			w.Header().Set("Retry-After", st.RetryAfter)
+		}
+		if st.Body != "" {
+			w.Header().Set("Content-Type", "text/plain")
+		}
+		w.WriteHeader(st.Code)
+		_, _ = w.Write([]byte(st.Body))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if st.Body != "" {
+		_, _ = w.Write([]byte(st.Body))
+		return
+	}
+
+	resp := struct {
+		Order   string         `json:"order"`
+		Status  string         `json:"status"`
+		Accrual *models.Amount `json:"accrual,omitempty"`
+	}{
+		Order:  number,
+		Status: st.Status,
+	}
+	if st.Status == StatusProcessed {
+		resp.Accrual = &st.Accrual
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, _ = w.Write(b)
+}
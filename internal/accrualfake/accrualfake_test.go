@@ -0,0 +1,45 @@
+package accrualfake
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Script(t *testing.T) {
+	s, ts := NewServer()
+	defer ts.Close()
+
+	s.Script("49927398716", Registered(), TooManyRequests("5"), Processed(72998))
+
+	get := func(number string) (*http.Response, string) {
+		resp, err := ts.Client().Get(ts.URL + "/api/orders/" + number)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(b)
+	}
+
+	resp, body := get("49927398716")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.JSONEq(t, `{"order":"49927398716","status":"REGISTERED"}`, body)
+
+	resp, _ = get("49927398716")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "5", resp.Header.Get("Retry-After"))
+
+	for i := 0; i < 2; i++ {
+		resp, body = get("49927398716")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.JSONEq(t, `{"order":"49927398716","status":"PROCESSED","accrual":729.98}`, body)
+	}
+	require.Equal(t, 4, s.Calls("49927398716"))
+
+	resp, _ = get("79927398713")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Equal(t, 1, s.Calls("79927398713"))
+}
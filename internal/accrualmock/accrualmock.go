@@ -0,0 +1,88 @@
+// Package accrualmock is the accrual system for local stacks. Orders are registered with their goods,
+// rewards are registered for the goods descriptions, and registered orders are processed in the background.
+package accrualmock
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+const (
+	StatusRegistered = "REGISTERED"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+)
+
+const (
+	RewardPercent = "%"
+	RewardPoints  = "pt"
+)
+
+var ErrOrderExists = errors.New("the order is registered already")
+var ErrOrderNotFound = errors.New("the order is not registered")
+var ErrRewardExists = errors.New("the reward is registered already")
+
+type Good struct {
+	Description string        `json:"description"`
+	Price       models.Amount `json:"price"`
+}
+
+type Order struct {
+	Registered time.Time     `json:"-"`
+	Number     string        `json:"order"`
+	Status     string        `json:"status"`
+	Goods      []Good        `json:"goods,omitempty"`
+	Accrual    models.Amount `json:"accrual"`
+}
+
+type Reward struct {
+	Match      string        `json:"match"`
+	RewardType string        `json:"reward_type"`
+	Reward     models.Amount `json:"reward"`
+}
+
+type Storage interface {
+	AddOrder(ctx context.Context, o *Order) error
+	GetOrder(ctx context.Context, number string) (*Order, error)
+	GetOrdersByStatus(ctx context.Context, status string, limit int) ([]*Order, error)
+	UpdateOrder(ctx context.Context, o *Order) error
+	AddReward(ctx context.Context, r *Reward) error
+	GetRewards(ctx context.Context) ([]*Reward, error)
+}
+
+// reward returns the reward for the good, the reward of a percent is rounded to hundredths.
+func (r *Reward) reward(g Good) models.Amount {
+	if r.RewardType == RewardPoints {
+		return r.Reward
+	}
+
+	const scale = 100 * 100
+	return (g.Price*r.Reward + scale/2) / scale
+}
+
+// calculate applies to every good the first registered reward that matches its description.
+// The order without any matched good is invalid.
+func calculate(o *Order, rs []*Reward) (string, models.Amount) {
+	var accrual models.Amount
+	matched := false
+
+	for _, g := range o.Goods {
+		for _, r := range rs {
+			if strings.Contains(g.Description, r.Match) {
+				accrual += r.reward(g)
+				matched = true
+				break
+			}
+		}
+	}
+
+	if !matched {
+		return StatusInvalid, 0
+	}
+	return StatusProcessed, accrual
+}
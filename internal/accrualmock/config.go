@@ -0,0 +1,63 @@
+package accrualmock
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	Address         string
+	DSN             string
+	RateLimit       int
+	ProcessInterval time.Duration
+}
+
+const envAddress = "RUN_ADDRESS"
+const envDSN = "DATABASE_URI"
+const envRateLimit = "RATE_LIMIT"
+const envProcessInterval = "PROCESS_INTERVAL_SECOND"
+
+// GetConfig reads the flags and the environment the same way the accrual system does,
+// the orders are kept in memory when DATABASE_URI is empty.
+func GetConfig() *Config {
+	c := &Config{}
+
+	var processInterval int
+	pflag.StringVarP(&c.Address, "address", "a", "", "Accrual mock address and port")
+	pflag.StringVarP(&c.DSN, "dsn", "d", "", "Postgresql DSN string, the storage is in memory when it is empty")
+	pflag.IntVarP(&c.RateLimit, "rateLimit", "l", 0,
+		"Maximum number of GET /api/orders/{number} requests per minute, 0 means unlimited")
+	pflag.IntVarP(&processInterval, "processInterval", "p", 0, "Interval between order status changes in seconds")
+	pflag.Parse()
+
+	const defAddress = "localhost:8080"
+	const defRateLimit = 0
+	const defProcessInterval = 1
+
+	viper.AutomaticEnv()
+	viper.SetDefault(envAddress, defAddress)
+	viper.SetDefault(envDSN, "")
+	viper.SetDefault(envRateLimit, defRateLimit)
+	viper.SetDefault(envProcessInterval, defProcessInterval)
+
+	if c.Address == "" {
+		c.Address = viper.GetString(envAddress)
+	}
+
+	if c.DSN == "" {
+		c.DSN = viper.GetString(envDSN)
+	}
+
+	if c.RateLimit == 0 {
+		c.RateLimit = viper.GetInt(envRateLimit)
+	}
+
+	if processInterval == 0 {
+		processInterval = viper.GetInt(envProcessInterval)
+	}
+	c.ProcessInterval = time.Second * time.Duration(processInterval)
+
+	return c
+}
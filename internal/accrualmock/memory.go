@@ -0,0 +1,111 @@
+package accrualmock
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory keeps the orders and the rewards until the process exits.
+type Memory struct {
+	mx      *sync.Mutex
+	orders  map[string]*Order
+	numbers []string
+	rewards []*Reward
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		mx:     &sync.Mutex{},
+		orders: make(map[string]*Order),
+	}
+}
+
+func (m *Memory) AddOrder(ctx context.Context, o *Order) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if _, ok := m.orders[o.Number]; ok {
+		return ErrOrderExists
+	}
+
+	c := *o
+	m.orders[o.Number] = &c
+	m.numbers = append(m.numbers, o.Number)
+
+	return nil
+}
+
+func (m *Memory) GetOrder(ctx context.Context, number string) (*Order, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	o, ok := m.orders[number]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+
+	c := *o
+	return &c, nil
+}
+
+func (m *Memory) GetOrdersByStatus(ctx context.Context, status string, limit int) ([]*Order, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	var os []*Order
+	for _, n := range m.numbers {
+		if len(os) == limit {
+			break
+		}
+		if o := m.orders[n]; o.Status == status {
+			c := *o
+			os = append(os, &c)
+		}
+	}
+
+	return os, nil
+}
+
+func (m *Memory) UpdateOrder(ctx context.Context, o *Order) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	prev, ok := m.orders[o.Number]
+	if !ok {
+		return ErrOrderNotFound
+	}
+
+	prev.Status = o.Status
+	prev.Accrual = o.Accrual
+
+	return nil
+}
+
+func (m *Memory) AddReward(ctx context.Context, r *Reward) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	for _, rr := range m.rewards {
+		if rr.Match == r.Match {
+			return ErrRewardExists
+		}
+	}
+
+	c := *r
+	m.rewards = append(m.rewards, &c)
+
+	return nil
+}
+
+func (m *Memory) GetRewards(ctx context.Context) ([]*Reward, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	rs := make([]*Reward, 0, len(m.rewards))
+	for _, r := range m.rewards {
+		c := *r
+		rs = append(rs, &c)
+	}
+
+	return rs, nil
+}
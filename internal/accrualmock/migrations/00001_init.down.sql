@@ -0,0 +1,4 @@
+begin transaction;
+drop table rewards;
+drop table orders;
+commit;
\ No newline at end of file
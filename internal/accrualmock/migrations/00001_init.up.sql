@@ -0,0 +1,20 @@
+begin transaction;
+-- Заказы с товарами, начисление рассчитывается в фоне
+create table orders(
+    number varchar(255) not null,
+    registered timestamp with time zone not null,
+    status varchar(16) not null,
+    accrual numeric(18, 2) not null default 0,
+    goods jsonb not null,
+    primary key (number)
+);
+create index orders_status_idx on orders (status, registered);
+-- Вознаграждения за товары, в описании которых встречается match
+create table rewards(
+    seq int generated always as identity,
+    match varchar(255) unique not null,
+    reward numeric(18, 2) not null,
+    rewardtype varchar(2) not null,
+    primary key (seq)
+);
+commit;
\ No newline at end of file
@@ -0,0 +1,189 @@
+package accrualmock
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ArtemShalinFe/gophermart/internal/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationsDir embed.FS
+
+type Postgres struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgres(ctx context.Context, dsn string) (*Postgres, error) {
+	if err := migrate.Up(migrationsDir, "migrations", dsn); err != nil {
+		return nil, fmt.Errorf("failed to run DB migrations: %w", err)
+	}
+
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a connection pool: %w", err)
+	}
+
+	return &Postgres{pool: pool}, nil
+}
+
+func (p *Postgres) Close() {
+	p.pool.Close()
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation
+}
+
+func (p *Postgres) AddOrder(ctx context.Context, o *Order) error {
+	sql := `
+	INSERT INTO orders(number, registered, status, goods)
+	VALUES ($1, $2, $3, $4);`
+
+	goods, err := json.Marshal(o.Goods)
+	if err != nil {
+		return fmt.Errorf("goods marshal to json err: %w", err)
+	}
+
+	if _, err := p.pool.Exec(ctx, sql, o.Number, o.Registered, o.Status, goods); err != nil {
+		if isUniqueViolation(err) {
+			return ErrOrderExists
+		}
+		return fmt.Errorf("db AddOrder err: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) GetOrder(ctx context.Context, number string) (*Order, error) {
+	sql := `
+	SELECT number, registered, status, accrual, goods
+	FROM orders
+	WHERE number = $1;`
+
+	o, err := scanOrder(p.pool.QueryRow(ctx, sql, number))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("db GetOrder err: %w", err)
+	}
+
+	return o, nil
+}
+
+func (p *Postgres) GetOrdersByStatus(ctx context.Context, status string, limit int) ([]*Order, error) {
+	sql := `
+	SELECT number, registered, status, accrual, goods
+	FROM orders
+	WHERE status = $1
+	ORDER BY registered
+	LIMIT $2;`
+
+	rows, err := p.pool.Query(ctx, sql, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("db GetOrdersByStatus err: %w", err)
+	}
+	defer rows.Close()
+
+	var os []*Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("db GetOrdersByStatus row scan err: %w", err)
+		}
+		os = append(os, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db GetOrdersByStatus rows err: %w", err)
+	}
+
+	return os, nil
+}
+
+func scanOrder(row pgx.Row) (*Order, error) {
+	var o Order
+	var goods []byte
+	if err := row.Scan(&o.Number, &o.Registered, &o.Status, &o.Accrual, &goods); err != nil {
+		return nil, fmt.Errorf("order scan err: %w", err)
+	}
+
+	if err := json.Unmarshal(goods, &o.Goods); err != nil {
+		return nil, fmt.Errorf("goods unmarshal err: %w", err)
+	}
+
+	return &o, nil
+}
+
+func (p *Postgres) UpdateOrder(ctx context.Context, o *Order) error {
+	sql := `
+	UPDATE orders
+	SET
+		status = $2,
+		accrual = $3
+	WHERE
+		number = $1;`
+
+	tag, err := p.pool.Exec(ctx, sql, o.Number, o.Status, o.Accrual)
+	if err != nil {
+		return fmt.Errorf("db UpdateOrder err: %w", err)
+	}
+	if tag.RowsAffected() != 1 {
+		return ErrOrderNotFound
+	}
+
+	return nil
+}
+
+func (p *Postgres) AddReward(ctx context.Context, r *Reward) error {
+	sql := `
+	INSERT INTO rewards(match, reward, rewardtype)
+	VALUES ($1, $2, $3);`
+
+	if _, err := p.pool.Exec(ctx, sql, r.Match, r.Reward, r.RewardType); err != nil {
+		if isUniqueViolation(err) {
+			return ErrRewardExists
+		}
+		return fmt.Errorf("db AddReward err: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) GetRewards(ctx context.Context) ([]*Reward, error) {
+	sql := `
+	SELECT match, reward, rewardtype
+	FROM rewards
+	ORDER BY seq;`
+
+	rows, err := p.pool.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("db GetRewards err: %w", err)
+	}
+	defer rows.Close()
+
+	var rs []*Reward
+	for rows.Next() {
+		var r Reward
+		if err := rows.Scan(&r.Match, &r.Reward, &r.RewardType); err != nil {
+			return nil, fmt.Errorf("db GetRewards row scan err: %w", err)
+		}
+		rs = append(rs, &r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db GetRewards rows err: %w", err)
+	}
+
+	return rs, nil
+}
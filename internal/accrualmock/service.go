@@ -0,0 +1,258 @@
+package accrualmock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+const processBatch = 100
+
+type Service struct {
+	store   Storage
+	log     *zap.SugaredLogger
+	limiter *windowLimiter
+	router  *chi.Mux
+}
+
+func NewService(store Storage, log *zap.SugaredLogger, rateLimit int) *Service {
+	s := &Service{
+		store:   store,
+		log:     log,
+		limiter: newWindowLimiter(rateLimit, time.Minute),
+		router:  chi.NewRouter(),
+	}
+
+	s.router.Post("/api/orders", s.addOrder)
+	s.router.Post("/api/goods", s.addReward)
+	s.router.Get("/api/orders/{number}", s.getOrder)
+
+	return s
+}
+
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// Run moves the registered orders to processing and the processing orders to a final status on every tick,
+// so every order is seen in all the statuses.
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Process(ctx); err != nil {
+				s.log.Errorf("failed to process orders err: %v", err)
+			}
+		}
+	}
+}
+
+func (s *Service) Process(ctx context.Context) error {
+	processing, err := s.store.GetOrdersByStatus(ctx, StatusProcessing, processBatch)
+	if err != nil {
+		return fmt.Errorf("get processing orders err: %w", err)
+	}
+
+	if len(processing) > 0 {
+		rs, err := s.store.GetRewards(ctx)
+		if err != nil {
+			return fmt.Errorf("get rewards err: %w", err)
+		}
+
+		for _, o := range processing {
+			o.Status, o.Accrual = calculate(o, rs)
+			if err := s.store.UpdateOrder(ctx, o); err != nil {
+				return fmt.Errorf("update processed order err: %w", err)
+			}
+		}
+	}
+
+	registered, err := s.store.GetOrdersByStatus(ctx, StatusRegistered, processBatch)
+	if err != nil {
+		return fmt.Errorf("get registered orders err: %w", err)
+	}
+
+	for _, o := range registered {
+		o.Status = StatusProcessing
+		if err := s.store.UpdateOrder(ctx, o); err != nil {
+			return fmt.Errorf("update processing order err: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) addOrder(w http.ResponseWriter, r *http.Request) {
+	var o Order
+	if err := readJSON(r, &o); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dto := models.OrderDTO{Number: o.Number}
+	if !dto.NumberIsCorrect() || len(o.Goods) == 0 {
+		http.Error(w, "order number is incorrect or goods are empty", http.StatusBadRequest)
+		return
+	}
+	for _, g := range o.Goods {
+		if g.Price < 0 {
+			http.Error(w, "price must not be negative", http.StatusBadRequest)
+			return
+		}
+	}
+
+	o.Status = StatusRegistered
+	o.Accrual = 0
+	o.Registered = time.Now()
+
+	if err := s.store.AddOrder(r.Context(), &o); err != nil {
+		if errors.Is(err, ErrOrderExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.log.Errorf("failed to add order err: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Service) addReward(w http.ResponseWriter, r *http.Request) {
+	var rw Reward
+	if err := readJSON(r, &rw); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if rw.Match == "" || rw.Reward <= 0 || (rw.RewardType != RewardPercent && rw.RewardType != RewardPoints) {
+		http.Error(w, "match, reward and reward_type % or pt are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.AddReward(r.Context(), &rw); err != nil {
+		if errors.Is(err, ErrRewardExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.log.Errorf("failed to add reward err: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Service) getOrder(w http.ResponseWriter, r *http.Request) {
+	if ok, retryAfter := s.limiter.Allow(); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, fmt.Sprintf("No more than %d requests per minute allowed", s.limiter.limit),
+			http.StatusTooManyRequests)
+		return
+	}
+
+	o, err := s.store.GetOrder(r.Context(), chi.URLParam(r, "number"))
+	if err != nil {
+		if errors.Is(err, ErrOrderNotFound) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.log.Errorf("failed to get order err: %v", err)
+		return
+	}
+
+	resp := struct {
+		Accrual *models.Amount `json:"accrual,omitempty"`
+		Order   string         `json:"order"`
+		Status  string         `json:"status"`
+	}{
+		Order:  o.Number,
+		Status: o.Status,
+	}
+	if o.Status == StatusProcessed && o.Accrual > 0 {
+		resp.Accrual = &o.Accrual
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.log.Errorf("order marshal to json err: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(b); err != nil {
+		s.log.Errorf("failed to write order err: %v", err)
+	}
+}
+
+func readJSON(r *http.Request, v any) error {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read the request body err: %w", err)
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("request body is not a valid JSON err: %w", err)
+	}
+
+	return nil
+}
+
+// windowLimiter allows the limit of requests in every window, zero limit allows all requests.
+type windowLimiter struct {
+	start  time.Time
+	mx     *sync.Mutex
+	window time.Duration
+	limit  int
+	count  int
+}
+
+func newWindowLimiter(limit int, window time.Duration) *windowLimiter {
+	return &windowLimiter{
+		mx:     &sync.Mutex{},
+		limit:  limit,
+		window: window,
+	}
+}
+
+// Allow reports whether the request is allowed and otherwise how long to wait for the next window.
+func (l *windowLimiter) Allow() (bool, time.Duration) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.start) >= l.window {
+		l.start = now
+		l.count = 0
+	}
+
+	if l.count >= l.limit {
+		return false, l.start.Add(l.window).Sub(now)
+	}
+
+	l.count++
+	return true, 0
+}
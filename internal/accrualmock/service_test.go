@@ -0,0 +1,164 @@
+package accrualmock
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// envTestDSN is a separate database, the mock tables would clash with the gophermart ones.
+const envTestDSN = "TEST_ACCRUAL_DATABASE_URI"
+
+func testStorages(t *testing.T) map[string]Storage {
+	t.Helper()
+
+	ss := map[string]Storage{"memory": NewMemory()}
+
+	if dsn := os.Getenv(envTestDSN); dsn != "" {
+		pg, err := NewPostgres(context.Background(), dsn)
+		require.NoError(t, err)
+		t.Cleanup(pg.Close)
+
+		_, err = pg.pool.Exec(context.Background(), "TRUNCATE orders, rewards;")
+		require.NoError(t, err)
+
+		ss["postgres"] = pg
+	}
+
+	return ss
+}
+
+func testDo(t *testing.T, ts *httptest.Server, method string, path string, body string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.URL+path, bytes.NewBufferString(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, string(b)
+}
+
+func TestService(t *testing.T) {
+	for name, store := range testStorages(t) {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewService(store, zap.L().Sugar(), 0)
+			ts := httptest.NewServer(s)
+			defer ts.Close()
+
+			rewards := []struct {
+				body   string
+				status int
+			}{
+				{body: `{"match":"Bork","reward":10,"reward_type":"%"}`, status: http.StatusOK},
+				{body: `{"match":"Samsung","reward":50,"reward_type":"pt"}`, status: http.StatusOK},
+				{body: `{"match":"Bork","reward":5,"reward_type":"pt"}`, status: http.StatusConflict},
+				{body: `{"match":"LG","reward":5,"reward_type":"points"}`, status: http.StatusBadRequest},
+				{body: `{"match":"","reward":5,"reward_type":"pt"}`, status: http.StatusBadRequest},
+			}
+			for _, r := range rewards {
+				resp, body := testDo(t, ts, http.MethodPost, "/api/goods", r.body)
+				require.Equal(t, r.status, resp.StatusCode, r.body+" "+body)
+			}
+
+			orders := []struct {
+				body   string
+				status int
+			}{
+				{
+					body: `{"order":"49927398716","goods":[` +
+						`{"description":"Чайник Bork","price":7000},{"description":"Телевизор Samsung","price":1000}]}`,
+					status: http.StatusAccepted,
+				},
+				{
+					body:   `{"order":"79927398713","goods":[{"description":"Утюг Philips","price":3000}]}`,
+					status: http.StatusAccepted,
+				},
+				{
+					body:   `{"order":"49927398716","goods":[{"description":"Чайник Bork","price":7000}]}`,
+					status: http.StatusConflict,
+				},
+				{
+					body:   `{"order":"49927398717","goods":[{"description":"Чайник Bork","price":7000}]}`,
+					status: http.StatusBadRequest,
+				},
+				{
+					body:   `{"order":"12345678903","goods":[]}`,
+					status: http.StatusBadRequest,
+				},
+			}
+			for _, o := range orders {
+				resp, body := testDo(t, ts, http.MethodPost, "/api/orders", o.body)
+				require.Equal(t, o.status, resp.StatusCode, o.body+" "+body)
+			}
+
+			statuses := []struct {
+				processed string
+				invalid   string
+			}{
+				{
+					processed: `{"order":"49927398716","status":"REGISTERED"}`,
+					invalid:   `{"order":"79927398713","status":"REGISTERED"}`,
+				},
+				{
+					processed: `{"order":"49927398716","status":"PROCESSING"}`,
+					invalid:   `{"order":"79927398713","status":"PROCESSING"}`,
+				},
+				{
+					processed: `{"order":"49927398716","status":"PROCESSED","accrual":750}`,
+					invalid:   `{"order":"79927398713","status":"INVALID"}`,
+				},
+			}
+			for i, st := range statuses {
+				if i > 0 {
+					require.NoError(t, s.Process(ctx))
+				}
+
+				resp, body := testDo(t, ts, http.MethodGet, "/api/orders/49927398716", "")
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				require.JSONEq(t, st.processed, body)
+
+				resp, body = testDo(t, ts, http.MethodGet, "/api/orders/79927398713", "")
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				require.JSONEq(t, st.invalid, body)
+			}
+
+			resp, _ := testDo(t, ts, http.MethodGet, "/api/orders/12345678903", "")
+			require.Equal(t, http.StatusNoContent, resp.StatusCode)
+		})
+	}
+}
+
+func TestService_RateLimit(t *testing.T) {
+	ts := httptest.NewServer(NewService(NewMemory(), zap.L().Sugar(), 2))
+	defer ts.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, _ := testDo(t, ts, http.MethodGet, "/api/orders/49927398716", "")
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	}
+
+	resp, body := testDo(t, ts, http.MethodGet, "/api/orders/49927398716", "")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "No more than 2 requests per minute allowed\n", body)
+
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	require.NoError(t, err)
+	require.True(t, retryAfter > 0 && retryAfter <= 60, retryAfter)
+}
@@ -6,9 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
@@ -20,27 +22,81 @@ import (
 
 type Accrual struct {
 	httpClient *retryablehttp.Client
+	breaker    *circuitBreaker
 	log        *zap.SugaredLogger
 	host       string
 }
 
+// AccrualErrClass tells the accrual loop what to do with the failed request.
+type AccrualErrClass int
+
+const (
+	// AccrualErrTransient is an outage of the accrual system or the network, the request is repeated with a backoff.
+	AccrualErrTransient AccrualErrClass = iota
+	// AccrualErrThrottled asks to repeat the request later, it is not a failure of the order.
+	AccrualErrThrottled
+	// AccrualErrPermanent is an answer about the order that repeating won't change.
+	AccrualErrPermanent
+	// AccrualErrProtocol is a response that breaks the accrual system contract. It may come from a proxy
+	// or a wrong address, so it is counted by the circuit breaker rather than against the order.
+	AccrualErrProtocol
+)
+
+func (c AccrualErrClass) String() string {
+	switch c {
+	case AccrualErrTransient:
+		return "transient"
+	case AccrualErrThrottled:
+		return "throttled"
+	case AccrualErrPermanent:
+		return "permanent"
+	case AccrualErrProtocol:
+		return "protocol"
+	default:
+		return "unknown"
+	}
+}
+
 type AccrualErr struct {
 	error
-	timeout int
+	class      AccrualErrClass
+	retryAfter time.Duration
 }
 
+// The client retries only short failures, the accrual loop retries the rest with its own backoff
+// and the whole job must fit into its timeout.
+const (
+	accrualRetryMax     = 3
+	accrualRetryWaitMin = 100 * time.Millisecond
+	accrualRetryWaitMax = time.Second
+)
+
+// accrualMaxRetryAfter bounds the pause the accrual system may ask for.
+const accrualMaxRetryAfter = time.Hour
+
+const retryAfterHeader = "Retry-After"
+
 var errOrderNotRegistered = errors.New("the order is not registered in the payment system")
 var errTooManyRequests = errors.New("too many requests")
+var errCircuitOpen = errors.New("the circuit to the accrual system is open")
+var errInvalidResponse = errors.New("invalid accrual system response")
+var errServiceUnavailable = errors.New("the accrual system is unavailable")
 
-func newAccrualErr(err error, timeout int) *AccrualErr {
+func newAccrualErr(class AccrualErrClass, err error, retryAfter time.Duration) *AccrualErr {
 	return &AccrualErr{
-		error:   err,
-		timeout: timeout,
+		error:      err,
+		class:      class,
+		retryAfter: retryAfter,
 	}
 }
 
-func (ae *AccrualErr) TimeoutSec() (int, bool) {
-	return ae.timeout, ae.timeout > 0
+func (ae *AccrualErr) Class() AccrualErrClass {
+	return ae.class
+}
+
+// RetryAfter returns the time the accrual system asked to wait before the next request.
+func (ae *AccrualErr) RetryAfter() (time.Duration, bool) {
+	return ae.retryAfter, ae.retryAfter > 0
 }
 
 func (ae *AccrualErr) IsOrderNotRegistered() bool {
@@ -51,20 +107,93 @@ func (ae *AccrualErr) IsTooManyRequests() bool {
 	return errors.Is(ae.error, errTooManyRequests)
 }
 
+func (ae *AccrualErr) IsCircuitOpen() bool {
+	return errors.Is(ae.error, errCircuitOpen)
+}
+
+// IsInvalidResponse reports that the accrual system answered with a body that breaks its contract.
+func (ae *AccrualErr) IsInvalidResponse() bool {
+	return errors.Is(ae.error, errInvalidResponse)
+}
+
 func NewAccrualClient(cfg config.Config, log *zap.SugaredLogger) *Accrual {
 	client := retryablehttp.NewClient()
-	client.RetryMax = 3
-	client.CheckRetry = checkRetry
+	client.HTTPClient = newHTTPClient(cfg.AccrualConnectTimeout, cfg.AccrualReadTimeout)
+	client.RetryMax = accrualRetryMax
+	client.RetryWaitMin = accrualRetryWaitMin
+	client.RetryWaitMax = accrualRetryWaitMax
 	client.Backoff = backoff
+	// The last response is classified by GetOrderAccrual instead of the "giving up" error.
+	client.ErrorHandler = retryablehttp.PassthroughErrorHandler
 
-	return &Accrual{
+	a := &Accrual{
 		host:       cfg.Accrual,
 		log:        log,
 		httpClient: client,
 	}
+	a.breaker = newCircuitBreaker(cfg.AccrualBreakerFailures, cfg.AccrualBreakerOpenTimeout, func(state string) {
+		log.Infof("accrual circuit breaker is %s", state)
+	})
+	client.CheckRetry = a.checkRetry
+
+	return a
+}
+
+// newHTTPClient limits the time to connect and the time to get the whole response of one attempt,
+// zero means no limit.
+func newHTTPClient(connectTimeout, readTimeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+	transport.ResponseHeaderTimeout = readTimeout
+
+	client := &http.Client{Transport: transport}
+	if connectTimeout > 0 && readTimeout > 0 {
+		client.Timeout = connectTimeout + readTimeout
+	}
+	return client
+}
+
+// Budget returns the longest time GetOrderAccrual may take with all the attempts and the pauses between them,
+// it is zero when the time of an attempt is not limited.
+func (a *Accrual) Budget() time.Duration {
+	attempt := a.httpClient.HTTPClient.Timeout
+	if attempt == 0 {
+		return 0
+	}
+
+	d := time.Duration(a.httpClient.RetryMax+1) * attempt
+	// LinearJitterBackoff waits up to the attempt number times the maximum wait.
+	for i := 1; i <= a.httpClient.RetryMax; i++ {
+		d += time.Duration(i) * a.httpClient.RetryWaitMax
+	}
+	return d
 }
 
-func checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+// BreakerState returns the state of the circuit to the accrual system: closed, open or half-open.
+func (a *Accrual) BreakerState() string {
+	return a.breaker.State()
+}
+
+// checkRetry counts every attempt in the circuit breaker and stops the retries once the circuit is open.
+// Responses with Retry-After are left to the caller, the accrual loop pauses all the workers for that time.
+// A 200 response is counted by GetOrderAccrual once its body is checked.
+func (a *Accrual) checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if ctx.Err() == nil && (err != nil || resp == nil || resp.StatusCode != http.StatusOK) {
+		a.breaker.Record(isFailure(resp, err))
+	}
+
+	if err == nil && resp != nil && isThrottled(resp) {
+		return false, nil
+	}
+
+	if a.breaker.State() == BreakerOpen {
+		return false, nil
+	}
+
 	check, err := retryablehttp.DefaultRetryPolicy(ctx, resp, err)
 	if err != nil {
 		return false, fmt.Errorf("accrual error in default retry policy : %w", err)
@@ -72,52 +201,146 @@ func checkRetry(ctx context.Context, resp *http.Response, err error) (bool, erro
 	return check, nil
 }
 
+// isFailure tells whether the attempt shows that the accrual system is unavailable or doesn't answer by its contract.
+func isFailure(resp *http.Response, err error) bool {
+	if err != nil || resp == nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusTooManyRequests:
+		return false
+	default:
+		return true
+	}
+}
+
+func isThrottled(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get(retryAfterHeader) != ""
+}
+
 func backoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
 	return retryablehttp.LinearJitterBackoff(min, max, attemptNum, resp)
 }
 
+// parseRetryAfter parses Retry-After of RFC 9110: delay-seconds or HTTP-date.
+// A date in the past means the request may be repeated right away.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+
+	if strings.Trim(v, "0123456789") == "" {
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || sec > int64(accrualMaxRetryAfter/time.Second) {
+			return accrualMaxRetryAfter, true
+		}
+		return time.Duration(sec) * time.Second, true
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+
+	d := t.Sub(now)
+	if d < 0 {
+		return 0, true
+	}
+	if d > accrualMaxRetryAfter {
+		d = accrualMaxRetryAfter
+	}
+	return d, true
+}
+
 func (a *Accrual) GetOrderAccrual(ctx context.Context, order *models.Order) (*models.OrderAccrual, *AccrualErr) {
+	if ok, wait := a.breaker.Allow(); !ok {
+		return nil, newAccrualErr(AccrualErrThrottled, errCircuitOpen, wait)
+	}
+
 	req, err := a.request(ctx, order)
 	if err != nil {
-		return nil, newAccrualErr(fmt.Errorf("failed prepare accrual request err: %w", err), 0)
+		return nil, newAccrualErr(AccrualErrTransient, fmt.Errorf("failed prepare accrual request err: %w", err), 0)
 	}
 
 	resp, err := a.httpClient.Do(req)
 	if err != nil {
-		return nil, newAccrualErr(fmt.Errorf("failed exec accrual request err: %w", err), 0)
+		return nil, newAccrualErr(AccrualErrTransient, fmt.Errorf("failed exec accrual request err: %w", err), 0)
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			a.log.Errorf("closing body was failed err: %w", err)
+			a.log.Errorf("closing body was failed err: %v", err)
 		}
 	}()
 
-	if resp.StatusCode == http.StatusNoContent {
-		return nil, newAccrualErr(errOrderNotRegistered, 0)
+	if aerr := classifyStatus(resp); aerr != nil {
+		return nil, aerr
 	}
 
-	if resp.StatusCode == http.StatusTooManyRequests {
-		retryAfterString := resp.Header.Get("Retry-After")
-		retryAfter, err := strconv.Atoi(retryAfterString)
-		if err != nil {
-			return nil, newAccrualErr(fmt.Errorf("parse value Retry-After err: %w", err), 0)
+	res, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() == nil {
+			a.breaker.Record(true)
 		}
-		return nil, newAccrualErr(errTooManyRequests, retryAfter)
+		return nil, newAccrualErr(AccrualErrTransient,
+			fmt.Errorf("failed reading response body %s err: %w", string(res), err), 0)
 	}
 
-	res, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, newAccrualErr(fmt.Errorf("failed reading response body %s err: %w", string(res), err), 0)
+	oa, aerr := parseOrderAccrual(res, order.Number)
+	a.breaker.Record(aerr != nil && aerr.Class() == AccrualErrProtocol)
+	if aerr != nil {
+		return nil, aerr
 	}
 
+	return oa, nil
+}
+
+func parseOrderAccrual(b []byte, number string) (*models.OrderAccrual, *AccrualErr) {
 	var oa models.OrderAccrual
-	if err := json.Unmarshal(res, &oa); err != nil {
-		return nil, newAccrualErr(fmt.Errorf("failed unmarshal response body %s err: %w", string(res), err), 0)
+	if err := json.Unmarshal(b, &oa); err != nil {
+		return nil, newAccrualErr(AccrualErrProtocol, fmt.Errorf("%w: failed unmarshal response body %s err: %w",
+			errInvalidResponse, string(b), err), 0)
+	}
+
+	if err := oa.Validate(number); err != nil {
+		class := AccrualErrProtocol
+		if errors.Is(err, models.ErrAccrualOrderMismatch) {
+			class = AccrualErrPermanent
+		}
+		return nil, newAccrualErr(class, fmt.Errorf("%w: %s err: %w", errInvalidResponse, string(b), err), 0)
 	}
+	oa.Raw = b
 
 	return &oa, nil
 }
 
+// classifyStatus returns the error for any status but 200.
+func classifyStatus(resp *http.Response) *AccrualErr {
+	status := resp.StatusCode
+	switch {
+	case status == http.StatusOK:
+		return nil
+	case status == http.StatusNoContent:
+		return newAccrualErr(AccrualErrTransient, errOrderNotRegistered, 0)
+	case status == http.StatusTooManyRequests:
+		retryAfter, _ := parseRetryAfter(resp.Header.Get(retryAfterHeader), time.Now())
+		return newAccrualErr(AccrualErrThrottled, errTooManyRequests, retryAfter)
+	case status == http.StatusServiceUnavailable:
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get(retryAfterHeader), time.Now()); ok {
+			return newAccrualErr(AccrualErrThrottled, errServiceUnavailable, retryAfter)
+		}
+		return newAccrualErr(AccrualErrTransient, fmt.Errorf("%w: status %d", errServiceUnavailable, status), 0)
+	case status >= http.StatusInternalServerError:
+		return newAccrualErr(AccrualErrTransient, fmt.Errorf("%w: status %d", errServiceUnavailable, status), 0)
+	case status == http.StatusRequestTimeout:
+		return newAccrualErr(AccrualErrTransient, fmt.Errorf("%w: status %d", errServiceUnavailable, status), 0)
+	default:
+		return newAccrualErr(AccrualErrProtocol, fmt.Errorf("%w: unexpected status %d", errInvalidResponse, status), 0)
+	}
+}
+
 func (a *Accrual) request(ctx context.Context, order *models.Order) (*retryablehttp.Request, error) {
 	url, err := url.JoinPath(a.host, "/api/orders/", order.Number)
 	if err != nil {
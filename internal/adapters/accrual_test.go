@@ -0,0 +1,373 @@
+package adapters
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/gophermart/internal/accrualfake"
+	"github.com/ArtemShalinFe/gophermart/internal/config"
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestAccrual_GetOrderAccrual(t *testing.T) {
+	fake, ts := accrualfake.NewServer()
+	defer ts.Close()
+
+	a := NewAccrualClient(config.Config{Accrual: ts.URL}, zap.L().Sugar())
+	ctx := context.Background()
+
+	fake.Script("49927398716", accrualfake.Processed(72998))
+	oa, aerr := a.GetOrderAccrual(ctx, &models.Order{Number: "49927398716"})
+	require.Nil(t, aerr)
+	require.Equal(t, accrualfake.StatusProcessed, oa.Status)
+	require.Equal(t, models.Amount(72998), oa.Accrual)
+
+	_, aerr = a.GetOrderAccrual(ctx, &models.Order{Number: "79927398713"})
+	require.NotNil(t, aerr)
+	require.True(t, aerr.IsOrderNotRegistered())
+
+	fake.Script("12345678903", accrualfake.TooManyRequests("7"))
+	_, aerr = a.GetOrderAccrual(ctx, &models.Order{Number: "12345678903"})
+	require.NotNil(t, aerr)
+	require.True(t, aerr.IsTooManyRequests())
+	retryAfter, ok := aerr.RetryAfter()
+	require.True(t, ok)
+	require.Equal(t, 7*time.Second, retryAfter)
+	require.Equal(t, 1, fake.Calls("12345678903"), "429 must not be retried by the client")
+}
+
+func TestAccrual_GetOrderAccrualInvalidResponse(t *testing.T) {
+	fake, ts := accrualfake.NewServer()
+	defer ts.Close()
+
+	a := NewAccrualClient(config.Config{Accrual: ts.URL}, zap.L().Sugar())
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{
+			name: "another order",
+			body: `{"order":"79927398713","status":"PROCESSED","accrual":500}`,
+		},
+		{
+			name: "negative accrual",
+			body: `{"order":"49927398716","status":"PROCESSED","accrual":-500}`,
+		},
+		{
+			name: "accrual out of range",
+			body: `{"order":"49927398716","status":"PROCESSED","accrual":1e400}`,
+		},
+		{
+			name: "unknown status",
+			body: `{"order":"49927398716","status":"DONE"}`,
+		},
+		{
+			name: "not a json",
+			body: `PROCESSED`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.Script("49927398716", accrualfake.Step{Body: tt.body})
+
+			_, aerr := a.GetOrderAccrual(context.Background(), &models.Order{Number: "49927398716"})
+			require.NotNil(t, aerr)
+			require.True(t, aerr.IsInvalidResponse(), aerr.Error())
+		})
+	}
+}
+
+func TestAccrual_GetOrderAccrualErrClass(t *testing.T) {
+	fake, ts := accrualfake.NewServer()
+	defer ts.Close()
+
+	cfg := config.Config{Accrual: ts.URL, AccrualConnectTimeout: time.Second, AccrualReadTimeout: 100 * time.Millisecond}
+	a := NewAccrualClient(cfg, zap.L().Sugar())
+	a.httpClient.RetryWaitMin = time.Millisecond
+	a.httpClient.RetryWaitMax = 5 * time.Millisecond
+
+	tests := []struct {
+		name           string
+		step           accrualfake.Step
+		want           AccrualErrClass
+		wantRetryAfter time.Duration
+	}{
+		{
+			name: "not registered",
+			step: accrualfake.NotRegistered(),
+			want: AccrualErrTransient,
+		},
+		{
+			name: "internal error",
+			step: accrualfake.InternalError(),
+			want: AccrualErrTransient,
+		},
+		{
+			name: "unavailable",
+			step: accrualfake.Step{Code: http.StatusServiceUnavailable},
+			want: AccrualErrTransient,
+		},
+		{
+			name: "read timeout",
+			step: accrualfake.Slow(time.Second, accrualfake.Processed(100)),
+			want: AccrualErrTransient,
+		},
+		{
+			name:           "too many requests",
+			step:           accrualfake.TooManyRequests("60"),
+			want:           AccrualErrThrottled,
+			wantRetryAfter: time.Minute,
+		},
+		{
+			name: "too many requests without Retry-After",
+			step: accrualfake.TooManyRequests(""),
+			want: AccrualErrThrottled,
+		},
+		{
+			name: "too many requests with invalid Retry-After",
+			step: accrualfake.TooManyRequests("soon"),
+			want: AccrualErrThrottled,
+		},
+		{
+			name:           "unavailable with Retry-After",
+			step:           accrualfake.Step{Code: http.StatusServiceUnavailable, RetryAfter: "120"},
+			want:           AccrualErrThrottled,
+			wantRetryAfter: 2 * time.Minute,
+		},
+		{
+			name: "request timeout",
+			step: accrualfake.Step{Code: http.StatusRequestTimeout},
+			want: AccrualErrTransient,
+		},
+		{
+			name: "not found",
+			step: accrualfake.Step{Code: http.StatusNotFound},
+			want: AccrualErrProtocol,
+		},
+		{
+			name: "bad request",
+			step: accrualfake.Step{Code: http.StatusBadRequest},
+			want: AccrualErrProtocol,
+		},
+		{
+			name: "unexpected status",
+			step: accrualfake.Step{Code: http.StatusAccepted},
+			want: AccrualErrProtocol,
+		},
+		{
+			name: "unexpected client error",
+			step: accrualfake.Step{Code: http.StatusTeapot},
+			want: AccrualErrProtocol,
+		},
+		{
+			name: "invalid body",
+			step: accrualfake.Step{Body: `{"order":"49927398716","status":"DONE"}`},
+			want: AccrualErrProtocol,
+		},
+		{
+			name: "another order",
+			step: accrualfake.Step{Body: `{"order":"79927398713","status":"PROCESSED","accrual":500}`},
+			want: AccrualErrPermanent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.Script("49927398716", tt.step)
+
+			_, aerr := a.GetOrderAccrual(context.Background(), &models.Order{Number: "49927398716"})
+			require.NotNil(t, aerr)
+			require.Equal(t, tt.want, aerr.Class(), aerr.Error())
+
+			retryAfter, _ := aerr.RetryAfter()
+			require.Equal(t, tt.wantRetryAfter, retryAfter)
+		})
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	tests := []struct {
+		name           string
+		connectTimeout time.Duration
+		readTimeout    time.Duration
+		want           time.Duration
+	}{
+		{
+			name:           "both timeouts",
+			connectTimeout: 5 * time.Second,
+			readTimeout:    10 * time.Second,
+			want:           15 * time.Second,
+		},
+		{
+			name:        "no connect timeout",
+			readTimeout: 10 * time.Second,
+		},
+		{
+			name: "no timeouts",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newHTTPClient(tt.connectTimeout, tt.readTimeout)
+			require.Equal(t, tt.want, c.Timeout)
+
+			transport, ok := c.Transport.(*http.Transport)
+			require.True(t, ok)
+			require.Equal(t, tt.connectTimeout, transport.TLSHandshakeTimeout)
+			require.Equal(t, tt.readTimeout, transport.ResponseHeaderTimeout)
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{name: "seconds", value: "120", want: 2 * time.Minute, wantOk: true},
+		{name: "zero seconds", value: "0", want: 0, wantOk: true},
+		{name: "seconds with spaces", value: " 5 ", want: 5 * time.Second, wantOk: true},
+		{name: "seconds beyond the limit", value: "86400", want: time.Hour, wantOk: true},
+		{name: "seconds overflow", value: "99999999999999999999", want: time.Hour, wantOk: true},
+		{name: "IMF-fixdate", value: "Wed, 21 Oct 2015 07:30:00 GMT", want: 2 * time.Minute, wantOk: true},
+		{name: "RFC 850 date", value: "Wednesday, 21-Oct-15 07:29:00 GMT", want: time.Minute, wantOk: true},
+		{name: "asctime date", value: "Wed Oct 21 07:28:30 2015", want: 30 * time.Second, wantOk: true},
+		{name: "date in the past", value: "Wed, 21 Oct 2015 07:00:00 GMT", want: 0, wantOk: true},
+		{name: "date beyond the limit", value: "Thu, 22 Oct 2015 07:28:00 GMT", want: time.Hour, wantOk: true},
+		{name: "empty", value: ""},
+		{name: "negative seconds", value: "-1"},
+		{name: "fractional seconds", value: "1.5"},
+		{name: "garbage", value: "soon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.value, now)
+			require.Equal(t, tt.wantOk, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAccrual_CircuitBreaker(t *testing.T) {
+	fake, ts := accrualfake.NewServer()
+	defer ts.Close()
+
+	cfg := config.Config{Accrual: ts.URL, AccrualBreakerFailures: 3, AccrualBreakerOpenTimeout: time.Minute}
+	a := NewAccrualClient(cfg, zap.L().Sugar())
+	a.httpClient.RetryWaitMin = time.Millisecond
+	a.httpClient.RetryWaitMax = 5 * time.Millisecond
+
+	now := time.Now()
+	a.breaker.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	const number = "49927398716"
+	order := &models.Order{Number: number}
+
+	fake.Script(number,
+		accrualfake.InternalError(), accrualfake.InternalError(), accrualfake.InternalError(),
+		accrualfake.InternalError(),
+		accrualfake.Processed(100))
+
+	_, aerr := a.GetOrderAccrual(ctx, order)
+	require.NotNil(t, aerr)
+	require.False(t, aerr.IsCircuitOpen())
+	require.Equal(t, BreakerOpen, a.BreakerState())
+	require.Equal(t, 3, fake.Calls(number), "the retries must stop once the circuit is open")
+
+	_, aerr = a.GetOrderAccrual(ctx, order)
+	require.NotNil(t, aerr)
+	require.True(t, aerr.IsCircuitOpen())
+	retryAfter, ok := aerr.RetryAfter()
+	require.True(t, ok)
+	require.Equal(t, time.Minute, retryAfter)
+	require.Equal(t, 3, fake.Calls(number), "no request must be sent while the circuit is open")
+
+	now = now.Add(time.Minute)
+	_, aerr = a.GetOrderAccrual(ctx, order)
+	require.NotNil(t, aerr)
+	require.False(t, aerr.IsCircuitOpen())
+	require.Equal(t, BreakerOpen, a.BreakerState(), "a failed probe must open the circuit again")
+	require.Equal(t, 4, fake.Calls(number), "a failed probe must not be retried")
+
+	now = now.Add(30 * time.Second)
+	_, aerr = a.GetOrderAccrual(ctx, order)
+	require.NotNil(t, aerr)
+	require.True(t, aerr.IsCircuitOpen())
+
+	now = now.Add(30 * time.Second)
+	oa, aerr := a.GetOrderAccrual(ctx, order)
+	require.Nil(t, aerr)
+	require.Equal(t, models.Amount(100), oa.Accrual)
+	require.Equal(t, BreakerClosed, a.BreakerState())
+	require.Equal(t, 5, fake.Calls(number))
+}
+
+func TestAccrual_CircuitBreakerProtocol(t *testing.T) {
+	fake, ts := accrualfake.NewServer()
+	defer ts.Close()
+
+	cfg := config.Config{Accrual: ts.URL, AccrualBreakerFailures: 2, AccrualBreakerOpenTimeout: time.Minute}
+	a := NewAccrualClient(cfg, zap.L().Sugar())
+
+	const number = "49927398716"
+	order := &models.Order{Number: number}
+
+	tests := []struct {
+		name      string
+		step      accrualfake.Step
+		wantClass AccrualErrClass
+		wantState string
+	}{
+		{
+			name:      "status out of the contract",
+			step:      accrualfake.Step{Code: http.StatusNotFound},
+			wantClass: AccrualErrProtocol,
+			wantState: BreakerOpen,
+		},
+		{
+			name:      "invalid body",
+			step:      accrualfake.Step{Body: `{"order":"49927398716","status":"DONE"}`},
+			wantClass: AccrualErrProtocol,
+			wantState: BreakerOpen,
+		},
+		{
+			name:      "another order",
+			step:      accrualfake.Step{Body: `{"order":"79927398713","status":"PROCESSED","accrual":500}`},
+			wantClass: AccrualErrPermanent,
+			wantState: BreakerClosed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.breaker = newCircuitBreaker(cfg.AccrualBreakerFailures, cfg.AccrualBreakerOpenTimeout, nil)
+			fake.Script(number, tt.step)
+
+			for i := 0; i < cfg.AccrualBreakerFailures; i++ {
+				_, aerr := a.GetOrderAccrual(context.Background(), order)
+				require.NotNil(t, aerr)
+				require.Equal(t, tt.wantClass, aerr.Class(), aerr.Error())
+			}
+			require.Equal(t, tt.wantState, a.BreakerState())
+		})
+	}
+}
+
+func TestAccrual_Budget(t *testing.T) {
+	a := NewAccrualClient(config.Config{}, zap.L().Sugar())
+	require.Zero(t, a.Budget(), "the attempts are not limited")
+
+	a = NewAccrualClient(config.Config{AccrualConnectTimeout: 5 * time.Second, AccrualReadTimeout: 10 * time.Second},
+		zap.L().Sugar())
+	// 4 attempts of 15 seconds and the pauses of up to 1, 2 and 3 seconds between them.
+	require.Equal(t, 66*time.Second, a.Budget())
+}
@@ -0,0 +1,102 @@
+package adapters
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// circuitBreaker stops the requests to the accrual system after the threshold of failures in a row.
+// When the open timeout passes one probe request is let through: its success closes the breaker,
+// its failure opens it again. A probe without an outcome expires after the same timeout.
+type circuitBreaker struct {
+	changed     time.Time
+	now         func() time.Time
+	onChange    func(state string)
+	mx          *sync.Mutex
+	state       string
+	openTimeout time.Duration
+	threshold   int
+	failures    int
+}
+
+func newCircuitBreaker(threshold int, openTimeout time.Duration, onChange func(state string)) *circuitBreaker {
+	return &circuitBreaker{
+		mx:          &sync.Mutex{},
+		state:       BreakerClosed,
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		now:         time.Now,
+		onChange:    onChange,
+	}
+}
+
+// Allow reports whether a request may be sent and otherwise how long the breaker stays open.
+func (b *circuitBreaker) Allow() (bool, time.Duration) {
+	if b.threshold <= 0 {
+		return true, 0
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.state == BreakerClosed {
+		return true, 0
+	}
+
+	now := b.now()
+	if wait := b.changed.Add(b.openTimeout).Sub(now); wait > 0 {
+		return false, wait
+	}
+
+	// The probe is let through, the others wait for its outcome.
+	b.setState(BreakerHalfOpen, now)
+	return true, 0
+}
+
+// Record counts the outcome of a request.
+func (b *circuitBreaker) Record(failure bool) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if !failure {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.setState(BreakerClosed, b.now())
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.setState(BreakerOpen, b.now())
+	}
+}
+
+func (b *circuitBreaker) State() string {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return b.state
+}
+
+func (b *circuitBreaker) setState(state string, now time.Time) {
+	b.changed = now
+	if b.state == state {
+		return
+	}
+
+	b.state = state
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
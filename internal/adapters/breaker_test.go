@@ -0,0 +1,66 @@
+package adapters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var states []string
+	b := newCircuitBreaker(2, time.Minute, func(state string) {
+		states = append(states, state)
+	})
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	allow := func() bool {
+		ok, _ := b.Allow()
+		return ok
+	}
+
+	require.True(t, allow())
+	b.Record(true)
+	b.Record(false)
+	b.Record(true)
+	require.Equal(t, BreakerClosed, b.State(), "a success must reset the failures in a row")
+
+	b.Record(true)
+	require.Equal(t, BreakerOpen, b.State())
+
+	ok, wait := b.Allow()
+	require.False(t, ok)
+	require.Equal(t, time.Minute, wait)
+
+	now = now.Add(time.Minute)
+	require.True(t, allow())
+	require.Equal(t, BreakerHalfOpen, b.State())
+	require.False(t, allow(), "only one probe is let through in the half-open state")
+
+	b.Record(true)
+	require.Equal(t, BreakerOpen, b.State())
+	require.False(t, allow())
+
+	now = now.Add(time.Minute)
+	require.True(t, allow())
+	now = now.Add(time.Minute)
+	require.True(t, allow(), "a probe without an outcome must expire")
+
+	b.Record(false)
+	require.Equal(t, BreakerClosed, b.State())
+	require.True(t, allow())
+
+	require.Equal(t, []string{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}, states)
+}
+
+func TestCircuitBreaker_Disabled(t *testing.T) {
+	b := newCircuitBreaker(-1, time.Minute, nil)
+	for i := 0; i < 10; i++ {
+		b.Record(true)
+	}
+
+	ok, _ := b.Allow()
+	require.True(t, ok)
+	require.Equal(t, BreakerClosed, b.State())
+}
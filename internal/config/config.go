@@ -1,7 +1,9 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/spf13/pflag"
@@ -9,12 +11,41 @@ import (
 )
 
 type Config struct {
-	Address         string
-	Accrual         string
-	DSN             string
-	Key             []byte
-	AccrualInterval int
-	TokenExp        time.Duration
+	Address                   string
+	Accrual                   string
+	DSN                       string
+	Key                       []byte
+	JWTKeysDir                string
+	JWTSigningKeyID           string
+	AccrualInterval           int
+	AccrualWorkers            int
+	AccrualRateLimit          int
+	AccrualMaxAttempts        int
+	AccrualMaxAge             time.Duration
+	AccrualBreakerFailures    int
+	AccrualBreakerOpenTimeout time.Duration
+	AccrualConnectTimeout     time.Duration
+	AccrualReadTimeout        time.Duration
+	TokenExp                  time.Duration
+	RefreshTokenExp           time.Duration
+	IdempotencyTTL            time.Duration
+	UserCacheSize             int
+	LoginMaxFailures          int
+	LoginMaxIPFailures        int
+	LoginMaxLockout           time.Duration
+	AdminKey                  string
+	TrustedProxies            string
+	PasswordHash              string
+	PasswordDenyList          string
+	PasswordMinLength         int
+	PasswordMaxLength         int
+	PasswordResetExp          time.Duration
+	PasswordResetFile         string
+	MaxRequestBody            int64
+	// Dev allows the settings that are only safe on a developer machine, like the default secret key.
+	Dev bool
+	// Warnings are the problems of the configuration the service can start with, they are logged at startup.
+	Warnings []string
 }
 
 const envAddress = "RUN_ADDRESS"
@@ -22,26 +53,122 @@ const envDSN = "DATABASE_URI"
 const envAccrualAddress = "ACCRUAL_SYSTEM_ADDRESS"
 const envSecretKey = "KEY"
 const envAccrualInterval = "ACCRUAL_INTERVAL_SECOND"
-const envTokenExp = "JWT_TOKEN_EXP"
+const envTokenExp = "JWT_TOKEN_EXP_MINUTE"
 
-func GetConfig() *Config {
+// Deprecated: envDeprecatedTokenExp is the access token expiration in hours, use envTokenExp.
+const envDeprecatedTokenExp = "JWT_TOKEN_EXP"
+const envRefreshTokenExp = "JWT_REFRESH_TOKEN_EXP_HOUR"
+const envAccrualWorkers = "ACCRUAL_WORKERS"
+const envAccrualRateLimit = "ACCRUAL_RATE_LIMIT"
+const envAccrualMaxAttempts = "ACCRUAL_MAX_ATTEMPTS"
+const envAccrualMaxAge = "ACCRUAL_MAX_AGE_HOUR"
+const envAccrualBreakerFailures = "ACCRUAL_BREAKER_FAILURES"
+const envAccrualBreakerOpenTimeout = "ACCRUAL_BREAKER_OPEN_SECOND"
+const envAccrualConnectTimeout = "ACCRUAL_CONNECT_TIMEOUT_SECOND"
+const envAccrualReadTimeout = "ACCRUAL_READ_TIMEOUT_SECOND"
+const envIdempotencyTTL = "IDEMPOTENCY_KEY_TTL_HOUR"
+const envUserCacheSize = "USER_CACHE_SIZE"
+const envJWTKeysDir = "JWT_KEYS_DIR"
+const envJWTSigningKeyID = "JWT_SIGNING_KEY_ID"
+const envLoginMaxFailures = "LOGIN_MAX_FAILURES"
+const envLoginMaxIPFailures = "LOGIN_MAX_IP_FAILURES"
+const envLoginMaxLockout = "LOGIN_MAX_LOCKOUT_MINUTE"
+const envAdminKey = "ADMIN_KEY"
+const envTrustedProxies = "TRUSTED_PROXIES"
+const envPasswordHash = "PASSWORD_HASH_ALGORITHM"
+const envPasswordDenyList = "PASSWORD_DENY_LIST_FILE"
+const envPasswordMinLength = "PASSWORD_MIN_LENGTH"
+const envPasswordMaxLength = "PASSWORD_MAX_LENGTH"
+const envPasswordResetExp = "PASSWORD_RESET_TOKEN_EXP_MINUTE"
+const envPasswordResetFile = "PASSWORD_RESET_NOTIFY_FILE"
+const envMaxRequestBody = "MAX_REQUEST_BODY_KB"
+const envDev = "DEV_MODE"
+
+var ErrInvalidConfig = errors.New("invalid config")
+
+func GetConfig() (*Config, error) {
 	c := &Config{}
 
 	var key string
 	var tokExp int
+	var refreshTokExp int
+	var accMaxAge int
+	var accBreakerOpenTimeout int
+	var accConnectTimeout int
+	var accReadTimeout int
+	var idempotencyTTL int
+	var loginMaxLockout int
+	var passwordResetExp int
+	var maxRequestBody int
 	pflag.StringVarP(&c.Address, "address", "a", "", "Gophermart address and port")
 	pflag.StringVarP(&c.Accrual, "accrual", "r", "", "Accrual address and port")
 	pflag.IntVarP(&c.AccrualInterval, "accrualInterval", "i", 0, "This is timeout between requests to the accrual service")
+	pflag.IntVarP(&c.AccrualWorkers, "accrualWorkers", "w", 0, "Number of concurrent requests to the accrual service")
+	pflag.IntVarP(&c.AccrualRateLimit, "accrualRateLimit", "l", 0,
+		"Maximum number of requests per second to the accrual service, 0 means unlimited")
+	pflag.IntVar(&c.AccrualMaxAttempts, "accrualMaxAttempts", 0,
+		"Number of attempts after which an order unknown to the accrual service becomes INVALID")
+	pflag.IntVar(&accMaxAge, "accrualMaxAge", 0,
+		"Age in hours after which an order unknown to the accrual service becomes INVALID")
+	pflag.IntVar(&c.AccrualBreakerFailures, "accrualBreakerFailures", 0,
+		"Number of failed requests in a row that opens the circuit to the accrual service, -1 disables the breaker")
+	pflag.IntVar(&accBreakerOpenTimeout, "accrualBreakerOpenTimeout", 0,
+		"Time in seconds the circuit to the accrual service stays open before a probe request")
+	pflag.IntVar(&accConnectTimeout, "accrualConnectTimeout", 0, "Timeout in seconds to connect to the accrual service")
+	pflag.IntVar(&accReadTimeout, "accrualReadTimeout", 0,
+		"Timeout in seconds to get the response of the accrual service after the connection")
 	pflag.StringVarP(&c.DSN, "dsn", "d", "", "Postgresql DSN string")
 	pflag.StringVarP(&key, "key", "k", "", "Secret key")
-	pflag.IntVarP(&tokExp, "tokenExpiration", "t", 0, "jwt token expiration")
+	pflag.StringVar(&c.JWTKeysDir, "jwtKeysDir", "",
+		"Directory with RSA or Ed25519 PEM keys for JWT, the secret key is used when it is empty")
+	pflag.StringVar(&c.JWTSigningKeyID, "jwtSigningKeyID", "", "kid of the key that signs new JWT")
+	pflag.IntVarP(&tokExp, "tokenExpiration", "t", 0, "jwt access token expiration in minutes")
+	pflag.IntVar(&refreshTokExp, "refreshTokenExpiration", 0, "refresh token expiration in hours")
+	pflag.IntVar(&idempotencyTTL, "idempotencyTTL", 0, "Idempotency-Key lifetime in hours")
+	pflag.IntVar(&c.UserCacheSize, "userCacheSize", 0,
+		"Number of users cached by the JWT middleware, 0 disables the cache")
+	pflag.IntVar(&c.LoginMaxFailures, "loginMaxFailures", 0,
+		"Number of failed logins in a row after which the login is locked out")
+	pflag.IntVar(&c.LoginMaxIPFailures, "loginMaxIPFailures", 0,
+		"Number of failed logins in a row after which the client IP address is locked out")
+	pflag.IntVar(&loginMaxLockout, "loginMaxLockout", 0, "Maximum login lockout in minutes")
+	pflag.StringVar(&c.AdminKey, "adminKey", "", "Key of the admin API, the admin API is disabled when it is empty")
+	pflag.StringVar(&c.TrustedProxies, "trustedProxies", "",
+		"Comma separated addresses or CIDR networks of the proxies whose X-Forwarded-For and X-Real-IP are trusted")
+	pflag.StringVar(&c.PasswordHash, "passwordHash", "", "Password hashing algorithm: argon2id or bcrypt")
+	pflag.StringVar(&c.PasswordDenyList, "passwordDenyList", "", "File with denied passwords, one per line")
+	pflag.IntVar(&c.PasswordMinLength, "passwordMinLength", 0, "Minimum password length in characters")
+	pflag.IntVar(&c.PasswordMaxLength, "passwordMaxLength", 0, "Maximum password length in characters")
+	pflag.IntVar(&passwordResetExp, "passwordResetExpiration", 0, "Password reset token expiration in minutes")
+	pflag.StringVar(&c.PasswordResetFile, "passwordResetNotifyFile", "",
+		"File the password reset tokens are written to, they are written to the log when it is empty")
+	pflag.IntVar(&maxRequestBody, "maxRequestBody", 0, "Maximum request body size in kilobytes")
+	pflag.BoolVar(&c.Dev, "dev", false, "Development mode, the tokens may be signed with the default secret key")
 	pflag.Parse()
 
 	const defAddress = "localhost:8078"
 	const defAccrualAddress = "localhost:8080"
-	const defSecretKey = "gophermart"
 	const defAccrualInterval = 2
-	const defTokenExp = 1
+	const defTokenExp = 15
+	const defRefreshTokenExp = 720
+	const defAccrualWorkers = 4
+	const defAccrualRateLimit = 0
+	const defAccrualMaxAttempts = 20
+	const defAccrualMaxAge = 72
+	const defAccrualBreakerFailures = 5
+	const defAccrualBreakerOpenTimeout = 30
+	const defAccrualConnectTimeout = 5
+	const defAccrualReadTimeout = 10
+	const defIdempotencyTTL = 24
+	const defUserCacheSize = 0
+	const defLoginMaxFailures = 5
+	const defLoginMaxIPFailures = 50
+	const defLoginMaxLockout = 15
+	const defPasswordHash = "argon2id"
+	const defPasswordMinLength = 8
+	const defPasswordMaxLength = 128
+	const defPasswordResetExp = 30
+	const defMaxRequestBody = 64
 
 	viper.AutomaticEnv()
 	viper.SetDefault(envAddress, defAddress)
@@ -50,6 +177,32 @@ func GetConfig() *Config {
 	viper.SetDefault(envSecretKey, defSecretKey)
 	viper.SetDefault(envAccrualInterval, defAccrualInterval)
 	viper.SetDefault(envTokenExp, defTokenExp)
+	viper.SetDefault(envRefreshTokenExp, defRefreshTokenExp)
+	viper.SetDefault(envAccrualWorkers, defAccrualWorkers)
+	viper.SetDefault(envAccrualRateLimit, defAccrualRateLimit)
+	viper.SetDefault(envAccrualMaxAttempts, defAccrualMaxAttempts)
+	viper.SetDefault(envAccrualMaxAge, defAccrualMaxAge)
+	viper.SetDefault(envAccrualBreakerFailures, defAccrualBreakerFailures)
+	viper.SetDefault(envAccrualBreakerOpenTimeout, defAccrualBreakerOpenTimeout)
+	viper.SetDefault(envAccrualConnectTimeout, defAccrualConnectTimeout)
+	viper.SetDefault(envAccrualReadTimeout, defAccrualReadTimeout)
+	viper.SetDefault(envIdempotencyTTL, defIdempotencyTTL)
+	viper.SetDefault(envUserCacheSize, defUserCacheSize)
+	viper.SetDefault(envJWTKeysDir, "")
+	viper.SetDefault(envJWTSigningKeyID, "")
+	viper.SetDefault(envLoginMaxFailures, defLoginMaxFailures)
+	viper.SetDefault(envLoginMaxIPFailures, defLoginMaxIPFailures)
+	viper.SetDefault(envLoginMaxLockout, defLoginMaxLockout)
+	viper.SetDefault(envAdminKey, "")
+	viper.SetDefault(envTrustedProxies, "")
+	viper.SetDefault(envPasswordHash, defPasswordHash)
+	viper.SetDefault(envPasswordDenyList, "")
+	viper.SetDefault(envPasswordMinLength, defPasswordMinLength)
+	viper.SetDefault(envPasswordMaxLength, defPasswordMaxLength)
+	viper.SetDefault(envPasswordResetExp, defPasswordResetExp)
+	viper.SetDefault(envPasswordResetFile, "")
+	viper.SetDefault(envMaxRequestBody, defMaxRequestBody)
+	viper.SetDefault(envDev, false)
 
 	if c.Address == "" {
 		c.Address = viper.GetString(envAddress)
@@ -67,16 +220,219 @@ func GetConfig() *Config {
 		c.AccrualInterval = viper.GetInt(envAccrualInterval)
 	}
 
+	if c.AccrualWorkers == 0 {
+		c.AccrualWorkers = viper.GetInt(envAccrualWorkers)
+	}
+
+	if c.AccrualRateLimit == 0 {
+		c.AccrualRateLimit = viper.GetInt(envAccrualRateLimit)
+	}
+
+	if c.AccrualMaxAttempts == 0 {
+		c.AccrualMaxAttempts = viper.GetInt(envAccrualMaxAttempts)
+	}
+
+	if accMaxAge == 0 {
+		accMaxAge = viper.GetInt(envAccrualMaxAge)
+	}
+	c.AccrualMaxAge = time.Hour * time.Duration(accMaxAge)
+
+	if c.AccrualBreakerFailures == 0 {
+		c.AccrualBreakerFailures = viper.GetInt(envAccrualBreakerFailures)
+	}
+
+	if accBreakerOpenTimeout == 0 {
+		accBreakerOpenTimeout = viper.GetInt(envAccrualBreakerOpenTimeout)
+	}
+	c.AccrualBreakerOpenTimeout = time.Second * time.Duration(accBreakerOpenTimeout)
+
+	if accConnectTimeout == 0 {
+		accConnectTimeout = viper.GetInt(envAccrualConnectTimeout)
+	}
+	c.AccrualConnectTimeout = time.Second * time.Duration(accConnectTimeout)
+
+	if accReadTimeout == 0 {
+		accReadTimeout = viper.GetInt(envAccrualReadTimeout)
+	}
+	c.AccrualReadTimeout = time.Second * time.Duration(accReadTimeout)
+
 	if tokExp == 0 {
-		c.TokenExp = time.Hour * time.Duration(viper.GetInt(envTokenExp))
+		tokExp = viper.GetInt(envTokenExp)
+		if m, ok := deprecatedTokenExp(); ok {
+			tokExp = m
+			c.Warnings = append(c.Warnings,
+				fmt.Sprintf("%s is deprecated, set the expiration in minutes with %s", envDeprecatedTokenExp, envTokenExp))
+		}
+	}
+	c.TokenExp = time.Minute * time.Duration(tokExp)
+
+	if refreshTokExp == 0 {
+		refreshTokExp = viper.GetInt(envRefreshTokenExp)
+	}
+	c.RefreshTokenExp = time.Hour * time.Duration(refreshTokExp)
+
+	if idempotencyTTL == 0 {
+		idempotencyTTL = viper.GetInt(envIdempotencyTTL)
+	}
+	c.IdempotencyTTL = time.Hour * time.Duration(idempotencyTTL)
+
+	if c.UserCacheSize == 0 {
+		c.UserCacheSize = viper.GetInt(envUserCacheSize)
+	}
+
+	if c.JWTKeysDir == "" {
+		c.JWTKeysDir = viper.GetString(envJWTKeysDir)
+	}
+
+	if c.JWTSigningKeyID == "" {
+		c.JWTSigningKeyID = viper.GetString(envJWTSigningKeyID)
+	}
+
+	if c.LoginMaxFailures == 0 {
+		c.LoginMaxFailures = viper.GetInt(envLoginMaxFailures)
+	}
+
+	if c.LoginMaxIPFailures == 0 {
+		c.LoginMaxIPFailures = viper.GetInt(envLoginMaxIPFailures)
+	}
+
+	if loginMaxLockout == 0 {
+		loginMaxLockout = viper.GetInt(envLoginMaxLockout)
+	}
+	c.LoginMaxLockout = time.Minute * time.Duration(loginMaxLockout)
+
+	if c.AdminKey == "" {
+		c.AdminKey = viper.GetString(envAdminKey)
+	}
+
+	if c.TrustedProxies == "" {
+		c.TrustedProxies = viper.GetString(envTrustedProxies)
 	}
 
+	if c.PasswordHash == "" {
+		c.PasswordHash = viper.GetString(envPasswordHash)
+	}
+
+	if c.PasswordDenyList == "" {
+		c.PasswordDenyList = viper.GetString(envPasswordDenyList)
+	}
+
+	if c.PasswordMinLength == 0 {
+		c.PasswordMinLength = viper.GetInt(envPasswordMinLength)
+	}
+
+	if c.PasswordMaxLength == 0 {
+		c.PasswordMaxLength = viper.GetInt(envPasswordMaxLength)
+	}
+
+	if passwordResetExp == 0 {
+		passwordResetExp = viper.GetInt(envPasswordResetExp)
+	}
+	c.PasswordResetExp = time.Minute * time.Duration(passwordResetExp)
+
+	if c.PasswordResetFile == "" {
+		c.PasswordResetFile = viper.GetString(envPasswordResetFile)
+	}
+
+	if maxRequestBody == 0 {
+		maxRequestBody = viper.GetInt(envMaxRequestBody)
+	}
+	c.MaxRequestBody = int64(maxRequestBody) * 1024
+
 	if key == "" {
 		key = viper.GetString(envSecretKey)
 	}
 	c.Key = []byte(key)
 
-	return c
+	if !c.Dev {
+		c.Dev = viper.GetBool(envDev)
+	}
+
+	if c.Dev && c.defaultSecretKey() {
+		c.Warnings = append(c.Warnings, warnDefaultSecretKey)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	if !c.Dev && c.defaultSecretKey() {
+		return fmt.Errorf("%w: tokens can't be signed with the default secret, set %s or %s, or %s for development",
+			ErrInvalidConfig, envSecretKey, envJWTKeysDir, envDev)
+	}
+
+	ints := []struct {
+		name string
+		v    int
+		min  int
+	}{
+		{envAccrualInterval, c.AccrualInterval, 1},
+		{envAccrualWorkers, c.AccrualWorkers, 1},
+		{envAccrualRateLimit, c.AccrualRateLimit, 0},
+		{envAccrualMaxAttempts, c.AccrualMaxAttempts, 1},
+		{envAccrualBreakerFailures, c.AccrualBreakerFailures, -1},
+		{envUserCacheSize, c.UserCacheSize, 0},
+		{envLoginMaxFailures, c.LoginMaxFailures, 1},
+		{envLoginMaxIPFailures, c.LoginMaxIPFailures, 1},
+		{envPasswordMinLength, c.PasswordMinLength, 1},
+		{envPasswordMaxLength, c.PasswordMaxLength, c.PasswordMinLength},
+		{envMaxRequestBody, int(c.MaxRequestBody / 1024), 1},
+	}
+	for _, i := range ints {
+		if i.v < i.min {
+			return fmt.Errorf("%w: %s must be at least %d, got %d", ErrInvalidConfig, i.name, i.min, i.v)
+		}
+	}
+
+	durations := []struct {
+		name string
+		v    time.Duration
+		min  time.Duration
+	}{
+		{envAccrualMaxAge, c.AccrualMaxAge, time.Hour},
+		{envAccrualBreakerOpenTimeout, c.AccrualBreakerOpenTimeout, time.Second},
+		{envAccrualConnectTimeout, c.AccrualConnectTimeout, time.Second},
+		{envAccrualReadTimeout, c.AccrualReadTimeout, time.Second},
+		{envTokenExp, c.TokenExp, time.Minute},
+		{envRefreshTokenExp, c.RefreshTokenExp, time.Hour},
+		{envIdempotencyTTL, c.IdempotencyTTL, time.Hour},
+		{envLoginMaxLockout, c.LoginMaxLockout, time.Minute},
+		{envPasswordResetExp, c.PasswordResetExp, time.Minute},
+	}
+	for _, d := range durations {
+		if d.v < d.min {
+			return fmt.Errorf("%w: %s must be at least %s, got %s", ErrInvalidConfig, d.name, d.min, d.v)
+		}
+	}
+
+	return nil
+}
+
+// defSecretKey is the secret anyone can read in the sources, it is only allowed in the development mode.
+const defSecretKey = "gophermart"
+
+// defaultSecretKey reports whether the tokens are signed with the secret anyone can read in the sources.
+func (c *Config) defaultSecretKey() bool {
+	return c.JWTKeysDir == "" && string(c.Key) == defSecretKey
+}
+
+// warnDefaultSecretKey is logged when the default secret key is allowed by the development mode.
+const warnDefaultSecretKey = "tokens are signed with the default secret, set " + envSecretKey + " or " + envJWTKeysDir
+
+// deprecatedTokenExp returns the access token expiration in minutes from the variable that set it in hours.
+// The variable is only read when the one in minutes is not set.
+func deprecatedTokenExp() (int, bool) {
+	if _, ok := os.LookupEnv(envTokenExp); ok {
+		return 0, false
+	}
+	if _, ok := os.LookupEnv(envDeprecatedTokenExp); !ok {
+		return 0, false
+	}
+	return viper.GetInt(envDeprecatedTokenExp) * 60, true
 }
 
 func (c *Config) String() string {
@@ -84,5 +440,10 @@ func (c *Config) String() string {
 		`Address: %s, 
 		Accrual: %s, 
 		AccrualInterval: %d, 
-		DSN: %s`, c.Address, c.Accrual, c.AccrualInterval, c.DSN)
+		AccrualWorkers: %d, 
+		AccrualRateLimit: %d, 
+		AccrualMaxAttempts: %d, 
+		AccrualMaxAge: %s, 
+		DSN: %s`, c.Address, c.Accrual, c.AccrualInterval, c.AccrualWorkers, c.AccrualRateLimit,
+		c.AccrualMaxAttempts, c.AccrualMaxAge, c.DSN)
 }
@@ -1,19 +1,43 @@
 package config
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 	"time"
+
+	"github.com/spf13/viper"
 )
 
 func TestGetConfig(t *testing.T) {
 	defConfig := &Config{
-		Address:         "localhost:8078",
-		Accrual:         "localhost:8080",
-		DSN:             "",
-		Key:             []byte("gophermart"),
-		AccrualInterval: 2,
-		TokenExp:        1 * time.Hour,
+		Address:                   "localhost:8078",
+		Accrual:                   "localhost:8080",
+		DSN:                       "",
+		Key:                       []byte("gophermart"),
+		AccrualInterval:           2,
+		AccrualWorkers:            4,
+		AccrualRateLimit:          0,
+		AccrualMaxAttempts:        20,
+		AccrualMaxAge:             72 * time.Hour,
+		AccrualBreakerFailures:    5,
+		AccrualBreakerOpenTimeout: 30 * time.Second,
+		AccrualConnectTimeout:     5 * time.Second,
+		AccrualReadTimeout:        10 * time.Second,
+		TokenExp:                  15 * time.Minute,
+		RefreshTokenExp:           720 * time.Hour,
+		IdempotencyTTL:            24 * time.Hour,
+		UserCacheSize:             0,
+		LoginMaxFailures:          5,
+		LoginMaxIPFailures:        50,
+		LoginMaxLockout:           15 * time.Minute,
+		PasswordHash:              "argon2id",
+		PasswordMinLength:         8,
+		PasswordMaxLength:         128,
+		PasswordResetExp:          30 * time.Minute,
+		MaxRequestBody:            64 * 1024,
+		Dev:                       true,
+		Warnings:                  []string{warnDefaultSecretKey},
 	}
 
 	tests := []struct {
@@ -26,11 +50,154 @@ func TestGetConfig(t *testing.T) {
 			want: defConfig,
 		},
 	}
+	// The default secret key is refused outside of the development mode.
+	t.Setenv(envDev, "true")
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := GetConfig(); !reflect.DeepEqual(got, tt.want) {
+			got, err := GetConfig()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("GetConfig() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestConfig_validate(t *testing.T) {
+	tests := []struct {
+		update  func(c *Config)
+		name    string
+		wantErr bool
+	}{
+		{
+			name:   "valid",
+			update: func(c *Config) {},
+		},
+		{
+			name:    "negative workers",
+			update:  func(c *Config) { c.AccrualWorkers = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "zero workers",
+			update:  func(c *Config) { c.AccrualWorkers = 0 },
+			wantErr: true,
+		},
+		{
+			name:    "negative rate limit",
+			update:  func(c *Config) { c.AccrualRateLimit = -1 },
+			wantErr: true,
+		},
+		{
+			name:   "unlimited rate",
+			update: func(c *Config) { c.AccrualRateLimit = 0 },
+		},
+		{
+			name:   "disabled breaker",
+			update: func(c *Config) { c.AccrualBreakerFailures = -1 },
+		},
+		{
+			name:    "negative read timeout",
+			update:  func(c *Config) { c.AccrualReadTimeout = -time.Second },
+			wantErr: true,
+		},
+		{
+			name:    "max password length below min",
+			update:  func(c *Config) { c.PasswordMaxLength = c.PasswordMinLength - 1 },
+			wantErr: true,
+		}, {
+			name:    "default secret key",
+			update:  func(c *Config) { c.Key = []byte(defSecretKey) },
+			wantErr: true,
+		},
+		{
+			name: "default secret key in development mode",
+			update: func(c *Config) {
+				c.Key = []byte(defSecretKey)
+				c.Dev = true
+			},
+		},
+		{
+			name: "key files instead of the default secret key",
+			update: func(c *Config) {
+				c.Key = []byte(defSecretKey)
+				c.JWTKeysDir = "keys"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{
+				AccrualInterval:           2,
+				AccrualWorkers:            4,
+				AccrualMaxAttempts:        20,
+				AccrualMaxAge:             72 * time.Hour,
+				AccrualBreakerFailures:    5,
+				AccrualBreakerOpenTimeout: 30 * time.Second,
+				AccrualConnectTimeout:     5 * time.Second,
+				AccrualReadTimeout:        10 * time.Second,
+				TokenExp:                  15 * time.Minute,
+				RefreshTokenExp:           720 * time.Hour,
+				IdempotencyTTL:            24 * time.Hour,
+				LoginMaxFailures:          5,
+				LoginMaxIPFailures:        50,
+				LoginMaxLockout:           15 * time.Minute,
+				PasswordMinLength:         8,
+				PasswordMaxLength:         128,
+				PasswordResetExp:          30 * time.Minute,
+				MaxRequestBody:            64 * 1024,
+			}
+			tt.update(c)
+
+			err := c.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("validate() error = %v, want %v", err, ErrInvalidConfig)
+			}
+		})
+	}
+}
+
+func TestDeprecatedTokenExp(t *testing.T) {
+	viper.AutomaticEnv()
+
+	tests := []struct {
+		env    map[string]string
+		name   string
+		want   int
+		wantOk bool
+	}{
+		{
+			name: "not set",
+			env:  map[string]string{},
+		},
+		{
+			name:   "hours",
+			env:    map[string]string{envDeprecatedTokenExp: "2"},
+			want:   120,
+			wantOk: true,
+		},
+		{
+			name: "minutes take precedence",
+			env:  map[string]string{envDeprecatedTokenExp: "2", envTokenExp: "15"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			got, ok := deprecatedTokenExp()
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("deprecatedTokenExp() = %d, %t, want %d, %t", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
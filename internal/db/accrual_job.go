@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func (db *DB) LeaseOrdersForAccrual(ctx context.Context,
+	owner string,
+	limit int,
+	lease time.Duration) ([]*models.AccrualJob, error) {
+	sql := `
+	WITH c AS (
+		SELECT seq
+		FROM accrual_jobs
+		WHERE nextattempt <= CURRENT_TIMESTAMP
+			AND (lockeduntil IS NULL OR lockeduntil < CURRENT_TIMESTAMP)
+		ORDER BY nextattempt, seq
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE accrual_jobs j
+	SET
+		lockedby = $1,
+		lockeduntil = CURRENT_TIMESTAMP + make_interval(secs => $2),
+		attempts = j.attempts + 1
+	FROM c, orders o
+	WHERE 
+		j.seq = c.seq AND o.id = j.orderid
+	RETURNING 
+		o.id, o.userid, o.uploaded, o.number, o.sum, o.status, j.enqueued, j.attempts, j.failures, j.nextattempt, j.seq;`
+
+	rows, err := db.pool.Query(ctx, sql, owner, lease.Seconds(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("db LeaseOrdersForAccrual err: %w", err)
+	}
+	defer rows.Close()
+
+	type leased struct {
+		job         *models.AccrualJob
+		nextAttempt time.Time
+		seq         int64
+	}
+
+	var ls []leased
+	for rows.Next() {
+		var o models.Order
+		var l leased
+		l.job = &models.AccrualJob{Order: &o}
+		if err := rows.Scan(&o.ID, &o.UserID, &o.UploadedAt, &o.Number, &o.Accrual, &o.Status,
+			&l.job.EnqueuedAt, &l.job.Attempts, &l.job.Failures, &l.nextAttempt, &l.seq); err != nil {
+			return nil, fmt.Errorf("db LeaseOrdersForAccrual row scan err: %w", err)
+		}
+		l.job.Lease = models.AccrualLease{Owner: owner, Attempt: l.job.Attempts}
+		ls = append(ls, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db LeaseOrdersForAccrual rows err: %w", err)
+	}
+
+	// RETURNING does not keep the order of the locking subquery.
+	sort.Slice(ls, func(i, j int) bool {
+		if ls[i].nextAttempt.Equal(ls[j].nextAttempt) {
+			return ls[i].seq < ls[j].seq
+		}
+		return ls[i].nextAttempt.Before(ls[j].nextAttempt)
+	})
+
+	jobs := make([]*models.AccrualJob, 0, len(ls))
+	for _, l := range ls {
+		jobs = append(jobs, l.job)
+	}
+
+	return jobs, nil
+}
+
+// RescheduleOrderAccrual releases the job leased by the lease, models.ErrAccrualLeaseLost is returned
+// when the job is not leased by it any more.
+func (db *DB) RescheduleOrderAccrual(ctx context.Context,
+	orderID string,
+	lease models.AccrualLease,
+	delay time.Duration) error {
+	sql := `
+	UPDATE accrual_jobs
+	SET
+		lockedby = NULL,
+		lockeduntil = NULL,
+		nextattempt = CURRENT_TIMESTAMP + make_interval(secs => $2)
+	WHERE
+		orderid = $1 AND lockedby = $3 AND attempts = $4;`
+
+	tag, err := db.pool.Exec(ctx, sql, orderID, delay.Seconds(), lease.Owner, lease.Attempt)
+	if err != nil {
+		return fmt.Errorf("db RescheduleOrderAccrual err: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return models.ErrAccrualLeaseLost
+	}
+
+	return nil
+}
+
+func (db *DB) RetryOrderAccrual(ctx context.Context,
+	orderID string,
+	lease models.AccrualLease,
+	delay time.Duration,
+	reason string) error {
+	sql := `
+	UPDATE accrual_jobs
+	SET
+		lockedby = NULL,
+		lockeduntil = NULL,
+		failures = failures + 1,
+		lasterror = $3,
+		nextattempt = CURRENT_TIMESTAMP + make_interval(secs => $2)
+	WHERE
+		orderid = $1 AND lockedby = $4 AND attempts = $5;`
+
+	tag, err := db.pool.Exec(ctx, sql, orderID, delay.Seconds(), reason, lease.Owner, lease.Attempt)
+	if err != nil {
+		return fmt.Errorf("db RetryOrderAccrual err: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return models.ErrAccrualLeaseLost
+	}
+
+	return nil
+}
+
+// lockAccrualLease locks the job while it is leased by the lease.
+func (db *DB) lockAccrualLease(ctx context.Context, tx pgx.Tx, orderID string, lease *models.AccrualLease) error {
+	sql := `
+	SELECT 1
+	FROM accrual_jobs
+	WHERE orderid = $1 AND lockedby = $2 AND attempts = $3
+	FOR UPDATE;`
+
+	var one int
+	row := tx.QueryRow(ctx, sql, orderID, lease.Owner, lease.Attempt)
+	if err := row.Scan(&one); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ErrAccrualLeaseLost
+		}
+		return fmt.Errorf("db lockAccrualLease err: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) deleteAccrualJob(ctx context.Context, tx pgx.Tx, orderID string) error {
+	sql := `
+	DELETE FROM accrual_jobs
+	WHERE orderid = $1;`
+
+	if _, err := tx.Exec(ctx, sql, orderID); err != nil {
+		return fmt.Errorf("db deleteAccrualJob err: %w", err)
+	}
+
+	return nil
+}
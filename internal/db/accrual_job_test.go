@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestDB_AccrualLeaseLost(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	o := newTestOrder(t, db, newTestUser(t, db))
+
+	lease := func(owner string) *models.AccrualJob {
+		t.Helper()
+
+		// The jobs of the other tests may be due as well.
+		js, err := db.LeaseOrdersForAccrual(ctx, owner, 10000, 0)
+		require.NoError(t, err)
+		for _, j := range js {
+			if j.Order.ID == o.ID {
+				return j
+			}
+		}
+		require.FailNow(t, "the job was not leased")
+		return nil
+	}
+
+	// The lease of the first worker expires at once and the job is leased again.
+	stale := lease("first" + testUniqueSuffix())
+	time.Sleep(10 * time.Millisecond)
+	current := lease("second" + testUniqueSuffix())
+
+	require.ErrorIs(t, db.RetryOrderAccrual(ctx, o.ID, stale.Lease, time.Hour, "stale"), models.ErrAccrualLeaseLost)
+	require.ErrorIs(t, db.RescheduleOrderAccrual(ctx, o.ID, stale.Lease, time.Hour), models.ErrAccrualLeaseLost)
+
+	upd := *o
+	upd.Status = models.OrderStatusProcessed
+	upd.Accrual = 100
+	require.ErrorIs(t, db.UpdateOrder(ctx, &upd, &stale.Lease), models.ErrAccrualLeaseLost)
+
+	got, err := db.GetOrder(ctx, &models.OrderDTO{Number: o.Number, UserID: o.UserID})
+	require.NoError(t, err)
+	require.Equal(t, models.OrderStatusNew, got.Status, "the stale result must be dropped")
+
+	require.NoError(t, db.UpdateOrder(ctx, &upd, &current.Lease))
+	require.ErrorIs(t, db.RescheduleOrderAccrual(ctx, o.ID, current.Lease, 0), models.ErrAccrualLeaseLost,
+		"the job of the final order is deleted")
+}
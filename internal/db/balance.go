@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 
 	"github.com/ArtemShalinFe/gophermart/internal/models"
 )
@@ -45,13 +47,13 @@ func (db *DB) GetBalance(ctx context.Context, userID string) (*models.UserBalanc
 	return &b, nil
 }
 
-func (db *DB) getCurrentBalance(ctx context.Context, tx pgx.Tx, userID string) (float64, error) {
+func (db *DB) getCurrentBalance(ctx context.Context, tx pgx.Tx, userID string) (models.Amount, error) {
 	sql := `
 	SELECT sum
 	FROM currentBalances
 	WHERE userId = $1;`
 
-	var b float64
+	var b models.Amount
 	row := tx.QueryRow(ctx, sql, userID)
 	if err := row.Scan(&b); err != nil {
 		if !errors.Is(err, pgx.ErrNoRows) {
@@ -62,13 +64,31 @@ func (db *DB) getCurrentBalance(ctx context.Context, tx pgx.Tx, userID string) (
 	return b, nil
 }
 
-func (db *DB) getWithdrawals(ctx context.Context, tx pgx.Tx, userID string) (float64, error) {
+func (db *DB) lockCurrentBalance(ctx context.Context, tx pgx.Tx, userID string) (models.Amount, error) {
+	sql := `
+	SELECT sum
+	FROM currentBalances
+	WHERE userId = $1
+	FOR UPDATE;`
+
+	var b models.Amount
+	row := tx.QueryRow(ctx, sql, userID)
+	if err := row.Scan(&b); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return 0, fmt.Errorf("db lockCurrentBalance err: %w", err)
+		}
+	}
+
+	return b, nil
+}
+
+func (db *DB) getWithdrawals(ctx context.Context, tx pgx.Tx, userID string) (models.Amount, error) {
 	sql := `
 	SELECT coalesce(sum(sum),0)
 	FROM withdrawals
 	WHERE userId = $1;`
 
-	var b float64
+	var b models.Amount
 	row := tx.QueryRow(ctx, sql, userID)
 	if err := row.Scan(&b); err != nil {
 		if !errors.Is(err, pgx.ErrNoRows) {
@@ -79,7 +99,7 @@ func (db *DB) getWithdrawals(ctx context.Context, tx pgx.Tx, userID string) (flo
 	return b, nil
 }
 
-func (db *DB) AddWithdrawn(ctx context.Context, userID string, orderNumber string, sum float64) error {
+func (db *DB) AddWithdrawn(ctx context.Context, userID string, orderNumber string, sum models.Amount) error {
 	tx, err := db.pool.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("unable to start AddWithdrawn transaction err: %w", err)
@@ -93,14 +113,47 @@ func (db *DB) AddWithdrawn(ctx context.Context, userID string, orderNumber strin
 		}
 	}(tx)
 
+	// Withdrawals of the same user queue up on the balance row, so they can't overdraw it together.
+	current, err := db.lockCurrentBalance(ctx, tx, userID)
+	if err != nil {
+		return fmt.Errorf("unable to lock current balance err: %w", err)
+	}
+
+	// A replayed withdrawal has already been paid, so it is reported as a duplicate even when the funds ran out.
+	exists, err := db.withdrawalExists(ctx, tx, orderNumber)
+	if err != nil {
+		return fmt.Errorf("unable to check withdrawal err: %w", err)
+	}
+	if exists {
+		return models.ErrWithdrawalWasRegisteredEarlier
+	}
+
+	if current < sum {
+		return models.ErrNotEnoughAccruals
+	}
+
 	sql := `
 	INSERT INTO withdrawals(date, userid, orderNumber, sum)
-	VALUES (CURRENT_TIMESTAMP, $1, $2, $3);`
-
-	if _, err := tx.Exec(ctx, sql, userID, orderNumber, sum); err != nil {
+	VALUES (CURRENT_TIMESTAMP, $1, $2, $3)
+	RETURNING 
+		seq;`
+
+	var seq int
+	row := tx.QueryRow(ctx, sql, userID, orderNumber, sum)
+	if err := row.Scan(&seq); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) && pgErr.ConstraintName == "withdrawals_ordernumber_key" {
+				return models.ErrWithdrawalWasRegisteredEarlier
+			}
+		}
 		return fmt.Errorf("db AddWithdrawn err: %w", err)
 	}
 
+	if err := db.postWithdrawal(ctx, tx, userID, seq, sum); err != nil {
+		return fmt.Errorf("failed post withdrawal. AddWithdrawn err: %w", err)
+	}
+
 	if _, err := db.UpdateUserBalance(ctx, tx, userID, -sum); err != nil {
 		return fmt.Errorf("failed update user balance. AddWithdrawn err: %w", err)
 	}
@@ -112,6 +165,25 @@ func (db *DB) AddWithdrawn(ctx context.Context, userID string, orderNumber strin
 	return nil
 }
 
+// withdrawalExists checks the order number across all users like the unique key does,
+// an order is paid with points only once whoever withdraws for it.
+func (db *DB) withdrawalExists(ctx context.Context, tx pgx.Tx, orderNumber string) (bool, error) {
+	sql := `
+	SELECT EXISTS (
+		SELECT 1
+		FROM withdrawals
+		WHERE orderNumber = $1
+	);`
+
+	var exists bool
+	row := tx.QueryRow(ctx, sql, orderNumber)
+	if err := row.Scan(&exists); err != nil {
+		return false, fmt.Errorf("db withdrawalExists err: %w", err)
+	}
+
+	return exists, nil
+}
+
 func (db *DB) GetWithdrawalList(ctx context.Context, userID string) ([]*models.UserWithdrawalsHistory, error) {
 	sql := `
 	SELECT date, orderNumber, sum
@@ -139,7 +211,10 @@ func (db *DB) GetWithdrawalList(ctx context.Context, userID string) ([]*models.U
 	return m, nil
 }
 
-func (db *DB) UpdateUserBalance(ctx context.Context, tx pgx.Tx, userID string, sum float64) (float64, error) {
+func (db *DB) UpdateUserBalance(ctx context.Context,
+	tx pgx.Tx,
+	userID string,
+	sum models.Amount) (models.Amount, error) {
 	sql := `
 	INSERT INTO currentBalances(userid, sum)
 		VALUES ($1, $2)
@@ -148,15 +223,17 @@ func (db *DB) UpdateUserBalance(ctx context.Context, tx pgx.Tx, userID string, s
 	RETURNING
 		sum;`
 
-	var cb float64
+	var cb models.Amount
 	row := tx.QueryRow(ctx, sql, userID, sum)
 	if err := row.Scan(&cb); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == pgerrcode.CheckViolation && pgErr.ConstraintName == "currentbalances_sum_check" {
+				return 0, models.ErrNotEnoughAccruals
+			}
+		}
 		return 0, fmt.Errorf("db UpdateUserBalance err: %w", err)
 	}
 
-	if cb < 0 {
-		return 0, models.ErrNotEnoughAccruals
-	}
-
 	return cb, nil
 }
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestDB_AddWithdrawnSameOrder(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+	o := newTestOrder(t, db, u)
+
+	o.Status = models.OrderStatusProcessed
+	o.Accrual = 10000
+	require.NoError(t, db.UpdateOrder(ctx, o, nil))
+
+	number := testUniqueSuffix()
+	require.NoError(t, db.AddWithdrawn(ctx, u.ID, number, 1000))
+	require.ErrorIs(t, db.AddWithdrawn(ctx, u.ID, number, 1000), models.ErrWithdrawalWasRegisteredEarlier)
+
+	// The replay is a duplicate even when the rest of the balance doesn't cover it.
+	number = testUniqueSuffix()
+	require.NoError(t, db.AddWithdrawn(ctx, u.ID, number, 6000))
+	require.ErrorIs(t, db.AddWithdrawn(ctx, u.ID, number, 6000), models.ErrWithdrawalWasRegisteredEarlier)
+
+	b, err := db.GetBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Amount(3000), b.Current)
+}
+
+func TestDB_AddWithdrawnOrderOfAnotherUser(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u1 := newTestUser(t, db)
+	u2 := newTestUser(t, db)
+	for _, u := range []*models.User{u1, u2} {
+		o := newTestOrder(t, db, u)
+		o.Status = models.OrderStatusProcessed
+		o.Accrual = 10000
+		require.NoError(t, db.UpdateOrder(ctx, o, nil))
+	}
+
+	number := testUniqueSuffix()
+	require.NoError(t, db.AddWithdrawn(ctx, u1.ID, number, 1000))
+	require.ErrorIs(t, db.AddWithdrawn(ctx, u2.ID, number, 1000), models.ErrWithdrawalWasRegisteredEarlier)
+
+	b, err := db.GetBalance(ctx, u2.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Amount(10000), b.Current)
+	require.Equal(t, models.Amount(0), b.Withdrawn)
+}
+
+func TestDB_AddWithdrawnConcurrently(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+	o := newTestOrder(t, db, u)
+
+	const (
+		balance     = models.Amount(10000)
+		withdrawal  = models.Amount(100)
+		withdrawals = 300
+	)
+
+	o.Status = models.OrderStatusProcessed
+	o.Accrual = balance
+	require.NoError(t, db.UpdateOrder(ctx, o, nil))
+
+	var succeeded atomic.Int64
+	wg := &sync.WaitGroup{}
+	for i := 0; i < withdrawals; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := db.AddWithdrawn(ctx, u.ID, testUniqueSuffix(), withdrawal)
+			switch {
+			case err == nil:
+				succeeded.Add(1)
+			case errors.Is(err, models.ErrNotEnoughAccruals):
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int64(balance/withdrawal), succeeded.Load())
+
+	b, err := db.GetBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Amount(0), b.Current)
+	require.Equal(t, balance, b.Withdrawn)
+
+	ms, err := db.CheckLedger(ctx)
+	require.NoError(t, err)
+	for _, m := range ms {
+		require.NotEqual(t, u.ID, m.UserID, "ledger %s, balance %s", m.Ledger, m.Balance)
+	}
+}
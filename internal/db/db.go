@@ -2,33 +2,76 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/gophermart/internal/migrate"
 )
 
+var ErrDuplicateWithdrawals = errors.New("withdrawals were made more than once for the orders")
+
 type DB struct {
 	pool *pgxpool.Pool
 	log  *zap.SugaredLogger
 }
 
 func NewDB(ctx context.Context, dsn string, log *zap.SugaredLogger) (*DB, error) {
-	if err := runMigrations(dsn); err != nil {
-		return nil, fmt.Errorf("failed to run DB migrations: %w", err)
-	}
-
 	pool, err := pgxpool.New(ctx, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a connection pool: %w", err)
 	}
 
+	// The migrations can't add the unique key of the order number until the duplicates are resolved by hand.
+	dups, err := duplicateWithdrawals(ctx, pool)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	if len(dups) > 0 {
+		pool.Close()
+		return nil, fmt.Errorf("%w: %s", ErrDuplicateWithdrawals, strings.Join(dups, ", "))
+	}
+
+	if err := migrate.Up(migrationsDir, "migrations", dsn); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to run DB migrations: %w", err)
+	}
+
 	return &DB{
 		pool: pool,
 		log:  log,
 	}, nil
 }
 
+func duplicateWithdrawals(ctx context.Context, pool *pgxpool.Pool) ([]string, error) {
+	sql := `
+	SELECT ordernumber
+	FROM withdrawals
+	GROUP BY ordernumber
+	HAVING count(*) > 1
+	ORDER BY ordernumber;`
+
+	// CollectRows returns the error of the query too.
+	rows, _ := pool.Query(ctx, sql)
+	dups, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UndefinedTable {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("db duplicateWithdrawals err: %w", err)
+	}
+
+	return dups, nil
+}
+
 func (db *DB) Close() {
 	db.pool.Close()
 }
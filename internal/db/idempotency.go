@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func (db *DB) StartIdempotentRequest(ctx context.Context,
+	k *models.IdempotencyKey) (*models.IdempotencyKey, bool, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to start StartIdempotentRequest transaction err: %w", err)
+	}
+
+	defer func(tx pgx.Tx) {
+		if err := tx.Rollback(ctx); err != nil {
+			if !errors.Is(err, pgx.ErrTxClosed) {
+				db.log.Errorf("failed rollback transaction StartIdempotentRequest err: %w", err)
+			}
+		}
+	}(tx)
+
+	// The key of the request that is in progress for too long is taken over, its instance is probably gone.
+	sql := `
+	DELETE FROM idempotency_keys
+	WHERE userid = $1 AND key = $2
+		AND (expires < CURRENT_TIMESTAMP
+			OR (status IS NULL AND created < CURRENT_TIMESTAMP - make_interval(secs => $3)));`
+
+	if _, err := tx.Exec(ctx, sql, k.UserID, k.Key, k.StaleTimeout.Seconds()); err != nil {
+		return nil, false, fmt.Errorf("db StartIdempotentRequest delete expired err: %w", err)
+	}
+
+	sql = `
+	INSERT INTO idempotency_keys(key, userid, method, path, requesthash, created, expires)
+	VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, $6)
+	ON CONFLICT (userid, key) DO NOTHING
+	RETURNING
+		created;`
+
+	saved := k
+	started := true
+	row := tx.QueryRow(ctx, sql, k.Key, k.UserID, k.Method, k.Path, k.RequestHash, k.ExpiresAt)
+	if err := row.Scan(&k.CreatedAt); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, fmt.Errorf("db StartIdempotentRequest insert err: %w", err)
+		}
+		started = false
+	}
+
+	if !started {
+		saved, err = db.getIdempotencyKey(ctx, tx, k.UserID, k.Key)
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to get idempotency key err: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, fmt.Errorf("failed commit transaction StartIdempotentRequest err: %w", err)
+	}
+
+	return saved, started, nil
+}
+
+func (db *DB) getIdempotencyKey(ctx context.Context,
+	tx pgx.Tx,
+	userID string,
+	key string) (*models.IdempotencyKey, error) {
+	sql := `
+	SELECT key, userid, method, path, requesthash, coalesce(status, 0), coalesce(contenttype, ''), body,
+		created, expires
+	FROM idempotency_keys
+	WHERE userid = $1 AND key = $2;`
+
+	var k models.IdempotencyKey
+	row := tx.QueryRow(ctx, sql, userID, key)
+	if err := row.Scan(&k.Key, &k.UserID, &k.Method, &k.Path, &k.RequestHash,
+		&k.Status, &k.ContentType, &k.Body, &k.CreatedAt, &k.ExpiresAt); err != nil {
+		return nil, fmt.Errorf("db getIdempotencyKey err: %w", err)
+	}
+
+	return &k, nil
+}
+
+func (db *DB) FinishIdempotentRequest(ctx context.Context, k *models.IdempotencyKey) error {
+	sql := `
+	UPDATE idempotency_keys
+	SET
+		status = $3,
+		contenttype = $4,
+		body = $5
+	WHERE
+		userid = $1 AND key = $2 AND created = $6;`
+
+	if _, err := db.pool.Exec(ctx, sql, k.UserID, k.Key, k.Status, k.ContentType, k.Body, k.CreatedAt); err != nil {
+		return fmt.Errorf("db FinishIdempotentRequest err: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) CancelIdempotentRequest(ctx context.Context, k *models.IdempotencyKey) error {
+	sql := `
+	DELETE FROM idempotency_keys
+	WHERE userid = $1 AND key = $2 AND created = $3 AND status IS NULL;`
+
+	if _, err := db.pool.Exec(ctx, sql, k.UserID, k.Key, k.CreatedAt); err != nil {
+		return fmt.Errorf("db CancelIdempotentRequest err: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	sql := `
+	DELETE FROM idempotency_keys
+	WHERE expires < CURRENT_TIMESTAMP;`
+
+	tag, err := db.pool.Exec(ctx, sql)
+	if err != nil {
+		return 0, fmt.Errorf("db PurgeIdempotencyKeys err: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
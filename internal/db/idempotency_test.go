@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestDB_IdempotencyKeyStale(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+	newKey := func() *models.IdempotencyKey {
+		return &models.IdempotencyKey{
+			Key:          "stale",
+			UserID:       u.ID,
+			Method:       "POST",
+			Path:         "/api/user/balance/withdraw",
+			RequestHash:  "hash",
+			ExpiresAt:    time.Now().Add(time.Hour),
+			StaleTimeout: time.Minute,
+		}
+	}
+
+	first := newKey()
+	_, started, err := db.StartIdempotentRequest(ctx, first)
+	require.NoError(t, err)
+	require.True(t, started)
+
+	_, started, err = db.StartIdempotentRequest(ctx, newKey())
+	require.NoError(t, err)
+	require.False(t, started, "the request is still in progress")
+
+	// The instance of the first request is gone for longer than the stale timeout.
+	_, err = db.pool.Exec(ctx, `UPDATE idempotency_keys SET created = created - $3::interval
+		WHERE userid = $1 AND key = $2;`, u.ID, first.Key, "2 minutes")
+	require.NoError(t, err)
+
+	second := newKey()
+	_, started, err = db.StartIdempotentRequest(ctx, second)
+	require.NoError(t, err)
+	require.True(t, started, "the stale key is taken over")
+
+	// The first request finishing late doesn't touch the key of the second one.
+	require.NoError(t, db.CancelIdempotentRequest(ctx, first))
+	first.Status = 200
+	require.NoError(t, db.FinishIdempotentRequest(ctx, first))
+
+	saved, started, err := db.StartIdempotentRequest(ctx, newKey())
+	require.NoError(t, err)
+	require.False(t, started)
+	require.Equal(t, 0, saved.Status)
+	require.Equal(t, second.CreatedAt, saved.CreatedAt)
+}
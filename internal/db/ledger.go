@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func (db *DB) postOrderAccrual(ctx context.Context, tx pgx.Tx, userID string, orderID string, sum models.Amount) error {
+	if sum <= 0 {
+		return nil
+	}
+
+	debit, err := db.systemAccount(ctx, tx, models.LedgerAccountAccruals)
+	if err != nil {
+		return fmt.Errorf("failed get accruals account err: %w", err)
+	}
+
+	credit, err := db.userAccount(ctx, tx, userID)
+	if err != nil {
+		return fmt.Errorf("failed get user account err: %w", err)
+	}
+
+	sql := `
+	INSERT INTO ledger_postings(date, debitaccount, creditaccount, sum, orderid)
+	VALUES (CURRENT_TIMESTAMP, $1, $2, $3, $4);`
+
+	if _, err := tx.Exec(ctx, sql, debit, credit, sum, orderID); err != nil {
+		return fmt.Errorf("db postOrderAccrual err: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) postWithdrawal(ctx context.Context, tx pgx.Tx, userID string, withdrawalSeq int, sum models.Amount) error {
+	debit, err := db.userAccount(ctx, tx, userID)
+	if err != nil {
+		return fmt.Errorf("failed get user account err: %w", err)
+	}
+
+	credit, err := db.systemAccount(ctx, tx, models.LedgerAccountWithdrawals)
+	if err != nil {
+		return fmt.Errorf("failed get withdrawals account err: %w", err)
+	}
+
+	sql := `
+	INSERT INTO ledger_postings(date, debitaccount, creditaccount, sum, withdrawalseq)
+	VALUES (CURRENT_TIMESTAMP, $1, $2, $3, $4);`
+
+	if _, err := tx.Exec(ctx, sql, debit, credit, sum, withdrawalSeq); err != nil {
+		return fmt.Errorf("db postWithdrawal err: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) userAccount(ctx context.Context, tx pgx.Tx, userID string) (string, error) {
+	sql := `
+	INSERT INTO ledger_accounts(kind, userid)
+	VALUES ($1, $2)
+	ON CONFLICT (userid)
+		DO UPDATE SET kind = EXCLUDED.kind
+	RETURNING
+		id;`
+
+	var id string
+	row := tx.QueryRow(ctx, sql, models.LedgerAccountUser, userID)
+	if err := row.Scan(&id); err != nil {
+		return "", fmt.Errorf("db userAccount err: %w", err)
+	}
+
+	return id, nil
+}
+
+func (db *DB) systemAccount(ctx context.Context, tx pgx.Tx, kind string) (string, error) {
+	sql := `
+	SELECT id
+	FROM ledger_accounts
+	WHERE kind = $1 AND userid IS NULL;`
+
+	var id string
+	row := tx.QueryRow(ctx, sql, kind)
+	if err := row.Scan(&id); err != nil {
+		return "", fmt.Errorf("db systemAccount err: %w", err)
+	}
+
+	return id, nil
+}
+
+// CheckLedger returns users whose current balance doesn't match their ledger account.
+func (db *DB) CheckLedger(ctx context.Context) ([]*models.LedgerMismatch, error) {
+	sql := `
+	WITH l AS (
+		SELECT creditaccount AS account, sum
+		FROM ledger_postings
+		UNION ALL
+		SELECT debitaccount, -sum
+		FROM ledger_postings
+	), a AS (
+		SELECT a.userid, coalesce(sum(l.sum), 0) AS sum
+		FROM ledger_accounts a
+			LEFT JOIN l ON l.account = a.id
+		WHERE a.userid IS NOT NULL
+		GROUP BY a.userid
+	)
+	SELECT coalesce(a.userid, cb.userid), coalesce(a.sum, 0), coalesce(cb.sum, 0)
+	FROM a
+		FULL JOIN currentbalances cb ON cb.userid = a.userid
+	WHERE coalesce(a.sum, 0) <> coalesce(cb.sum, 0);`
+
+	rows, err := db.pool.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("db CheckLedger err: %w", err)
+	}
+	defer rows.Close()
+
+	var ms []*models.LedgerMismatch
+	for rows.Next() {
+		var m models.LedgerMismatch
+		if err := rows.Scan(&m.UserID, &m.Ledger, &m.Balance); err != nil {
+			return nil, fmt.Errorf("db CheckLedger row scan err: %w", err)
+		}
+		ms = append(ms, &m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db CheckLedger rows err: %w", err)
+	}
+
+	return ms, nil
+}
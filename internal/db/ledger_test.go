@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestDB_CheckLedger(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+	o := newTestOrder(t, db, u)
+
+	o.Status = models.OrderStatusProcessed
+	o.Accrual = 10000
+	require.NoError(t, db.UpdateOrder(ctx, o, nil))
+	require.NoError(t, db.AddWithdrawn(ctx, u.ID, testUniqueSuffix(), 3000))
+
+	ms, err := db.CheckLedger(ctx)
+	require.NoError(t, err)
+	for _, m := range ms {
+		require.NotEqual(t, u.ID, m.UserID, "ledger %s, balance %s", m.Ledger, m.Balance)
+	}
+
+	b, err := db.GetBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Amount(7000), b.Current)
+	require.Equal(t, models.Amount(3000), b.Withdrawn)
+}
+
+func TestDB_LedgerIsAppendOnly(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+	o := newTestOrder(t, db, u)
+
+	o.Status = models.OrderStatusProcessed
+	o.Accrual = 10000
+	require.NoError(t, db.UpdateOrder(ctx, o, nil))
+
+	_, err := db.pool.Exec(ctx, `UPDATE ledger_postings SET sum = 1000 WHERE orderid = $1;`, o.ID)
+	require.Error(t, err)
+
+	_, err = db.pool.Exec(ctx, `DELETE FROM ledger_postings WHERE orderid = $1;`, o.ID)
+	require.Error(t, err)
+}
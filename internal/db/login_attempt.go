@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func (db *DB) StartLoginAttempt(ctx context.Context,
+	k models.LoginAttemptKey,
+	p models.LoginLockoutPolicy) (time.Time, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to start StartLoginAttempt transaction err: %w", err)
+	}
+
+	defer func(tx pgx.Tx) {
+		if err := tx.Rollback(ctx); err != nil {
+			if !errors.Is(err, pgx.ErrTxClosed) {
+				db.log.Errorf("failed rollback transaction StartLoginAttempt err: %w", err)
+			}
+		}
+	}(tx)
+
+	// The row is locked, so the parallel attempts of the key are counted one by one.
+	sql := `
+	INSERT INTO login_attempts AS a (kind, key, failures, lastfailure)
+	VALUES ($1, $2, 0, CURRENT_TIMESTAMP)
+	ON CONFLICT (kind, key) DO UPDATE
+	SET failures = a.failures
+	RETURNING CASE WHEN lockeduntil > CURRENT_TIMESTAMP THEN lockeduntil END;`
+
+	var locked *time.Time
+	row := tx.QueryRow(ctx, sql, k.Kind, k.Value)
+	if err := row.Scan(&locked); err != nil {
+		return time.Time{}, fmt.Errorf("db StartLoginAttempt lock err: %w", err)
+	}
+
+	if locked != nil {
+		return *locked, nil
+	}
+
+	// The failures are counted from the beginning when the last one is older than the window.
+	sql = `
+	UPDATE login_attempts
+	SET
+		failures = CASE
+			WHEN lastfailure < CURRENT_TIMESTAMP - make_interval(secs => $3::double precision) THEN 1
+			ELSE failures + 1
+		END,
+		lastfailure = CURRENT_TIMESTAMP
+	WHERE kind = $1 AND key = $2
+	RETURNING failures;`
+
+	var failures int
+	row = tx.QueryRow(ctx, sql, k.Kind, k.Value, p.Window.Seconds())
+	if err := row.Scan(&failures); err != nil {
+		return time.Time{}, fmt.Errorf("db StartLoginAttempt count err: %w", err)
+	}
+
+	if err := setLoginLockout(ctx, tx, k, p.Delay(failures)); err != nil {
+		return time.Time{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return time.Time{}, fmt.Errorf("failed commit transaction StartLoginAttempt err: %w", err)
+	}
+
+	return time.Time{}, nil
+}
+
+func (db *DB) CancelLoginAttempt(ctx context.Context, k models.LoginAttemptKey, p models.LoginLockoutPolicy) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to start CancelLoginAttempt transaction err: %w", err)
+	}
+
+	defer func(tx pgx.Tx) {
+		if err := tx.Rollback(ctx); err != nil {
+			if !errors.Is(err, pgx.ErrTxClosed) {
+				db.log.Errorf("failed rollback transaction CancelLoginAttempt err: %w", err)
+			}
+		}
+	}(tx)
+
+	sql := `
+	UPDATE login_attempts
+	SET failures = greatest(failures - 1, 0)
+	WHERE kind = $1 AND key = $2
+	RETURNING failures;`
+
+	var failures int
+	row := tx.QueryRow(ctx, sql, k.Kind, k.Value)
+	if err := row.Scan(&failures); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("db CancelLoginAttempt err: %w", err)
+	}
+
+	// The lockout set by the attempt is lifted unless the other failures lock the key out anyway.
+	if p.Delay(failures) == 0 {
+		if err := setLoginLockout(ctx, tx, k, 0); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed commit transaction CancelLoginAttempt err: %w", err)
+	}
+
+	return nil
+}
+
+func setLoginLockout(ctx context.Context, tx pgx.Tx, k models.LoginAttemptKey, d time.Duration) error {
+	sql := `
+	UPDATE login_attempts
+	SET lockeduntil = CASE
+		WHEN $3::double precision > 0 THEN CURRENT_TIMESTAMP + make_interval(secs => $3::double precision)
+	END
+	WHERE kind = $1 AND key = $2;`
+
+	if _, err := tx.Exec(ctx, sql, k.Kind, k.Value, d.Seconds()); err != nil {
+		return fmt.Errorf("db setLoginLockout err: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) ResetLoginFailures(ctx context.Context, k models.LoginAttemptKey) error {
+	sql := `
+	DELETE FROM login_attempts
+	WHERE kind = $1 AND key = $2;`
+
+	if _, err := db.pool.Exec(ctx, sql, k.Kind, k.Value); err != nil {
+		return fmt.Errorf("db ResetLoginFailures err: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) PurgeLoginAttempts(ctx context.Context, olderThan time.Duration) (int64, error) {
+	sql := `
+	DELETE FROM login_attempts
+	WHERE lastfailure < CURRENT_TIMESTAMP - make_interval(secs => $1::double precision)
+		AND (lockeduntil IS NULL OR lockeduntil < CURRENT_TIMESTAMP);`
+
+	tag, err := db.pool.Exec(ctx, sql, olderThan.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("db PurgeLoginAttempts err: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
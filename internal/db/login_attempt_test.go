@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestDB_LoginLockout(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	p := models.LoginLockoutPolicy{
+		FreeAttempts: 2,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}
+
+	lk := models.LoginAttemptKey{Kind: models.LoginAttemptLogin, Value: "login" + testUniqueSuffix()}
+
+	// The second attempt locks the key out before it is checked.
+	for i := 0; i < 2; i++ {
+		until, err := db.StartLoginAttempt(ctx, lk, p)
+		require.NoError(t, err)
+		require.True(t, until.IsZero(), "attempt #%d", i+1)
+	}
+
+	until, err := db.StartLoginAttempt(ctx, lk, p)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Minute), until, 10*time.Second)
+
+	// The attempt that didn't fail is taken back together with its lockout.
+	require.NoError(t, db.CancelLoginAttempt(ctx, lk, p))
+
+	until, err = db.StartLoginAttempt(ctx, lk, p)
+	require.NoError(t, err)
+	require.True(t, until.IsZero())
+
+	require.NoError(t, db.ResetLoginFailures(ctx, lk))
+
+	until, err = db.StartLoginAttempt(ctx, lk, p)
+	require.NoError(t, err)
+	require.True(t, until.IsZero())
+}
+
+func TestDB_LoginLockoutInParallel(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	p := models.LoginLockoutPolicy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}
+	k := models.LoginAttemptKey{Kind: models.LoginAttemptIP, Value: "ip" + testUniqueSuffix()}
+
+	const attempts = 20
+	var started atomic.Int32
+	wg := &sync.WaitGroup{}
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			until, err := db.StartLoginAttempt(ctx, k, p)
+			require.NoError(t, err)
+			if until.IsZero() {
+				started.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(p.FreeAttempts), started.Load())
+}
+
+func TestDB_PurgeLoginAttempts(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	p := models.LoginLockoutPolicy{
+		FreeAttempts: 1,
+		BaseDelay:    48 * time.Hour,
+		MaxDelay:     48 * time.Hour,
+		Window:       time.Hour,
+	}
+	stale := models.LoginAttemptKey{Kind: models.LoginAttemptLogin, Value: "stale" + testUniqueSuffix()}
+	locked := models.LoginAttemptKey{Kind: models.LoginAttemptLogin, Value: "locked" + testUniqueSuffix()}
+	fresh := models.LoginAttemptKey{Kind: models.LoginAttemptLogin, Value: "fresh" + testUniqueSuffix()}
+
+	for _, k := range []models.LoginAttemptKey{stale, locked, fresh} {
+		_, err := db.StartLoginAttempt(ctx, k, p)
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.CancelLoginAttempt(ctx, stale, p))
+
+	for _, k := range []models.LoginAttemptKey{stale, locked} {
+		_, err := db.pool.Exec(ctx, `UPDATE login_attempts SET lastfailure = lastfailure - interval '25 hours'
+		WHERE kind = $1 AND key = $2;`, k.Kind, k.Value)
+		require.NoError(t, err)
+	}
+
+	_, err := db.PurgeLoginAttempts(ctx, 24*time.Hour)
+	require.NoError(t, err)
+
+	for k, want := range map[models.LoginAttemptKey]bool{stale: false, locked: true, fresh: true} {
+		var exists bool
+		row := db.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM login_attempts WHERE kind = $1 AND key = $2);`,
+			k.Kind, k.Value)
+		require.NoError(t, row.Scan(&exists))
+		require.Equal(t, want, exists, k.Value)
+	}
+}
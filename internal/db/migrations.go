@@ -1,45 +1,6 @@
 package db
 
-import (
-	"embed"
-	"errors"
-	"fmt"
-	"log"
-
-	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	"github.com/golang-migrate/migrate/v4/source/iofs"
-)
+import "embed"
 
 //go:embed migrations/*.sql
 var migrationsDir embed.FS
-
-func runMigrations(dsn string) error {
-	d, err := iofs.New(migrationsDir, "migrations")
-	if err != nil {
-		return fmt.Errorf("failed to return an iofs driver: %w", err)
-	}
-
-	m, err := migrate.NewWithSourceInstance("iofs", d, dsn)
-	if err != nil {
-		return fmt.Errorf("failed to get a new migrate instance: %w", err)
-	}
-
-	defer func(m *migrate.Migrate) {
-		serr, dberr := m.Close()
-		if serr != nil {
-			log.Printf("close the source failed err: %v", serr)
-		}
-		if dberr != nil {
-			log.Printf("close the database failed err: %v", serr)
-		}
-	}(m)
-
-	if err := m.Up(); err != nil {
-		if !errors.Is(err, migrate.ErrNoChange) {
-			return fmt.Errorf("failed to apply migrations to the DB: %w", err)
-		}
-	}
-
-	return nil
-}
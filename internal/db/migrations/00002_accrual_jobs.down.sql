@@ -0,0 +1,3 @@
+begin transaction;
+drop table accrual_jobs;
+commit;
\ No newline at end of file
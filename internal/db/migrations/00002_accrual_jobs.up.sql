@@ -0,0 +1,21 @@
+begin transaction;
+-- Очередь опроса системы расчёта
+create table accrual_jobs(
+    seq bigint generated always as identity,
+    orderid uuid unique not null,
+    enqueued timestamp with time zone not null,
+    nextattempt timestamp with time zone not null,
+    attempts int not null default 0,
+    lockeduntil timestamp with time zone,
+    lockedby varchar(200),
+    primary key (seq),
+    foreign key (orderid) references orders (id) on delete cascade
+);
+create index accrual_jobs_nextattempt_idx on accrual_jobs (nextattempt, seq);
+-- Заказы, ожидающие расчёта
+insert into accrual_jobs(orderid, enqueued, nextattempt)
+select id, uploaded, current_timestamp
+from orders
+where status in ('NEW', 'PROCESSING')
+order by uploaded;
+commit;
\ No newline at end of file
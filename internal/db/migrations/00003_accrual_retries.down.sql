@@ -0,0 +1,5 @@
+begin transaction;
+alter table orders drop column statusreason;
+alter table accrual_jobs drop column lasterror;
+alter table accrual_jobs drop column failures;
+commit;
\ No newline at end of file
@@ -0,0 +1,6 @@
+begin transaction;
+alter table accrual_jobs add column failures int not null default 0;
+alter table accrual_jobs add column lasterror text;
+-- Причина окончательного статуса заказа
+alter table orders add column statusreason text;
+commit;
\ No newline at end of file
@@ -0,0 +1,6 @@
+begin transaction;
+drop table ledger_postings;
+drop function ledger_postings_append_only;
+drop table ledger_accounts;
+drop type ledger_account_kind;
+commit;
\ No newline at end of file
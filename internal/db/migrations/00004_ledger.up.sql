@@ -0,0 +1,57 @@
+begin transaction;
+create type ledger_account_kind as enum ('USER', 'ACCRUALS', 'WITHDRAWALS');
+-- Счета баллов
+create table ledger_accounts(
+    id uuid default gen_random_uuid(),
+    kind ledger_account_kind not null,
+    userid uuid unique,
+    primary key (id),
+    foreign key (userid) references users (id),
+    check ((kind = 'USER') = (userid is not null))
+);
+create unique index ledger_accounts_system_kind_key on ledger_accounts (kind) where userid is null;
+insert into ledger_accounts(kind) values ('ACCRUALS'), ('WITHDRAWALS');
+insert into ledger_accounts(kind, userid) select 'USER', id from users;
+-- Проводки
+create table ledger_postings(
+    seq bigint generated always as identity,
+    date timestamp with time zone not null,
+    debitaccount uuid not null,
+    creditaccount uuid not null,
+    sum double precision not null check (sum > 0),
+    orderid uuid unique,
+    withdrawalseq int unique,
+    primary key (seq),
+    foreign key (debitaccount) references ledger_accounts (id),
+    foreign key (creditaccount) references ledger_accounts (id),
+    foreign key (orderid) references orders (id),
+    foreign key (withdrawalseq) references withdrawals (seq),
+    check ((orderid is null) <> (withdrawalseq is null))
+);
+create index ledger_postings_debitaccount_idx on ledger_postings (debitaccount);
+create index ledger_postings_creditaccount_idx on ledger_postings (creditaccount);
+-- Журнал проводок только пополняется
+create function ledger_postings_append_only() returns trigger as $$
+begin
+    raise exception 'ledger_postings is append-only';
+end;
+$$ language plpgsql;
+create trigger ledger_postings_append_only
+    before update or delete on ledger_postings
+    for each statement execute function ledger_postings_append_only();
+-- Перенос начисленных и списанных баллов
+insert into ledger_postings(date, debitaccount, creditaccount, sum, orderid)
+select o.uploaded, s.id, u.id, o.sum, o.id
+from orders o
+    join ledger_accounts u on u.userid = o.userid
+    join ledger_accounts s on s.kind = 'ACCRUALS'
+where o.status = 'PROCESSED' and o.sum > 0
+order by o.uploaded;
+insert into ledger_postings(date, debitaccount, creditaccount, sum, withdrawalseq)
+select w.date, u.id, s.id, w.sum, w.seq
+from withdrawals w
+    join ledger_accounts u on u.userid = w.userid
+    join ledger_accounts s on s.kind = 'WITHDRAWALS'
+where w.sum > 0
+order by w.seq;
+commit;
\ No newline at end of file
@@ -0,0 +1,6 @@
+begin transaction;
+alter table ledger_postings alter column sum type double precision;
+alter table currentbalances alter column sum type double precision;
+alter table withdrawals alter column sum type double precision;
+alter table orders alter column sum type double precision;
+commit;
\ No newline at end of file
@@ -0,0 +1,7 @@
+begin transaction;
+-- Баллы с точностью до сотых
+alter table orders alter column sum type numeric(18, 2) using round(sum::numeric, 2);
+alter table withdrawals alter column sum type numeric(18, 2) using round(sum::numeric, 2);
+alter table currentbalances alter column sum type numeric(18, 2) using round(sum::numeric, 2);
+alter table ledger_postings alter column sum type numeric(18, 2) using round(sum::numeric, 2);
+commit;
\ No newline at end of file
@@ -0,0 +1,3 @@
+begin transaction;
+alter table withdrawals drop constraint withdrawals_ordernumber_key;
+commit;
\ No newline at end of file
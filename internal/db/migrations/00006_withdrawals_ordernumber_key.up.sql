@@ -0,0 +1,4 @@
+begin transaction;
+-- Списать баллы в счёт одного заказа можно только один раз
+alter table withdrawals add constraint withdrawals_ordernumber_key unique (ordernumber);
+commit;
\ No newline at end of file
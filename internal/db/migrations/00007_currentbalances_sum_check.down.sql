@@ -0,0 +1,3 @@
+begin transaction;
+alter table currentbalances drop constraint currentbalances_sum_check;
+commit;
\ No newline at end of file
@@ -0,0 +1,4 @@
+begin transaction;
+-- Баланс не уходит в минус
+alter table currentbalances add constraint currentbalances_sum_check check (sum >= 0);
+commit;
\ No newline at end of file
@@ -0,0 +1,3 @@
+begin transaction;
+drop table idempotency_keys;
+commit;
\ No newline at end of file
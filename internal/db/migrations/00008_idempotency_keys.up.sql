@@ -0,0 +1,18 @@
+begin transaction;
+-- Ответы на запросы с Idempotency-Key
+create table idempotency_keys(
+    key varchar(255) not null,
+    userid uuid not null,
+    method varchar(16) not null,
+    path varchar(2048) not null,
+    requesthash varchar(64) not null,
+    status int,
+    contenttype varchar(255),
+    body bytea,
+    created timestamp with time zone not null,
+    expires timestamp with time zone not null,
+    primary key (userid, key),
+    foreign key (userid) references users (id)
+);
+create index idempotency_keys_expires_idx on idempotency_keys (expires);
+commit;
\ No newline at end of file
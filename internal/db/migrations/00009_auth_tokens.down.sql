@@ -0,0 +1,4 @@
+begin transaction;
+drop table revoked_tokens;
+drop table refresh_tokens;
+commit;
\ No newline at end of file
@@ -0,0 +1,23 @@
+begin transaction;
+-- Хэши refresh токенов
+create table refresh_tokens(
+    seq int generated always as identity,
+    tokenhash varchar(64) unique not null,
+    family uuid not null,
+    userid uuid not null,
+    created timestamp with time zone not null,
+    expires timestamp with time zone not null,
+    used timestamp with time zone,
+    revoked timestamp with time zone,
+    primary key (seq),
+    foreign key (userid) references users (id)
+);
+create index refresh_tokens_family_idx on refresh_tokens (family);
+-- Отозванные access токены
+create table revoked_tokens(
+    jti varchar(64) not null,
+    expires timestamp with time zone not null,
+    primary key (jti)
+);
+create index revoked_tokens_expires_idx on revoked_tokens (expires);
+commit;
\ No newline at end of file
@@ -0,0 +1,3 @@
+begin transaction;
+drop table login_attempts;
+commit;
\ No newline at end of file
@@ -0,0 +1,11 @@
+begin transaction;
+-- Неудачные попытки входа
+create table login_attempts(
+    kind varchar(16) not null,
+    key varchar(200) not null,
+    failures int not null,
+    lastfailure timestamp with time zone not null,
+    lockeduntil timestamp with time zone,
+    primary key (kind, key)
+);
+commit;
\ No newline at end of file
@@ -0,0 +1,3 @@
+begin transaction;
+alter table users alter column pass type varchar(64);
+commit;
\ No newline at end of file
@@ -0,0 +1,4 @@
+begin transaction;
+-- Хэш argon2id длиннее хэша bcrypt
+alter table users alter column pass type varchar(255);
+commit;
\ No newline at end of file
@@ -0,0 +1,4 @@
+begin transaction;
+drop table password_reset_tokens;
+alter table users drop column sessionsrevoked;
+commit;
\ No newline at end of file
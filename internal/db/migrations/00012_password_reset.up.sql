@@ -0,0 +1,14 @@
+begin transaction;
+-- Отзыв сессий пользователя
+alter table users add column sessionsrevoked timestamp with time zone;
+-- Хэши токенов сброса пароля
+create table password_reset_tokens(
+    tokenhash varchar(64) not null,
+    userid uuid not null,
+    created timestamp with time zone not null,
+    expires timestamp with time zone not null,
+    primary key (tokenhash),
+    foreign key (userid) references users (id)
+);
+create index password_reset_tokens_userid_idx on password_reset_tokens (userid);
+commit;
\ No newline at end of file
@@ -0,0 +1,4 @@
+begin transaction;
+drop index withdrawals_userid_date_idx;
+drop index orders_userid_uploaded_idx;
+commit;
\ No newline at end of file
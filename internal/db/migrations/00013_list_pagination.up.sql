@@ -0,0 +1,5 @@
+begin transaction;
+-- Индексы постраничных списков
+create index orders_userid_uploaded_idx on orders (userid, uploaded, id);
+create index withdrawals_userid_date_idx on withdrawals (userid, date, seq);
+commit;
\ No newline at end of file
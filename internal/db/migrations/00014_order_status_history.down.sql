@@ -0,0 +1,3 @@
+begin transaction;
+drop table order_status_history;
+commit;
\ No newline at end of file
@@ -0,0 +1,20 @@
+begin transaction;
+-- История статусов заказа
+create table order_status_history(
+    seq bigint generated always as identity,
+    orderid uuid not null,
+    changed timestamp with time zone not null,
+    status order_status not null,
+    sum numeric(18, 2) not null,
+    reason text,
+    response jsonb,
+    primary key (seq),
+    foreign key (orderid) references orders (id)
+);
+create index order_status_history_orderid_idx on order_status_history (orderid, changed, seq);
+-- История загруженных ранее заказов
+insert into order_status_history(orderid, changed, status, sum)
+select id, uploaded, 'NEW', 0 from orders;
+insert into order_status_history(orderid, changed, status, sum, reason)
+select id, now(), status, sum, statusreason from orders where status <> 'NEW';
+commit;
\ No newline at end of file
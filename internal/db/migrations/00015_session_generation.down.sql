@@ -0,0 +1,5 @@
+begin transaction;
+alter table users add column sessionsrevoked timestamp with time zone;
+update users set sessionsrevoked = current_timestamp where sessiongen > 0;
+alter table users drop column sessiongen;
+commit;
\ No newline at end of file
@@ -0,0 +1,6 @@
+begin transaction;
+-- Поколение сессий пользователя
+alter table users add column sessiongen bigint not null default 0;
+update users set sessiongen = 1 where sessionsrevoked is not null;
+alter table users drop column sessionsrevoked;
+commit;
\ No newline at end of file
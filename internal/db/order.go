@@ -14,10 +14,23 @@ import (
 
 func (db *DB) AddOrder(ctx context.Context, order *models.OrderDTO) (*models.Order, error) {
 	sql := `
-	INSERT INTO orders(uploaded, number, userid, status, sum)
-	VALUES (CURRENT_TIMESTAMP, $1, $2, $3, 0)
-	RETURNING 
-		id, uploaded, number, sum, userid, status;`
+	WITH o AS (
+		INSERT INTO orders(uploaded, number, userid, status, sum)
+		VALUES (CURRENT_TIMESTAMP, $1, $2, $3, 0)
+		RETURNING 
+			id, uploaded, number, sum, userid, status
+	), j AS (
+		INSERT INTO accrual_jobs(orderid, enqueued, nextattempt)
+		SELECT id, uploaded, uploaded
+		FROM o
+	), h AS (
+		INSERT INTO order_status_history(orderid, changed, status, sum)
+		SELECT id, uploaded, status, sum
+		FROM o
+	)
+	SELECT 
+		id, uploaded, number, sum, userid, status
+	FROM o;`
 
 	row := db.pool.QueryRow(ctx, sql, order.Number, order.UserID, models.OrderStatusNew)
 
@@ -39,7 +52,7 @@ func (db *DB) AddOrder(ctx context.Context, order *models.OrderDTO) (*models.Ord
 func (db *DB) GetOrder(ctx context.Context, order *models.OrderDTO) (*models.Order, error) {
 	sql := `
 	SELECT 
-		id, uploaded, number, sum, userid, status
+		id, uploaded, number, sum, userid, status, coalesce(statusreason, '')
 	FROM 
 		orders
 	WHERE 
@@ -48,40 +61,19 @@ func (db *DB) GetOrder(ctx context.Context, order *models.OrderDTO) (*models.Ord
 	row := db.pool.QueryRow(ctx, sql, order.Number)
 
 	o := models.Order{}
-	if err := row.Scan(&o.ID, &o.UploadedAt, &o.Number, &o.Accrual, &o.UserID, &o.Status); err != nil {
+	if err := row.Scan(&o.ID, &o.UploadedAt, &o.Number, &o.Accrual, &o.UserID, &o.Status, &o.StatusReason); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrOrderNotFound
+		}
 		return nil, fmt.Errorf("db GetOrder err: %w", err)
 	}
 
 	return &o, nil
 }
 
-func (db *DB) GetOrdersForAccrual(ctx context.Context) ([]*models.Order, error) {
-	sql := `
-	SELECT id, userid, uploaded, number, sum, status
-	FROM orders
-	WHERE status IN ($1, $2)
-	ORDER BY uploaded DESC
-	LIMIT 10;`
-
-	rows, err := db.pool.Query(ctx, sql, models.OrderStatusNew, models.OrderStatusProcessing)
-	if err != nil {
-		return nil, fmt.Errorf("db GetOrdersForAccrual err: %w", err)
-	}
-	defer rows.Close()
-
-	var ors []*models.Order
-	for rows.Next() {
-		var o models.Order
-		if err := rows.Scan(&o.ID, &o.UserID, &o.UploadedAt, &o.Number, &o.Accrual, &o.Status); err != nil {
-			return nil, fmt.Errorf("db GetOrdersForAccrual row scan err: %w", err)
-		}
-		ors = append(ors, &o)
-	}
-
-	return ors, nil
-}
-
-func (db *DB) UpdateOrder(ctx context.Context, order *models.Order) error {
+// UpdateOrder stores the accrual result. When the lease is given, the order is updated and its job is deleted
+// only while the job is still leased by it, otherwise models.ErrAccrualLeaseLost is returned.
+func (db *DB) UpdateOrder(ctx context.Context, order *models.Order, lease *models.AccrualLease) error {
 	tx, err := db.pool.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("unable to start UpdateOrder transaction err: %w", err)
@@ -95,24 +87,65 @@ func (db *DB) UpdateOrder(ctx context.Context, order *models.Order) error {
 		}
 	}(tx)
 
+	if lease != nil {
+		if err := db.lockAccrualLease(ctx, tx, order.ID, lease); err != nil {
+			return fmt.Errorf("failed lock accrual lease. UpdateOrder err: %w", err)
+		}
+	}
+
+	prev, err := db.lockOrder(ctx, tx, order.ID)
+	if err != nil {
+		return fmt.Errorf("failed lock order. UpdateOrder err: %w", err)
+	}
+
+	// The order was finalized by an earlier delivery, so this one must not change it or credit it again.
+	if prev.StatusIsFinal() {
+		if err := db.deleteAccrualJob(ctx, tx, order.ID); err != nil {
+			return fmt.Errorf("failed delete accrual job. UpdateOrder err: %w", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fmt.Errorf("failed commit transaction UpdateOrder err: %w", err)
+		}
+		return nil
+	}
+
 	sql := `
 	UPDATE orders
 	SET
-		uploaded = $2, 
-		number = $3, 
-		userid = $4,
-		sum = $5, 
-		status = $6
+		sum = $3, 
+		status = $4,
+		statusreason = NULLIF($5, '')
 	WHERE
-		id = $1;`
+		id = $1 AND status = $2;`
 
-	if _, err := tx.Exec(ctx, sql,
-		order.ID, order.UploadedAt, order.Number, order.UserID, order.Accrual, order.Status); err != nil {
+	tag, err := tx.Exec(ctx, sql, order.ID, prev.Status, order.Accrual, order.Status, order.StatusReason)
+	if err != nil {
 		return fmt.Errorf("db UpdateOrder err: %w", err)
 	}
+	if tag.RowsAffected() != 1 {
+		return models.ErrOrderStatusChanged
+	}
+
+	// Repeated answers with the same status are not transitions.
+	if order.Status != prev.Status {
+		if err := db.addOrderStatusChange(ctx, tx, order); err != nil {
+			return fmt.Errorf("failed add order status change. UpdateOrder err: %w", err)
+		}
+	}
 
-	if _, err := db.UpdateUserBalance(ctx, tx, order.UserID, order.Accrual); err != nil {
-		return fmt.Errorf("failed update user balance. UpdateUserBalance err: %w", err)
+	if order.Status == models.OrderStatusProcessed {
+		if err := db.postOrderAccrual(ctx, tx, prev.UserID, order.ID, order.Accrual); err != nil {
+			return fmt.Errorf("failed post order accrual. UpdateOrder err: %w", err)
+		}
+		if _, err := db.UpdateUserBalance(ctx, tx, prev.UserID, order.Accrual); err != nil {
+			return fmt.Errorf("failed update user balance. UpdateUserBalance err: %w", err)
+		}
+	}
+
+	if order.StatusIsFinal() {
+		if err := db.deleteAccrualJob(ctx, tx, order.ID); err != nil {
+			return fmt.Errorf("failed delete accrual job. UpdateOrder err: %w", err)
+		}
 	}
 
 	if err := tx.Commit(ctx); err != nil {
@@ -122,9 +155,74 @@ func (db *DB) UpdateOrder(ctx context.Context, order *models.Order) error {
 	return nil
 }
 
+func (db *DB) addOrderStatusChange(ctx context.Context, tx pgx.Tx, order *models.Order) error {
+	sql := `
+	INSERT INTO order_status_history(orderid, changed, status, sum, reason, response)
+	VALUES ($1, CURRENT_TIMESTAMP, $2, $3, NULLIF($4, ''), $5::jsonb);`
+
+	var response *string
+	if len(order.AccrualResponse) > 0 {
+		s := string(order.AccrualResponse)
+		response = &s
+	}
+
+	if _, err := tx.Exec(ctx, sql, order.ID, order.Status, order.Accrual, order.StatusReason, response); err != nil {
+		return fmt.Errorf("db addOrderStatusChange err: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) GetOrderHistory(ctx context.Context, orderID string) ([]*models.OrderStatusChange, error) {
+	sql := `
+	SELECT changed, status, sum, coalesce(reason, ''), response
+	FROM order_status_history
+	WHERE orderid = $1
+	ORDER BY changed, seq;`
+
+	rows, err := db.pool.Query(ctx, sql, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("db GetOrderHistory err: %w", err)
+	}
+	defer rows.Close()
+
+	var h []*models.OrderStatusChange
+	for rows.Next() {
+		var c models.OrderStatusChange
+		var response []byte
+		if err := rows.Scan(&c.ChangedAt, &c.Status, &c.Accrual, &c.Reason, &response); err != nil {
+			return nil, fmt.Errorf("db GetOrderHistory row scan err: %w", err)
+		}
+		c.Response = response
+		h = append(h, &c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db GetOrderHistory rows err: %w", err)
+	}
+
+	return h, nil
+}
+
+func (db *DB) lockOrder(ctx context.Context, tx pgx.Tx, orderID string) (*models.Order, error) {
+	sql := `
+	SELECT id, userid, status
+	FROM orders
+	WHERE id = $1
+	FOR UPDATE;`
+
+	o := models.Order{}
+	row := tx.QueryRow(ctx, sql, orderID)
+	if err := row.Scan(&o.ID, &o.UserID, &o.Status); err != nil {
+		return nil, fmt.Errorf("db lockOrder err: %w", err)
+	}
+
+	return &o, nil
+}
+
 func (db *DB) GetUploadedOrders(ctx context.Context, u *models.User) ([]*models.Order, error) {
 	sql := `
-	SELECT id, uploaded, number, sum, status
+	SELECT id, uploaded, number, sum, status, coalesce(statusreason, '')
 	FROM orders
 	WHERE userId = $1
 	ORDER BY uploaded DESC;`
@@ -138,7 +236,7 @@ func (db *DB) GetUploadedOrders(ctx context.Context, u *models.User) ([]*models.
 	var ors []*models.Order
 	for rows.Next() {
 		var o models.Order
-		if err := rows.Scan(&o.ID, &o.UploadedAt, &o.Number, &o.Accrual, &o.Status); err != nil {
+		if err := rows.Scan(&o.ID, &o.UploadedAt, &o.Number, &o.Accrual, &o.Status, &o.StatusReason); err != nil {
 			return nil, fmt.Errorf("db GetUploadedOrders row scan err: %w", err)
 		}
 		ors = append(ors, &o)
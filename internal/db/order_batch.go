@@ -0,0 +1,168 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+// AddOrders adds the orders in one transaction, the statements are sent in one batch.
+// The numbers registered earlier are left as they are and reported with their owner.
+func (db *DB) AddOrders(ctx context.Context, userID string, numbers []string) ([]*models.OrderBatchResult, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start AddOrders transaction err: %w", err)
+	}
+
+	defer func(tx pgx.Tx) {
+		if err := tx.Rollback(ctx); err != nil {
+			if !errors.Is(err, pgx.ErrTxClosed) {
+				db.log.Errorf("failed rollback transaction AddOrders err: %w", err)
+			}
+		}
+	}(tx)
+
+	sql := `
+	WITH o AS (
+		INSERT INTO orders(uploaded, number, userid, status, sum)
+		VALUES (CURRENT_TIMESTAMP, $1, $2, $3, 0)
+		ON CONFLICT (number) DO NOTHING
+		RETURNING 
+			id, uploaded, status, sum
+	), j AS (
+		INSERT INTO accrual_jobs(orderid, enqueued, nextattempt)
+		SELECT id, uploaded, uploaded
+		FROM o
+	), h AS (
+		INSERT INTO order_status_history(orderid, changed, status, sum)
+		SELECT id, uploaded, status, sum
+		FROM o
+	)
+	SELECT id FROM o;`
+
+	// The numbers are inserted once each in the order of the unique index, so the parallel batches
+	// lock the index entries in the same order and can't deadlock on each other.
+	sent := make(map[string]string, len(numbers))
+	var unique []string
+	for _, n := range numbers {
+		k := orderNumberKey(n)
+		if _, ok := sent[k]; !ok {
+			sent[k] = n
+			unique = append(unique, n)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		ki, kj := orderNumberKey(unique[i]), orderNumberKey(unique[j])
+		if len(ki) != len(kj) {
+			return len(ki) < len(kj)
+		}
+		return ki < kj
+	})
+
+	b := &pgx.Batch{}
+	for _, n := range unique {
+		b.Queue(sql, n, userID, models.OrderStatusNew)
+	}
+
+	// accepted is true until the result is given to the first of the repeated numbers.
+	accepted := make(map[string]bool, len(unique))
+	var conflicts []string
+
+	br := tx.SendBatch(ctx, b)
+	for _, n := range unique {
+		var id string
+		if err := br.QueryRow().Scan(&id); err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				_ = br.Close()
+				return nil, fmt.Errorf("db AddOrders row scan err: %w", err)
+			}
+			conflicts = append(conflicts, n)
+			continue
+		}
+		accepted[n] = true
+	}
+	if err := br.Close(); err != nil {
+		return nil, fmt.Errorf("db AddOrders batch close err: %w", err)
+	}
+
+	owners, err := db.getOrderOwners(ctx, tx, conflicts)
+	if err != nil {
+		return nil, fmt.Errorf("failed get order owners. AddOrders err: %w", err)
+	}
+
+	rs := make([]*models.OrderBatchResult, len(numbers))
+	for i, n := range numbers {
+		k := sent[orderNumberKey(n)]
+
+		if first, ok := accepted[k]; ok {
+			r := models.OrderBatchUploadedByUser
+			if first {
+				r = models.OrderBatchAccepted
+				accepted[k] = false
+			}
+			rs[i] = &models.OrderBatchResult{Number: n, Result: r}
+			continue
+		}
+
+		owner, ok := owners[k]
+		if !ok {
+			return nil, fmt.Errorf("db AddOrders: owner of the order %s is not found", n)
+		}
+
+		r := models.OrderBatchUploadedByAnotherUser
+		if owner == userID {
+			r = models.OrderBatchUploadedByUser
+		}
+		rs[i] = &models.OrderBatchResult{Number: n, Result: r}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed commit transaction AddOrders err: %w", err)
+	}
+
+	return rs, nil
+}
+
+// orderNumberKey returns the number without the leading zeros, the numbers are stored as numerics.
+func orderNumberKey(n string) string {
+	return strings.TrimLeft(n, "0")
+}
+
+// getOrderOwners returns the owners by the numbers as they were given, the numbers are compared as numerics.
+func (db *DB) getOrderOwners(ctx context.Context, tx pgx.Tx, numbers []string) (map[string]string, error) {
+	owners := make(map[string]string, len(numbers))
+	if len(numbers) == 0 {
+		return owners, nil
+	}
+
+	sql := `
+	SELECT n.number, o.userid
+	FROM unnest($1::text[]) AS n(number)
+		JOIN orders o ON o.number = n.number::numeric;`
+
+	rows, err := tx.Query(ctx, sql, numbers)
+	if err != nil {
+		return nil, fmt.Errorf("db getOrderOwners err: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n, owner string
+		if err := rows.Scan(&n, &owner); err != nil {
+			return nil, fmt.Errorf("db getOrderOwners row scan err: %w", err)
+		}
+		owners[n] = owner
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db getOrderOwners rows err: %w", err)
+	}
+
+	return owners, nil
+}
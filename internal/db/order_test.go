@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestDB_UpdateOrderCreditsOnce(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		deliveries  []string
+		wantStatus  string
+		wantBalance models.Amount
+	}{
+		{
+			name:        "processing then processed",
+			deliveries:  []string{models.OrderStatusProcessing, models.OrderStatusProcessed},
+			wantStatus:  models.OrderStatusProcessed,
+			wantBalance: 10000,
+		},
+		{
+			name:        "processed delivered twice",
+			deliveries:  []string{models.OrderStatusProcessed, models.OrderStatusProcessed},
+			wantStatus:  models.OrderStatusProcessed,
+			wantBalance: 10000,
+		},
+		{
+			name: "processed redelivered after processing",
+			deliveries: []string{
+				models.OrderStatusProcessing,
+				models.OrderStatusProcessed,
+				models.OrderStatusProcessing,
+				models.OrderStatusProcessed,
+			},
+			wantStatus:  models.OrderStatusProcessed,
+			wantBalance: 10000,
+		},
+		{
+			name:        "invalid is final",
+			deliveries:  []string{models.OrderStatusInvalid, models.OrderStatusProcessed},
+			wantStatus:  models.OrderStatusInvalid,
+			wantBalance: 0,
+		},
+		{
+			name:        "processing credits nothing",
+			deliveries:  []string{models.OrderStatusProcessing, models.OrderStatusProcessing},
+			wantStatus:  models.OrderStatusProcessing,
+			wantBalance: 0,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			u := newTestUser(t, db)
+			o := newTestOrder(t, db, u)
+
+			for _, status := range tt.deliveries {
+				upd := *o
+				upd.Status = status
+				if status == models.OrderStatusProcessed {
+					upd.Accrual = 10000
+				}
+				require.NoError(t, db.UpdateOrder(ctx, &upd, nil))
+			}
+
+			got, err := db.GetOrder(ctx, &models.OrderDTO{Number: o.Number})
+			require.NoError(t, err)
+			require.Equal(t, tt.wantStatus, got.Status)
+
+			b, err := db.GetBalance(ctx, u.ID)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantBalance, b.Current)
+		})
+	}
+}
+
+func TestDB_UpdateOrderConcurrentRedelivery(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+	o := newTestOrder(t, db, u)
+
+	const deliveries = 50
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < deliveries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			upd := *o
+			upd.Status = models.OrderStatusProcessed
+			upd.Accrual = 10000
+			if err := db.UpdateOrder(ctx, &upd, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	b, err := db.GetBalance(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.Amount(10000), b.Current)
+}
+
+func TestDB_GetOrderHistory(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+	o := newTestOrder(t, db, u)
+
+	deliveries := []struct {
+		status   string
+		accrual  models.Amount
+		response string
+	}{
+		{status: models.OrderStatusProcessing, response: `{"status":"PROCESSING"}`},
+		{status: models.OrderStatusProcessing, response: `{"status":"PROCESSING"}`},
+		{status: models.OrderStatusProcessed, accrual: 500, response: `{"status":"PROCESSED","accrual":5}`},
+	}
+	for _, d := range deliveries {
+		upd := *o
+		upd.Status = d.status
+		upd.Accrual = d.accrual
+		upd.AccrualResponse = []byte(d.response)
+		require.NoError(t, db.UpdateOrder(ctx, &upd, nil))
+	}
+
+	h, err := db.GetOrderHistory(ctx, o.ID)
+	require.NoError(t, err)
+	require.Len(t, h, 3)
+
+	require.Equal(t, models.OrderStatusNew, h[0].Status)
+	require.Empty(t, h[0].Response)
+
+	require.Equal(t, models.OrderStatusProcessing, h[1].Status)
+	require.JSONEq(t, deliveries[0].response, string(h[1].Response))
+
+	require.Equal(t, models.OrderStatusProcessed, h[2].Status)
+	require.Equal(t, models.Amount(500), h[2].Accrual)
+	require.JSONEq(t, deliveries[2].response, string(h[2].Response))
+	require.False(t, h[2].ChangedAt.Before(h[1].ChangedAt))
+}
+
+func TestDB_AddOrders(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+	other := newTestUser(t, db)
+
+	own := newTestOrder(t, db, u)
+	foreign := newTestOrder(t, db, other)
+	fresh := testUniqueSuffix()
+
+	rs, err := db.AddOrders(ctx, u.ID, []string{fresh, own.Number, foreign.Number, fresh})
+	require.NoError(t, err)
+	require.Equal(t, []*models.OrderBatchResult{
+		{Number: fresh, Result: models.OrderBatchAccepted},
+		{Number: own.Number, Result: models.OrderBatchUploadedByUser},
+		{Number: foreign.Number, Result: models.OrderBatchUploadedByAnotherUser},
+		{Number: fresh, Result: models.OrderBatchUploadedByUser},
+	}, rs)
+
+	o, err := db.GetOrder(ctx, &models.OrderDTO{Number: fresh})
+	require.NoError(t, err)
+	require.Equal(t, u.ID, o.UserID)
+	require.Equal(t, models.OrderStatusNew, o.Status)
+
+	h, err := db.GetOrderHistory(ctx, o.ID)
+	require.NoError(t, err)
+	require.Len(t, h, 1)
+}
+
+func TestDB_AddOrdersInParallel(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+	other := newTestUser(t, db)
+
+	numbers := make([]string, 50)
+	for i := range numbers {
+		numbers[i] = testUniqueSuffix()
+	}
+	reversed := make([]string, len(numbers))
+	for i, n := range numbers {
+		reversed[len(numbers)-1-i] = n
+	}
+
+	// The overlapping batches in the opposite order would deadlock if the rows were locked in the request order.
+	wg := &sync.WaitGroup{}
+	for _, batch := range []struct {
+		userID  string
+		numbers []string
+	}{{u.ID, numbers}, {other.ID, reversed}} {
+		batch := batch
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rs, err := db.AddOrders(ctx, batch.userID, batch.numbers)
+			require.NoError(t, err)
+			require.Len(t, rs, len(batch.numbers))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDB_AddOrdersWithLeadingZeros(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+	fresh := testUniqueSuffix()
+
+	rs, err := db.AddOrders(ctx, u.ID, []string{"00" + fresh, fresh})
+	require.NoError(t, err)
+	require.Equal(t, []*models.OrderBatchResult{
+		{Number: "00" + fresh, Result: models.OrderBatchAccepted},
+		{Number: fresh, Result: models.OrderBatchUploadedByUser},
+	}, rs)
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+// pageOrder returns the comparison of the cursor and the direction of the sorting,
+// only these constants are inserted into the SQL text.
+func pageOrder(f *models.ListFilter) (string, string) {
+	if f.Desc() {
+		return "<", "DESC"
+	}
+	return ">", "ASC"
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (db *DB) GetUploadedOrdersPage(ctx context.Context,
+	userID string,
+	f *models.ListFilter) ([]*models.Order, error) {
+	var cursorTime *time.Time
+	var cursorID pgtype.UUID
+	if f.Cursor != nil {
+		if err := cursorID.Scan(f.Cursor.ID); err != nil {
+			return nil, models.ErrCursorIsInvalid
+		}
+		cursorTime = &f.Cursor.Time
+	}
+
+	statuses := f.Statuses
+	if statuses == nil {
+		statuses = []string{}
+	}
+
+	cmp, dir := pageOrder(f)
+	sql := fmt.Sprintf(`
+	SELECT id, uploaded, number, sum, status, coalesce(statusreason, '')
+	FROM orders
+	WHERE userid = $1
+		AND ($2::timestamp with time zone IS NULL OR uploaded >= $2)
+		AND ($3::timestamp with time zone IS NULL OR uploaded < $3)
+		AND (cardinality($4::text[]) = 0 OR status::text = ANY($4))
+		AND ($5::timestamp with time zone IS NULL OR (uploaded, id) %s ($5, $6))
+	ORDER BY uploaded %s, id %s
+	LIMIT $7;`, cmp, dir, dir)
+
+	rows, err := db.pool.Query(ctx, sql,
+		userID, optionalTime(f.From), optionalTime(f.To), statuses, cursorTime, cursorID, f.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("db GetUploadedOrdersPage err: %w", err)
+	}
+	defer rows.Close()
+
+	var ors []*models.Order
+	for rows.Next() {
+		var o models.Order
+		if err := rows.Scan(&o.ID, &o.UploadedAt, &o.Number, &o.Accrual, &o.Status, &o.StatusReason); err != nil {
+			return nil, fmt.Errorf("db GetUploadedOrdersPage row scan err: %w", err)
+		}
+		ors = append(ors, &o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db GetUploadedOrdersPage rows err: %w", err)
+	}
+
+	return ors, nil
+}
+
+func (db *DB) GetWithdrawalPage(ctx context.Context,
+	userID string,
+	f *models.ListFilter) ([]*models.UserWithdrawalsHistory, error) {
+	var cursorTime *time.Time
+	var cursorSeq int64
+	if f.Cursor != nil {
+		seq, err := strconv.ParseInt(f.Cursor.ID, 10, 64)
+		if err != nil {
+			return nil, models.ErrCursorIsInvalid
+		}
+		cursorTime, cursorSeq = &f.Cursor.Time, seq
+	}
+
+	cmp, dir := pageOrder(f)
+	sql := fmt.Sprintf(`
+	SELECT seq, date, ordernumber, sum
+	FROM withdrawals
+	WHERE userid = $1
+		AND ($2::timestamp with time zone IS NULL OR date >= $2)
+		AND ($3::timestamp with time zone IS NULL OR date < $3)
+		AND ($4::timestamp with time zone IS NULL OR (date, seq) %s ($4, $5))
+	ORDER BY date %s, seq %s
+	LIMIT $6;`, cmp, dir, dir)
+
+	rows, err := db.pool.Query(ctx, sql,
+		userID, optionalTime(f.From), optionalTime(f.To), cursorTime, cursorSeq, f.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("db GetWithdrawalPage err: %w", err)
+	}
+	defer rows.Close()
+
+	var ws []*models.UserWithdrawalsHistory
+	for rows.Next() {
+		var w models.UserWithdrawalsHistory
+		if err := rows.Scan(&w.Seq, &w.ProcessedAt, &w.OrderNumber, &w.Sum); err != nil {
+			return nil, fmt.Errorf("db GetWithdrawalPage row scan err: %w", err)
+		}
+		ws = append(ws, &w)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db GetWithdrawalPage rows err: %w", err)
+	}
+
+	return ws, nil
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestDB_GetUploadedOrdersPage(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+
+	want := make(map[string]struct{})
+	for i := 0; i < 5; i++ {
+		o := newTestOrder(t, db, u)
+		want[o.ID] = struct{}{}
+	}
+
+	for _, sort := range []string{models.SortAsc, models.SortDesc} {
+		f := &models.ListFilter{Limit: 2, Sort: sort}
+
+		var got []*models.Order
+		for {
+			p, err := u.GetUploadedOrdersPage(ctx, db, f)
+			require.NoError(t, err)
+			got = append(got, p.Orders...)
+
+			if p.NextCursor == "" {
+				break
+			}
+			f.Cursor, err = models.DecodeCursor(p.NextCursor)
+			require.NoError(t, err)
+		}
+
+		require.Len(t, got, len(want), sort)
+		for i, o := range got {
+			require.Contains(t, want, o.ID)
+			if i == 0 {
+				continue
+			}
+			if sort == models.SortAsc {
+				require.False(t, o.UploadedAt.Before(got[i-1].UploadedAt))
+			} else {
+				require.False(t, o.UploadedAt.After(got[i-1].UploadedAt))
+			}
+		}
+	}
+
+	p, err := u.GetUploadedOrdersPage(ctx, db, &models.ListFilter{
+		Limit:    models.DefaultPageLimit,
+		Statuses: []string{models.OrderStatusProcessed},
+	})
+	require.NoError(t, err)
+	require.Empty(t, p.Orders)
+
+	_, err = u.GetUploadedOrdersPage(ctx, db, &models.ListFilter{
+		Limit:  models.DefaultPageLimit,
+		Cursor: &models.Cursor{ID: "not uuid"},
+	})
+	require.ErrorIs(t, err, models.ErrCursorIsInvalid)
+}
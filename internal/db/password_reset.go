@@ -0,0 +1,171 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func (db *DB) ChangeUserPassword(ctx context.Context, userID string, hash string) (*models.User, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start ChangeUserPassword transaction err: %w", err)
+	}
+
+	defer func(tx pgx.Tx) {
+		if err := tx.Rollback(ctx); err != nil {
+			if !errors.Is(err, pgx.ErrTxClosed) {
+				db.log.Errorf("failed rollback transaction ChangeUserPassword err: %w", err)
+			}
+		}
+	}(tx)
+
+	u, err := changePassword(ctx, tx, userID, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed commit transaction ChangeUserPassword err: %w", err)
+	}
+
+	return u, nil
+}
+
+// changePassword sets the password and revokes all sessions and password reset tokens of the user.
+func changePassword(ctx context.Context, tx pgx.Tx, userID string, hash string) (*models.User, error) {
+	sql := `
+	UPDATE users
+	SET pass = $2, sessiongen = sessiongen + 1
+	WHERE id = $1
+	RETURNING id, login, pass, sessiongen;`
+
+	u := models.User{}
+	row := tx.QueryRow(ctx, sql, userID, hash)
+	if err := row.Scan(&u.ID, &u.Login, &u.PasswordHash, &u.SessionGen); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrUnknowUser
+		}
+		return nil, fmt.Errorf("db changePassword update user err: %w", err)
+	}
+
+	sql = `
+	UPDATE refresh_tokens
+	SET revoked = CURRENT_TIMESTAMP
+	WHERE userid = $1 AND revoked IS NULL;`
+
+	if _, err := tx.Exec(ctx, sql, userID); err != nil {
+		return nil, fmt.Errorf("db changePassword revoke refresh tokens err: %w", err)
+	}
+
+	sql = `
+	DELETE FROM password_reset_tokens
+	WHERE userid = $1;`
+
+	if _, err := tx.Exec(ctx, sql, userID); err != nil {
+		return nil, fmt.Errorf("db changePassword delete reset tokens err: %w", err)
+	}
+
+	return &u, nil
+}
+
+func (db *DB) AddPasswordResetToken(ctx context.Context, t *models.PasswordResetToken) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to start AddPasswordResetToken transaction err: %w", err)
+	}
+
+	defer func(tx pgx.Tx) {
+		if err := tx.Rollback(ctx); err != nil {
+			if !errors.Is(err, pgx.ErrTxClosed) {
+				db.log.Errorf("failed rollback transaction AddPasswordResetToken err: %w", err)
+			}
+		}
+	}(tx)
+
+	sql := `
+	DELETE FROM password_reset_tokens
+	WHERE userid = $1;`
+
+	if _, err := tx.Exec(ctx, sql, t.UserID); err != nil {
+		return fmt.Errorf("db AddPasswordResetToken delete err: %w", err)
+	}
+
+	sql = `
+	INSERT INTO password_reset_tokens(tokenhash, userid, created, expires)
+	VALUES ($1, $2, CURRENT_TIMESTAMP, $3);`
+
+	if _, err := tx.Exec(ctx, sql, t.Hash, t.UserID, t.ExpiresAt); err != nil {
+		return fmt.Errorf("db AddPasswordResetToken insert err: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed commit transaction AddPasswordResetToken err: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) GetPasswordResetUser(ctx context.Context, tokenHash string) (*models.User, error) {
+	sql := `
+	SELECT u.id, u.login, u.pass
+	FROM password_reset_tokens AS t
+	INNER JOIN users AS u ON u.id = t.userid
+	WHERE t.tokenhash = $1 AND t.expires > CURRENT_TIMESTAMP;`
+
+	u := models.User{}
+	row := db.pool.QueryRow(ctx, sql, tokenHash)
+	if err := row.Scan(&u.ID, &u.Login, &u.PasswordHash); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrPasswordResetTokenIsInvalid
+		}
+		return nil, fmt.Errorf("db GetPasswordResetUser row scan err: %w", err)
+	}
+
+	return &u, nil
+}
+
+func (db *DB) ResetUserPassword(ctx context.Context, tokenHash string, hash string) (*models.User, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start ResetUserPassword transaction err: %w", err)
+	}
+
+	defer func(tx pgx.Tx) {
+		if err := tx.Rollback(ctx); err != nil {
+			if !errors.Is(err, pgx.ErrTxClosed) {
+				db.log.Errorf("failed rollback transaction ResetUserPassword err: %w", err)
+			}
+		}
+	}(tx)
+
+	// The token is deleted in the same transaction, so it can not be used twice by concurrent requests.
+	sql := `
+	DELETE FROM password_reset_tokens
+	WHERE tokenhash = $1 AND expires > CURRENT_TIMESTAMP
+	RETURNING userid;`
+
+	var userID string
+	row := tx.QueryRow(ctx, sql, tokenHash)
+	if err := row.Scan(&userID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrPasswordResetTokenIsInvalid
+		}
+		return nil, fmt.Errorf("db ResetUserPassword delete token err: %w", err)
+	}
+
+	u, err := changePassword(ctx, tx, userID, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed commit transaction ResetUserPassword err: %w", err)
+	}
+
+	return u, nil
+}
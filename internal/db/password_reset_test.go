@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestDB_ChangeUserPasswordRevokesSessions(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+
+	rt, err := models.NewRefreshToken(u.ID, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, db.AddRefreshToken(ctx, rt))
+
+	changed, err := db.ChangeUserPassword(ctx, u.ID, "new hash")
+	require.NoError(t, err)
+	require.Equal(t, u.SessionGen+1, changed.SessionGen)
+
+	got, err := db.GetUserByID(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, "new hash", got.PasswordHash)
+	require.Equal(t, changed.SessionGen, got.SessionGen)
+
+	revoked, err := db.AccessTokenIsRevoked(ctx, "jti"+testUniqueSuffix(), u.ID, u.SessionGen)
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	revoked, err = db.AccessTokenIsRevoked(ctx, "jti"+testUniqueSuffix(), u.ID, changed.SessionGen)
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	next, err := models.NewRefreshToken("", time.Hour)
+	require.NoError(t, err)
+	_, err = db.RotateRefreshToken(ctx, rt.Hash, next)
+	require.ErrorIs(t, err, models.ErrRefreshTokenIsInvalid)
+}
+
+func TestDB_ResetUserPassword(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+
+	first, err := models.NewPasswordResetToken(u.ID, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, db.AddPasswordResetToken(ctx, first))
+
+	second, err := models.NewPasswordResetToken(u.ID, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, db.AddPasswordResetToken(ctx, second))
+
+	_, err = db.GetPasswordResetUser(ctx, first.Hash)
+	require.ErrorIs(t, err, models.ErrPasswordResetTokenIsInvalid)
+
+	ru, err := db.GetPasswordResetUser(ctx, second.Hash)
+	require.NoError(t, err)
+	require.Equal(t, u.ID, ru.ID)
+
+	ru, err = db.ResetUserPassword(ctx, second.Hash, "reset hash")
+	require.NoError(t, err)
+	require.Equal(t, "reset hash", ru.PasswordHash)
+
+	_, err = db.ResetUserPassword(ctx, second.Hash, "other hash")
+	require.ErrorIs(t, err, models.ErrPasswordResetTokenIsInvalid)
+}
+
+func TestDB_ResetUserPasswordExpired(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+
+	rt, err := models.NewPasswordResetToken(u.ID, -time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, db.AddPasswordResetToken(ctx, rt))
+
+	_, err = db.GetPasswordResetUser(ctx, rt.Hash)
+	require.ErrorIs(t, err, models.ErrPasswordResetTokenIsInvalid)
+
+	_, err = db.ResetUserPassword(ctx, rt.Hash, "reset hash")
+	require.ErrorIs(t, err, models.ErrPasswordResetTokenIsInvalid)
+}
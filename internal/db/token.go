@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func (db *DB) AddRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	sql := `
+	INSERT INTO refresh_tokens(tokenhash, family, userid, created, expires)
+	VALUES ($1, gen_random_uuid(), $2, CURRENT_TIMESTAMP, $3)
+	RETURNING family;`
+
+	row := db.pool.QueryRow(ctx, sql, t.Hash, t.UserID, t.ExpiresAt)
+	if err := row.Scan(&t.Family); err != nil {
+		return fmt.Errorf("db AddRefreshToken err: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) RotateRefreshToken(ctx context.Context,
+	hash string,
+	next *models.RefreshToken) (*models.User, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start RotateRefreshToken transaction err: %w", err)
+	}
+
+	defer func(tx pgx.Tx) {
+		if err := tx.Rollback(ctx); err != nil {
+			if !errors.Is(err, pgx.ErrTxClosed) {
+				db.log.Errorf("failed rollback transaction RotateRefreshToken err: %w", err)
+			}
+		}
+	}(tx)
+
+	sql := `
+	SELECT userid, family, expires < CURRENT_TIMESTAMP, used IS NOT NULL, revoked IS NOT NULL
+	FROM refresh_tokens
+	WHERE tokenhash = $1
+	FOR UPDATE;`
+
+	var userID, family string
+	var expired, used, revoked bool
+	row := tx.QueryRow(ctx, sql, hash)
+	if err := row.Scan(&userID, &family, &expired, &used, &revoked); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrRefreshTokenIsInvalid
+		}
+		return nil, fmt.Errorf("db RotateRefreshToken select err: %w", err)
+	}
+
+	if used {
+		// The token was already exchanged, so it was stolen either from the client or from the thief.
+		// Every token of the family is revoked and the user has to log in again.
+		if err := db.revokeRefreshTokenFamily(ctx, tx, family); err != nil {
+			return nil, fmt.Errorf("unable to revoke refresh token family err: %w", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("failed commit transaction RotateRefreshToken err: %w", err)
+		}
+		return nil, models.ErrRefreshTokenReused
+	}
+
+	if expired || revoked {
+		return nil, models.ErrRefreshTokenIsInvalid
+	}
+
+	sql = `
+	UPDATE refresh_tokens
+	SET used = CURRENT_TIMESTAMP
+	WHERE tokenhash = $1;`
+
+	if _, err := tx.Exec(ctx, sql, hash); err != nil {
+		return nil, fmt.Errorf("db RotateRefreshToken update err: %w", err)
+	}
+
+	sql = `
+	INSERT INTO refresh_tokens(tokenhash, family, userid, created, expires)
+	VALUES ($1, $2, $3, CURRENT_TIMESTAMP, $4);`
+
+	if _, err := tx.Exec(ctx, sql, next.Hash, family, userID, next.ExpiresAt); err != nil {
+		return nil, fmt.Errorf("db RotateRefreshToken insert err: %w", err)
+	}
+	next.UserID = userID
+	next.Family = family
+
+	sql = `
+	SELECT id, login, pass, sessiongen
+	FROM users
+	WHERE id = $1;`
+
+	u := models.User{}
+	row = tx.QueryRow(ctx, sql, userID)
+	if err := row.Scan(&u.ID, &u.Login, &u.PasswordHash, &u.SessionGen); err != nil {
+		return nil, fmt.Errorf("db RotateRefreshToken user err: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed commit transaction RotateRefreshToken err: %w", err)
+	}
+
+	return &u, nil
+}
+
+func (db *DB) revokeRefreshTokenFamily(ctx context.Context, tx pgx.Tx, family string) error {
+	sql := `
+	UPDATE refresh_tokens
+	SET revoked = CURRENT_TIMESTAMP
+	WHERE family = $1 AND revoked IS NULL;`
+
+	if _, err := tx.Exec(ctx, sql, family); err != nil {
+		return fmt.Errorf("db revokeRefreshTokenFamily err: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) RevokeRefreshToken(ctx context.Context, userID string, hash string) error {
+	sql := `
+	UPDATE refresh_tokens
+	SET revoked = CURRENT_TIMESTAMP
+	WHERE revoked IS NULL AND family = (
+		SELECT family
+		FROM refresh_tokens
+		WHERE tokenhash = $1 AND userid = $2
+	);`
+
+	if _, err := db.pool.Exec(ctx, sql, hash, userID); err != nil {
+		return fmt.Errorf("db RevokeRefreshToken err: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to start RevokeAccessToken transaction err: %w", err)
+	}
+
+	defer func(tx pgx.Tx) {
+		if err := tx.Rollback(ctx); err != nil {
+			if !errors.Is(err, pgx.ErrTxClosed) {
+				db.log.Errorf("failed rollback transaction RevokeAccessToken err: %w", err)
+			}
+		}
+	}(tx)
+
+	// Expired tokens are rejected anyway, so there is no need to keep them in the list.
+	sql := `
+	DELETE FROM revoked_tokens
+	WHERE expires < CURRENT_TIMESTAMP;`
+
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("db RevokeAccessToken delete expired err: %w", err)
+	}
+
+	sql = `
+	INSERT INTO revoked_tokens(jti, expires)
+	VALUES ($1, $2)
+	ON CONFLICT (jti) DO NOTHING;`
+
+	if _, err := tx.Exec(ctx, sql, jti, expiresAt); err != nil {
+		return fmt.Errorf("db RevokeAccessToken insert err: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed commit transaction RevokeAccessToken err: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) AccessTokenIsRevoked(ctx context.Context, jti string, userID string, sessionGen int64) (bool, error) {
+	sql := `
+	SELECT EXISTS(
+		SELECT 1
+		FROM revoked_tokens
+		WHERE jti = $1
+	) OR EXISTS(
+		SELECT 1
+		FROM users
+		WHERE id = $2 AND sessiongen > $3
+	);`
+
+	var revoked bool
+	row := db.pool.QueryRow(ctx, sql, jti, userID, sessionGen)
+	if err := row.Scan(&revoked); err != nil {
+		return false, fmt.Errorf("db AccessTokenIsRevoked err: %w", err)
+	}
+
+	return revoked, nil
+}
+
+func (db *DB) AccessTokenIDIsRevoked(ctx context.Context, jti string) (bool, error) {
+	sql := `
+	SELECT EXISTS(
+		SELECT 1
+		FROM revoked_tokens
+		WHERE jti = $1
+	);`
+
+	var revoked bool
+	row := db.pool.QueryRow(ctx, sql, jti)
+	if err := row.Scan(&revoked); err != nil {
+		return false, fmt.Errorf("db AccessTokenIDIsRevoked err: %w", err)
+	}
+
+	return revoked, nil
+}
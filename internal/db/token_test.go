@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestDB_RotateRefreshTokenReuse(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+
+	first, err := models.NewRefreshToken(u.ID, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, db.AddRefreshToken(ctx, first))
+
+	second, err := models.NewRefreshToken("", time.Hour)
+	require.NoError(t, err)
+	ru, err := db.RotateRefreshToken(ctx, first.Hash, second)
+	require.NoError(t, err)
+	require.Equal(t, u.ID, ru.ID)
+	require.Equal(t, first.Family, second.Family)
+
+	third, err := models.NewRefreshToken("", time.Hour)
+	require.NoError(t, err)
+	_, err = db.RotateRefreshToken(ctx, first.Hash, third)
+	require.ErrorIs(t, err, models.ErrRefreshTokenReused)
+
+	_, err = db.RotateRefreshToken(ctx, second.Hash, third)
+	require.ErrorIs(t, err, models.ErrRefreshTokenIsInvalid)
+}
+
+func TestDB_RotateRefreshTokenExpired(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+
+	rt, err := models.NewRefreshToken(u.ID, -time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, db.AddRefreshToken(ctx, rt))
+
+	next, err := models.NewRefreshToken("", time.Hour)
+	require.NoError(t, err)
+	_, err = db.RotateRefreshToken(ctx, rt.Hash, next)
+	require.ErrorIs(t, err, models.ErrRefreshTokenIsInvalid)
+}
+
+func TestDB_RevokeAccessToken(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	u := newTestUser(t, db)
+	jti := "jti" + testUniqueSuffix()
+
+	revoked, err := db.AccessTokenIsRevoked(ctx, jti, u.ID, u.SessionGen)
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	revoked, err = db.AccessTokenIDIsRevoked(ctx, jti)
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	require.NoError(t, db.RevokeAccessToken(ctx, jti, time.Now().Add(time.Hour)))
+	require.NoError(t, db.RevokeAccessToken(ctx, jti, time.Now().Add(time.Hour)))
+
+	revoked, err = db.AccessTokenIsRevoked(ctx, jti, u.ID, u.SessionGen)
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	revoked, err = db.AccessTokenIDIsRevoked(ctx, jti)
+	require.NoError(t, err)
+	require.True(t, revoked)
+}
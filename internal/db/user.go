@@ -38,14 +38,14 @@ func (db *DB) AddUser(ctx context.Context, us *models.UserDTO) (*models.User, er
 
 func (db *DB) GetUser(ctx context.Context, us *models.UserDTO) (*models.User, error) {
 	sql := `
-	SELECT id, login, pass
+	SELECT id, login, pass, sessiongen
 	FROM users
 	WHERE login = $1;`
 
 	row := db.pool.QueryRow(ctx, sql, us.Login)
 
 	u := models.User{}
-	if err := row.Scan(&u.ID, &u.Login, &u.PasswordHash); err != nil {
+	if err := row.Scan(&u.ID, &u.Login, &u.PasswordHash, &u.SessionGen); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, models.ErrUnknowUser
 		}
@@ -54,3 +54,40 @@ func (db *DB) GetUser(ctx context.Context, us *models.UserDTO) (*models.User, er
 
 	return &u, nil
 }
+
+func (db *DB) GetUserByID(ctx context.Context, userID string) (*models.User, error) {
+	sql := `
+	SELECT id, login, pass, sessiongen
+	FROM users
+	WHERE id = $1;`
+
+	row := db.pool.QueryRow(ctx, sql, userID)
+
+	u := models.User{}
+	if err := row.Scan(&u.ID, &u.Login, &u.PasswordHash, &u.SessionGen); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrUnknowUser
+		}
+		return nil, fmt.Errorf("db GetUserByID row scan err: %w", err)
+	}
+
+	return &u, nil
+}
+
+func (db *DB) UpdateUserPassword(ctx context.Context, userID string, hash string) error {
+	sql := `
+	UPDATE users
+	SET pass = $2
+	WHERE id = $1;`
+
+	tag, err := db.pool.Exec(ctx, sql, userID, hash)
+	if err != nil {
+		return fmt.Errorf("db UpdateUserPassword err: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrUnknowUser
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+// Package migrate applies the SQL migrations embedded into a service to its database.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// Up applies the migrations from the directory of fsys that are not applied yet.
+func Up(fsys fs.FS, dir string, dsn string) error {
+	d, err := iofs.New(fsys, dir)
+	if err != nil {
+		return fmt.Errorf("failed to return an iofs driver: %w", err)
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", d, dsn)
+	if err != nil {
+		return fmt.Errorf("failed to get a new migrate instance: %w", err)
+	}
+
+	defer func(m *migrate.Migrate) {
+		serr, dberr := m.Close()
+		if serr != nil {
+			log.Printf("close the source failed err: %v", serr)
+		}
+		if dberr != nil {
+			log.Printf("close the database failed err: %v", dberr)
+		}
+	}(m)
+
+	if err := m.Up(); err != nil {
+		if !errors.Is(err, migrate.ErrNoChange) {
+			return fmt.Errorf("failed to apply migrations to the DB: %w", err)
+		}
+	}
+
+	return nil
+}
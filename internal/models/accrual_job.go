@@ -0,0 +1,65 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type AccrualJob struct {
+	EnqueuedAt time.Time
+	Order      *Order
+	Lease      AccrualLease
+	Attempts   int
+	Failures   int
+}
+
+// AccrualLease fences off the worker whose lease has expired, every lease increments the attempt.
+type AccrualLease struct {
+	Owner   string
+	Attempt int
+}
+
+var ErrAccrualLeaseLost = errors.New("the accrual job lease was lost")
+
+type AccrualJobStorage interface {
+	LeaseOrdersForAccrual(ctx context.Context, owner string, limit int, lease time.Duration) ([]*AccrualJob, error)
+	RescheduleOrderAccrual(ctx context.Context, orderID string, lease AccrualLease, delay time.Duration) error
+	RetryOrderAccrual(ctx context.Context, orderID string, lease AccrualLease, delay time.Duration, reason string) error
+	UpdateOrder(ctx context.Context, order *Order, lease *AccrualLease) error
+}
+
+func LeaseOrdersForAccrual(ctx context.Context,
+	db AccrualJobStorage,
+	owner string,
+	limit int,
+	lease time.Duration) ([]*AccrualJob, error) {
+	jobs, err := db.LeaseOrdersForAccrual(ctx, owner, limit, lease)
+	if err != nil {
+		return nil, fmt.Errorf("lease orders for accrual was failed err: %w", err)
+	}
+	return jobs, nil
+}
+
+func (j *AccrualJob) Reschedule(ctx context.Context, db AccrualJobStorage, delay time.Duration) error {
+	if err := db.RescheduleOrderAccrual(ctx, j.Order.ID, j.Lease, delay); err != nil {
+		return fmt.Errorf("reschedule order accrual was failed err: %w", err)
+	}
+	return nil
+}
+
+func (j *AccrualJob) Retry(ctx context.Context, db AccrualJobStorage, delay time.Duration, reason string) error {
+	if err := db.RetryOrderAccrual(ctx, j.Order.ID, j.Lease, delay, reason); err != nil {
+		return fmt.Errorf("retry order accrual was failed err: %w", err)
+	}
+	return nil
+}
+
+// UpdateOrder stores the order of the job while the job is still leased.
+func (j *AccrualJob) UpdateOrder(ctx context.Context, db AccrualJobStorage) error {
+	if err := db.UpdateOrder(ctx, j.Order, &j.Lease); err != nil {
+		return fmt.Errorf("update order was failed err: %w", err)
+	}
+	return nil
+}
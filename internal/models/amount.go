@@ -0,0 +1,134 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount is a number of loyalty points kept in hundredths, so that sums of amounts are exact.
+type Amount int64
+
+const amountScale = 100
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// ParseAmount parses the amount exactly, the amount with more than two decimal places is invalid.
+func ParseAmount(s string) (Amount, error) {
+	r, err := parseCents(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if !r.IsInt() {
+		return 0, fmt.Errorf("%w: %q has more than two decimal places", ErrInvalidAmount, s)
+	}
+
+	return ratAmount(s, r)
+}
+
+// RoundAmount parses the amount rounding it half away from zero to hundredths,
+// so 7.295 is 7.30. It is used for the amounts the service doesn't control.
+func RoundAmount(s string) (Amount, error) {
+	r, err := parseCents(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if !r.IsInt() {
+		half := big.NewRat(1, 2)
+		if r.Sign() < 0 {
+			half.Neg(half)
+		}
+		r.Add(r, half)
+		r.SetInt(new(big.Int).Quo(r.Num(), r.Denom()))
+	}
+
+	return ratAmount(s, r)
+}
+
+// parseCents returns the number of hundredths in the amount.
+func parseCents(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || strings.ContainsRune(s, '/') {
+		return nil, fmt.Errorf("%w: %q is not a number", ErrInvalidAmount, s)
+	}
+
+	return r.Mul(r, big.NewRat(amountScale, 1)), nil
+}
+
+func ratAmount(s string, r *big.Rat) (Amount, error) {
+	if !r.Num().IsInt64() {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, s)
+	}
+
+	return Amount(r.Num().Int64()), nil
+}
+
+func (a Amount) String() string {
+	v := uint64(a)
+	sign := ""
+	if a < 0 {
+		v = -v
+		sign = "-"
+	}
+
+	units, cents := v/amountScale, v%amountScale
+	if cents == 0 {
+		return sign + strconv.FormatUint(units, 10)
+	}
+
+	return strings.TrimSuffix(fmt.Sprintf("%s%d.%02d", sign, units, cents), "0")
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+
+	v, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+
+	*a = v
+	return nil
+}
+
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+func (a *Amount) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+		return nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case int64:
+		*a = Amount(v * amountScale)
+		return nil
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidAmount, src)
+	}
+
+	v, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+
+	*a = v
+	return nil
+}
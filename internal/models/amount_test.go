@@ -0,0 +1,125 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    Amount
+		wantErr bool
+	}{
+		{name: "integer", s: "500", want: 50000},
+		{name: "one decimal", s: "500.5", want: 50050},
+		{name: "two decimals", s: "729.98", want: 72998},
+		{name: "trailing zeros", s: "729.9800", want: 72998},
+		{name: "exponent", s: "1.5e2", want: 15000},
+		{name: "negative", s: "-0.01", want: -1},
+		{name: "too precise", s: "0.001", wantErr: true},
+		{name: "fraction", s: "1/4", wantErr: true},
+		{name: "not a number", s: "abc", wantErr: true},
+		{name: "out of range", s: "1e30", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAmount(tt.s)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidAmount)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRoundAmount(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    Amount
+		wantErr bool
+	}{
+		{name: "two decimals", s: "729.98", want: 72998},
+		{name: "half up", s: "7.295", want: 730},
+		{name: "below half", s: "7.2949", want: 729},
+		{name: "less than a cent", s: "0.001", want: 0},
+		{name: "negative half", s: "-0.005", want: -1},
+		{name: "exponent", s: "1.2345e1", want: 1235},
+		{name: "fraction", s: "1/4", wantErr: true},
+		{name: "not a number", s: "abc", wantErr: true},
+		{name: "out of range", s: "1e30", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RoundAmount(tt.s)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidAmount)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		name string
+		a    Amount
+		want string
+	}{
+		{name: "zero", a: 0, want: "0"},
+		{name: "integer", a: 50000, want: "500"},
+		{name: "one decimal", a: 50050, want: "500.5"},
+		{name: "two decimals", a: 72998, want: "729.98"},
+		{name: "cents", a: 1, want: "0.01"},
+		{name: "negative", a: -72998, want: "-729.98"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.a.String())
+		})
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	b := UserBalance{Current: 50050, Withdrawn: 4200}
+
+	got, err := json.Marshal(&b)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"current": 500.5, "withdrawn": 42}`, string(got))
+
+	var oa OrderAccrual
+	require.NoError(t, json.Unmarshal([]byte(`{"order": "1", "status": "PROCESSED", "accrual": 729.98}`), &oa))
+	require.Equal(t, Amount(72998), oa.Accrual)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"order": "1", "status": "PROCESSED", "accrual": 7.295}`), &oa))
+	require.Equal(t, Amount(730), oa.Accrual)
+	require.Equal(t, "1", oa.OrderNumber)
+
+	require.Error(t, json.Unmarshal([]byte(`{"accrual": "7.29"}`), &oa))
+
+	var ub UserBalance
+	require.Error(t, json.Unmarshal([]byte(`{"current": 0.001}`), &ub))
+}
+
+func TestAmountIsExact(t *testing.T) {
+	var sum Amount
+	for i := 0; i < 1000; i++ {
+		sum += 72998
+		sum += 1
+	}
+	for i := 0; i < 1000; i++ {
+		sum -= 72999
+	}
+	require.Equal(t, Amount(0), sum)
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// IdempotencyKey is a client supplied key of a mutating request together with the response it got.
+// Status is zero while the first request with the key is still being processed.
+// CreatedAt tells the request that took the key from the request that took it over after StaleTimeout,
+// when the request in progress is considered lost, for example with the instance that crashed.
+type IdempotencyKey struct {
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	StaleTimeout time.Duration
+	Key          string
+	UserID       string
+	Method       string
+	Path         string
+	RequestHash  string
+	ContentType  string
+	Body         []byte
+	Status       int
+}
+
+type IdempotencyStorage interface {
+	StartIdempotentRequest(ctx context.Context, k *IdempotencyKey) (*IdempotencyKey, bool, error)
+	FinishIdempotentRequest(ctx context.Context, k *IdempotencyKey) error
+	CancelIdempotentRequest(ctx context.Context, k *IdempotencyKey) error
+	PurgeIdempotencyKeys(ctx context.Context) (int64, error)
+}
+
+// Start saves the key unless it is already known. If it is, the saved key is returned and started is false.
+func (k *IdempotencyKey) Start(ctx context.Context, db IdempotencyStorage) (*IdempotencyKey, bool, error) {
+	saved, started, err := db.StartIdempotentRequest(ctx, k)
+	if err != nil {
+		return nil, false, fmt.Errorf("start idempotent request was failed err: %w", err)
+	}
+	return saved, started, nil
+}
+
+func (k *IdempotencyKey) Finish(ctx context.Context, db IdempotencyStorage) error {
+	if err := db.FinishIdempotentRequest(ctx, k); err != nil {
+		return fmt.Errorf("finish idempotent request was failed err: %w", err)
+	}
+	return nil
+}
+
+func (k *IdempotencyKey) Cancel(ctx context.Context, db IdempotencyStorage) error {
+	if err := db.CancelIdempotentRequest(ctx, k); err != nil {
+		return fmt.Errorf("cancel idempotent request was failed err: %w", err)
+	}
+	return nil
+}
+
+// PurgeIdempotencyKeys deletes the expired keys and returns the number of them.
+func PurgeIdempotencyKeys(ctx context.Context, db IdempotencyStorage) (int64, error) {
+	n, err := db.PurgeIdempotencyKeys(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("purge idempotency keys was failed err: %w", err)
+	}
+	return n, nil
+}
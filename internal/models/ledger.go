@@ -0,0 +1,12 @@
+package models
+
+const LedgerAccountUser = "USER"
+const LedgerAccountAccruals = "ACCRUALS"
+const LedgerAccountWithdrawals = "WITHDRAWALS"
+
+// LedgerMismatch is a user whose current balance differs from the sum of the ledger postings.
+type LedgerMismatch struct {
+	UserID  string
+	Ledger  Amount
+	Balance Amount
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	LoginAttemptLogin = "LOGIN"
+	LoginAttemptIP    = "IP"
+	// Password reset requests are counted apart from the failed logins.
+	LoginAttemptResetLogin = "RESET_LOGIN"
+	LoginAttemptResetIP    = "RESET_IP"
+)
+
+// LoginAttemptKey is the login or the client IP address failed login attempts are counted by.
+type LoginAttemptKey struct {
+	Kind  string
+	Value string
+}
+
+// LoginLockoutPolicy locks the key out for BaseDelay after FreeAttempts failures in a row,
+// the delay is doubled on every next failure up to MaxDelay. Failures older than Window are forgotten.
+type LoginLockoutPolicy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Window       time.Duration
+}
+
+type LoginAttemptStorage interface {
+	StartLoginAttempt(ctx context.Context, k LoginAttemptKey, p LoginLockoutPolicy) (time.Time, error)
+	CancelLoginAttempt(ctx context.Context, k LoginAttemptKey, p LoginLockoutPolicy) error
+	ResetLoginFailures(ctx context.Context, k LoginAttemptKey) error
+	PurgeLoginAttempts(ctx context.Context, olderThan time.Duration) (int64, error)
+}
+
+func (p LoginLockoutPolicy) Delay(failures int) time.Duration {
+	if failures < p.FreeAttempts {
+		return 0
+	}
+
+	d := p.BaseDelay
+	for i := p.FreeAttempts; i < failures; i++ {
+		d *= 2
+		if d >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+
+	if d > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return d
+}
+
+// Start counts the attempt as failed before it is made, so the parallel attempts can't pass the lockout together.
+// If the key is locked out, the attempt is not counted and the time until which it is locked is returned.
+func (k LoginAttemptKey) Start(ctx context.Context,
+	db LoginAttemptStorage,
+	p LoginLockoutPolicy) (time.Time, error) {
+	t, err := db.StartLoginAttempt(ctx, k, p)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("start login attempt was failed err: %w", err)
+	}
+	return t, nil
+}
+
+// Cancel takes back the attempt counted by Start when it didn't fail.
+func (k LoginAttemptKey) Cancel(ctx context.Context, db LoginAttemptStorage, p LoginLockoutPolicy) error {
+	if err := db.CancelLoginAttempt(ctx, k, p); err != nil {
+		return fmt.Errorf("cancel login attempt was failed err: %w", err)
+	}
+	return nil
+}
+
+func (k LoginAttemptKey) Reset(ctx context.Context, db LoginAttemptStorage) error {
+	if err := db.ResetLoginFailures(ctx, k); err != nil {
+		return fmt.Errorf("reset login failures was failed err: %w", err)
+	}
+	return nil
+}
+
+// PurgeLoginAttempts deletes the keys that are not locked out and have no failures for olderThan,
+// and returns the number of them. Most of them are the logins that don't exist.
+func PurgeLoginAttempts(ctx context.Context, db LoginAttemptStorage, olderThan time.Duration) (int64, error) {
+	n, err := db.PurgeLoginAttempts(ctx, olderThan)
+	if err != nil {
+		return 0, fmt.Errorf("purge login attempts was failed err: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginLockoutPolicy_Delay(t *testing.T) {
+	p := LoginLockoutPolicy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     10 * time.Second,
+	}
+
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{name: "#1 first failure", failures: 1, want: 0},
+		{name: "#2 last free failure", failures: 2, want: 0},
+		{name: "#3 lockout", failures: 3, want: time.Second},
+		{name: "#4 progressive lockout", failures: 4, want: 2 * time.Second},
+		{name: "#5 progressive lockout", failures: 6, want: 8 * time.Second},
+		{name: "#6 max lockout", failures: 7, want: 10 * time.Second},
+		{name: "#7 max lockout", failures: 1000, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Delay(tt.failures); got != tt.want {
+				t.Errorf("LoginLockoutPolicy.Delay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
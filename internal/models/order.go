@@ -2,6 +2,7 @@ package models
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -18,23 +19,45 @@ const OrderStatusInvalid = "INVALID"
 const OrderStatusProcessed = "PROCESSED"
 
 type Order struct {
-	UploadedAt time.Time `json:"uploaded_at"`
-	ID         string    `json:"uuid"`
-	UserID     string    `json:"userId,omitempty"`
-	Status     string    `json:"status"`
-	Number     string    `json:"number"`
-	Accrual    float64   `json:"accrual"`
+	UploadedAt   time.Time `json:"uploaded_at"`
+	ID           string    `json:"uuid"`
+	UserID       string    `json:"userId,omitempty"`
+	Status       string    `json:"status"`
+	StatusReason string    `json:"status_reason,omitempty"`
+	Number       string    `json:"number"`
+	Accrual      Amount    `json:"accrual"`
+	// AccrualResponse is the accrual system response that caused the update, it is kept in the status history.
+	AccrualResponse json.RawMessage `json:"-"`
+}
+
+// OrderStatusChange is a transition of the order to the status.
+type OrderStatusChange struct {
+	ChangedAt time.Time       `json:"changed_at"`
+	Status    string          `json:"status"`
+	Reason    string          `json:"reason,omitempty"`
+	Response  json.RawMessage `json:"accrual_response,omitempty"`
+	Accrual   Amount          `json:"accrual"`
+}
+
+type OrderDetails struct {
+	*Order
+	History []*OrderStatusChange `json:"history"`
 }
 
 type OrderStorage interface {
 	AddOrder(ctx context.Context, order *OrderDTO) (*Order, error)
 	GetOrder(ctx context.Context, order *OrderDTO) (*Order, error)
-	GetOrdersForAccrual(ctx context.Context) ([]*Order, error)
-	UpdateOrder(ctx context.Context, order *Order) error
-	AddWithdrawn(ctx context.Context, userID string, orderNumber string, sum float64) error
+	AddWithdrawn(ctx context.Context, userID string, orderNumber string, sum Amount) error
+}
+
+type OrderHistoryStorage interface {
+	GetOrderHistory(ctx context.Context, orderID string) ([]*OrderStatusChange, error)
 }
 
 var ErrOrderWasRegisteredEarlier = errors.New("the order was registered earlier")
+var ErrOrderStatusChanged = errors.New("the order status was changed concurrently")
+var ErrOrderNumberIsIncorrect = errors.New("the order number is incorrect")
+var ErrOrderNotFound = errors.New("the order is not found")
 
 func (o *OrderDTO) AddOrder(ctx context.Context, db OrderStorage) (*Order, error) {
 	or, err := db.AddOrder(ctx, o)
@@ -55,6 +78,10 @@ func (o *OrderDTO) GetOrder(ctx context.Context, db OrderStorage) (*Order, error
 func (o *OrderDTO) NumberIsCorrect() bool {
 	var t = [...]int{0, 2, 4, 6, 8, 1, 3, 5, 7, 9}
 
+	if o.Number == "" {
+		return false
+	}
+
 	odd := len(o.Number) & 1
 	var sum int
 	for i, c := range o.Number {
@@ -71,17 +98,18 @@ func (o *OrderDTO) NumberIsCorrect() bool {
 	return sum%10 == 0
 }
 
-func (o *Order) Update(ctx context.Context, db OrderStorage) error {
-	if err := db.UpdateOrder(ctx, o); err != nil {
-		return fmt.Errorf("update order was failed err: %w", err)
+// Details returns the order with its status history from the oldest transition.
+func (o *Order) Details(ctx context.Context, db OrderHistoryStorage) (*OrderDetails, error) {
+	h, err := db.GetOrderHistory(ctx, o.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get order history was failed err: %w", err)
 	}
-	return nil
+	if h == nil {
+		h = []*OrderStatusChange{}
+	}
+	return &OrderDetails{Order: o, History: h}, nil
 }
 
-func GetOrdersForAccrual(ctx context.Context, db OrderStorage) ([]*Order, error) {
-	ors, err := db.GetOrdersForAccrual(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("get orders for accrual was failed err: %w", err)
-	}
-	return ors, nil
+func (o *Order) StatusIsFinal() bool {
+	return o.Status == OrderStatusInvalid || o.Status == OrderStatusProcessed
 }
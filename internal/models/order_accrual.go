@@ -1,7 +1,76 @@
 package models
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const AccrualStatusRegistered = "REGISTERED"
+
+var ErrAccrualOrderMismatch = errors.New("the accrual is for another order")
+var ErrAccrualStatusUnknown = errors.New("unknown accrual status")
+var ErrAccrualNegative = errors.New("negative accrual")
+
 type OrderAccrual struct {
-	OrderNumber string  `json:"order"`
-	Status      string  `json:"status"`
-	Accrual     float64 `json:"accrual"`
+	OrderNumber string          `json:"order"`
+	Status      string          `json:"status"`
+	Raw         json.RawMessage `json:"-"`
+	Accrual     Amount          `json:"accrual"`
+}
+
+// UnmarshalJSON rounds the accrual to hundredths, the accrual system may calculate it more precisely
+// and the order shouldn't be rejected for that.
+func (oa *OrderAccrual) UnmarshalJSON(b []byte) error {
+	type orderAccrual OrderAccrual
+	v := struct {
+		*orderAccrual
+		Accrual json.RawMessage `json:"accrual"`
+	}{orderAccrual: (*orderAccrual)(oa)}
+
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	if len(v.Accrual) == 0 || string(v.Accrual) == "null" {
+		return nil
+	}
+
+	a, err := RoundAmount(string(v.Accrual))
+	if err != nil {
+		return err
+	}
+
+	oa.Accrual = a
+	return nil
+}
+
+// OrderStatus returns the order status for the accrual status, the order registered by the accrual
+// system is processing already.
+func (oa *OrderAccrual) OrderStatus() string {
+	if oa.Status == AccrualStatusRegistered {
+		return OrderStatusProcessing
+	}
+	return oa.Status
+}
+
+// Validate checks the accrual system response for the order number. The accrual is only
+// kept for the processed order, the other statuses don't have it.
+func (oa *OrderAccrual) Validate(number string) error {
+	if oa.OrderNumber != number {
+		return fmt.Errorf("%w: %q instead of %q", ErrAccrualOrderMismatch, oa.OrderNumber, number)
+	}
+
+	switch oa.Status {
+	case AccrualStatusRegistered, OrderStatusProcessing, OrderStatusInvalid:
+		oa.Accrual = 0
+	case OrderStatusProcessed:
+		if oa.Accrual < 0 {
+			return fmt.Errorf("%w: %s", ErrAccrualNegative, oa.Accrual)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrAccrualStatusUnknown, oa.Status)
+	}
+
+	return nil
 }
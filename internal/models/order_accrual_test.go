@@ -0,0 +1,62 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderAccrual_Validate(t *testing.T) {
+	tests := []struct {
+		wantErr    error
+		oa         OrderAccrual
+		name       string
+		wantStatus string
+		want       Amount
+	}{
+		{
+			name:       "processed",
+			oa:         OrderAccrual{OrderNumber: "49927398716", Status: OrderStatusProcessed, Accrual: 500},
+			wantStatus: OrderStatusProcessed,
+			want:       500,
+		},
+		{
+			name:       "registered is processing",
+			oa:         OrderAccrual{OrderNumber: "49927398716", Status: AccrualStatusRegistered},
+			wantStatus: OrderStatusProcessing,
+		},
+		{
+			name:       "accrual of an unprocessed order is dropped",
+			oa:         OrderAccrual{OrderNumber: "49927398716", Status: OrderStatusInvalid, Accrual: 500},
+			wantStatus: OrderStatusInvalid,
+		},
+		{
+			name:    "another order",
+			oa:      OrderAccrual{OrderNumber: "79927398713", Status: OrderStatusProcessed, Accrual: 500},
+			wantErr: ErrAccrualOrderMismatch,
+		},
+		{
+			name:    "negative accrual",
+			oa:      OrderAccrual{OrderNumber: "49927398716", Status: OrderStatusProcessed, Accrual: -1},
+			wantErr: ErrAccrualNegative,
+		},
+		{
+			name:    "unknown status",
+			oa:      OrderAccrual{OrderNumber: "49927398716", Status: "NEW"},
+			wantErr: ErrAccrualStatusUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.oa.Validate("49927398716")
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.wantStatus, tt.oa.OrderStatus())
+			require.Equal(t, tt.want, tt.oa.Accrual)
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	OrderBatchAccepted              = "accepted"
+	OrderBatchUploadedByUser        = "already_uploaded"
+	OrderBatchUploadedByAnotherUser = "owned_by_another_user"
+	OrderBatchInvalid               = "invalid"
+)
+
+type OrderBatchResult struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+}
+
+type OrderBatchStorage interface {
+	AddOrders(ctx context.Context, userID string, numbers []string) ([]*OrderBatchResult, error)
+}
+
+// AddOrderBatch adds the correct numbers at once and returns the result for every number in the given order.
+func AddOrderBatch(ctx context.Context,
+	db OrderBatchStorage,
+	userID string,
+	numbers []string) ([]*OrderBatchResult, error) {
+	rs := make([]*OrderBatchResult, len(numbers))
+
+	var correct []string
+	var positions []int
+	for i, n := range numbers {
+		o := OrderDTO{Number: n, UserID: userID}
+		if !o.NumberIsCorrect() {
+			rs[i] = &OrderBatchResult{Number: n, Result: OrderBatchInvalid}
+			continue
+		}
+		correct = append(correct, n)
+		positions = append(positions, i)
+	}
+
+	if len(correct) == 0 {
+		return rs, nil
+	}
+
+	added, err := db.AddOrders(ctx, userID, correct)
+	if err != nil {
+		return nil, fmt.Errorf("add order batch was failed err: %w", err)
+	}
+	if len(added) != len(correct) {
+		return nil, fmt.Errorf("add order batch was failed: %d results for %d numbers", len(added), len(correct))
+	}
+
+	for i, r := range added {
+		rs[positions[i]] = r
+	}
+
+	return rs, nil
+}
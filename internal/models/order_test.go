@@ -33,6 +33,11 @@ func TestOrderDTONumberIsCorrect(t *testing.T) {
 			number: "4026843483168683",
 			want:   true,
 		},
+		{
+			name:   "#6 empty",
+			number: "",
+			want:   false,
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
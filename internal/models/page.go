@@ -0,0 +1,176 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SortDesc = "desc"
+	SortAsc  = "asc"
+
+	DefaultPageLimit = 50
+	MaxPageLimit     = 1000
+)
+
+var ErrCursorIsInvalid = errors.New("cursor is invalid")
+var ErrCursorMismatch = errors.New("cursor was given for another sort or filter of the list")
+
+// ListFilter selects a page of the orders or the withdrawals. The lists are ordered by the time
+// and then by the id, so the cursor points to the same place even if the times are equal.
+// From is inclusive and To is exclusive, zero times are not applied.
+type ListFilter struct {
+	From     time.Time
+	To       time.Time
+	Cursor   *Cursor
+	Sort     string
+	Statuses []string
+	Limit    int
+}
+
+// Cursor is the position of the last item of the previous page. It keeps the sort and the filters
+// of the list, the position means nothing in the list sorted or filtered another way.
+type Cursor struct {
+	Time     time.Time `json:"t"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	ID       string    `json:"id"`
+	Sort     string    `json:"sort"`
+	Statuses []string  `json:"status,omitempty"`
+}
+
+type OrderPage struct {
+	Orders     []*Order `json:"orders"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+type WithdrawalPage struct {
+	Withdrawals []*UserWithdrawalsHistory `json:"withdrawals"`
+	NextCursor  string                    `json:"next_cursor,omitempty"`
+}
+
+type PageStorage interface {
+	GetUploadedOrdersPage(ctx context.Context, userID string, f *ListFilter) ([]*Order, error)
+	GetWithdrawalPage(ctx context.Context, userID string, f *ListFilter) ([]*UserWithdrawalsHistory, error)
+}
+
+// Encode returns the opaque cursor the client passes back to get the next page.
+func (c *Cursor) Encode() string {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrCursorIsInvalid
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" || c.Time.IsZero() {
+		return nil, ErrCursorIsInvalid
+	}
+
+	return &c, nil
+}
+
+// CheckCursor reports ErrCursorMismatch when the cursor was given for the list sorted or filtered another way.
+func (f *ListFilter) CheckCursor() error {
+	c := f.Cursor
+	if c == nil {
+		return nil
+	}
+
+	if c.Sort != f.Sort || !c.From.Equal(f.From) || !c.To.Equal(f.To) ||
+		strings.Join(statusSet(c.Statuses), ",") != strings.Join(statusSet(f.Statuses), ",") {
+		return ErrCursorMismatch
+	}
+	return nil
+}
+
+// cursorAt returns the cursor of the item with the sort and the filters of the list.
+func (f *ListFilter) cursorAt(t time.Time, id string) *Cursor {
+	return &Cursor{
+		Time:     t,
+		ID:       id,
+		Sort:     f.Sort,
+		From:     f.From,
+		To:       f.To,
+		Statuses: statusSet(f.Statuses),
+	}
+}
+
+// statusSet returns the sorted statuses without repeats, the order of the statuses doesn't change the list.
+func statusSet(ss []string) []string {
+	set := append([]string(nil), ss...)
+	sort.Strings(set)
+
+	n := 0
+	for i, s := range set {
+		if i == 0 || s != set[n-1] {
+			set[n] = s
+			n++
+		}
+	}
+	return set[:n]
+}
+
+// Desc reports whether the newest items go first, it is the default order of the lists.
+func (f *ListFilter) Desc() bool {
+	return f.Sort != SortAsc
+}
+
+// nextPage requests one item more than the limit to find out whether there is the next page.
+func (f *ListFilter) nextPage() *ListFilter {
+	n := *f
+	n.Limit++
+	return &n
+}
+
+func (u *User) GetUploadedOrdersPage(ctx context.Context, db PageStorage, f *ListFilter) (*OrderPage, error) {
+	ors, err := db.GetUploadedOrdersPage(ctx, u.ID, f.nextPage())
+	if err != nil {
+		return nil, fmt.Errorf("get uploaded orders page was failed err: %w", err)
+	}
+
+	p := &OrderPage{Orders: ors}
+	if len(ors) > f.Limit {
+		p.Orders = ors[:f.Limit]
+		last := p.Orders[len(p.Orders)-1]
+		p.NextCursor = f.cursorAt(last.UploadedAt, last.ID).Encode()
+	}
+	if p.Orders == nil {
+		p.Orders = []*Order{}
+	}
+
+	return p, nil
+}
+
+func (u *User) GetWithdrawalPage(ctx context.Context, db PageStorage, f *ListFilter) (*WithdrawalPage, error) {
+	ws, err := db.GetWithdrawalPage(ctx, u.ID, f.nextPage())
+	if err != nil {
+		return nil, fmt.Errorf("get withdrawal page was failed err: %w", err)
+	}
+
+	p := &WithdrawalPage{Withdrawals: ws}
+	if len(ws) > f.Limit {
+		p.Withdrawals = ws[:f.Limit]
+		last := p.Withdrawals[len(p.Withdrawals)-1]
+		p.NextCursor = f.cursorAt(last.ProcessedAt, strconv.FormatInt(last.Seq, 10)).Encode()
+	}
+	if p.Withdrawals == nil {
+		p.Withdrawals = []*UserWithdrawalsHistory{}
+	}
+
+	return p, nil
+}
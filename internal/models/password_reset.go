@@ -0,0 +1,80 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PasswordResetToken is a single-use token that allows to set a new password without the current one.
+// Only the hash of the token is stored.
+type PasswordResetToken struct {
+	ExpiresAt time.Time
+	Token     string
+	Hash      string
+	UserID    string
+}
+
+var ErrPasswordResetTokenIsInvalid = errors.New("password reset token is invalid")
+
+// PasswordStorage changes passwords, every change revokes all sessions of the user.
+type PasswordStorage interface {
+	ChangeUserPassword(ctx context.Context, userID string, hash string) (*User, error)
+	AddPasswordResetToken(ctx context.Context, t *PasswordResetToken) error
+	GetPasswordResetUser(ctx context.Context, tokenHash string) (*User, error)
+	ResetUserPassword(ctx context.Context, tokenHash string, hash string) (*User, error)
+}
+
+func NewPasswordResetToken(userID string, exp time.Duration) (*PasswordResetToken, error) {
+	t, err := newOpaqueToken()
+	if err != nil {
+		return nil, fmt.Errorf("generate password reset token err: %w", err)
+	}
+
+	return &PasswordResetToken{
+		Token:     t,
+		Hash:      HashPasswordResetToken(t),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(exp),
+	}, nil
+}
+
+func HashPasswordResetToken(token string) string {
+	return hashOpaqueToken(token)
+}
+
+// Add stores the token, the tokens issued to the user earlier can no longer be used.
+func (t *PasswordResetToken) Add(ctx context.Context, db PasswordStorage) error {
+	if err := db.AddPasswordResetToken(ctx, t); err != nil {
+		return fmt.Errorf("add password reset token was failed err: %w", err)
+	}
+	return nil
+}
+
+// User returns the owner of the token if the token is not used and not expired.
+func (t *PasswordResetToken) User(ctx context.Context, db PasswordStorage) (*User, error) {
+	u, err := db.GetPasswordResetUser(ctx, t.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("get password reset user was failed err: %w", err)
+	}
+	return u, nil
+}
+
+// Use sets the new password hash and marks the token as used.
+func (t *PasswordResetToken) Use(ctx context.Context, db PasswordStorage, hash string) (*User, error) {
+	u, err := db.ResetUserPassword(ctx, t.Hash, hash)
+	if err != nil {
+		return nil, fmt.Errorf("reset user password was failed err: %w", err)
+	}
+	return u, nil
+}
+
+func (u *User) ChangePassword(ctx context.Context, db PasswordStorage, hash string) error {
+	cu, err := db.ChangeUserPassword(ctx, u.ID, hash)
+	if err != nil {
+		return fmt.Errorf("change user password was failed err: %w", err)
+	}
+	*u = *cu
+	return nil
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RefreshToken is an opaque token that is exchanged for a new access token.
+// Only the hash of the token is stored, tokens issued one after another by rotation share the family.
+type RefreshToken struct {
+	ExpiresAt time.Time
+	Token     string
+	Hash      string
+	UserID    string
+	Family    string
+}
+
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+var ErrRefreshTokenIsInvalid = errors.New("refresh token is invalid")
+var ErrRefreshTokenReused = errors.New("refresh token was reused")
+
+type TokenStorage interface {
+	AddRefreshToken(ctx context.Context, t *RefreshToken) error
+	RotateRefreshToken(ctx context.Context, hash string, next *RefreshToken) (*User, error)
+	RevokeRefreshToken(ctx context.Context, userID string, hash string) error
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	AccessTokenIsRevoked(ctx context.Context, jti string, userID string, sessionGen int64) (bool, error)
+	AccessTokenIDIsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+const opaqueTokenLen = 32
+
+// newOpaqueToken returns a random token that is given to the client, only its hash is stored.
+func newOpaqueToken() (string, error) {
+	b := make([]byte, opaqueTokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashOpaqueToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func NewRefreshToken(userID string, exp time.Duration) (*RefreshToken, error) {
+	t, err := newOpaqueToken()
+	if err != nil {
+		return nil, fmt.Errorf("generate refresh token err: %w", err)
+	}
+
+	return &RefreshToken{
+		Token:     t,
+		Hash:      HashRefreshToken(t),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(exp),
+	}, nil
+}
+
+func HashRefreshToken(token string) string {
+	return hashOpaqueToken(token)
+}
+
+func (t *RefreshToken) Add(ctx context.Context, db TokenStorage) error {
+	if err := db.AddRefreshToken(ctx, t); err != nil {
+		return fmt.Errorf("add refresh token was failed err: %w", err)
+	}
+	return nil
+}
+
+// Rotate exchanges the refresh token for the next one, the whole family is revoked if the token was used before.
+func (t *RefreshToken) Rotate(ctx context.Context, db TokenStorage, next *RefreshToken) (*User, error) {
+	u, err := db.RotateRefreshToken(ctx, t.Hash, next)
+	if err != nil {
+		return nil, fmt.Errorf("rotate refresh token was failed err: %w", err)
+	}
+	return u, nil
+}
+
+func (t *RefreshToken) Revoke(ctx context.Context, db TokenStorage) error {
+	if err := db.RevokeRefreshToken(ctx, t.UserID, t.Hash); err != nil {
+		return fmt.Errorf("revoke refresh token was failed err: %w", err)
+	}
+	return nil
+}
+
+func RevokeAccessToken(ctx context.Context, db TokenStorage, jti string, expiresAt time.Time) error {
+	if err := db.RevokeAccessToken(ctx, jti, expiresAt); err != nil {
+		return fmt.Errorf("revoke access token was failed err: %w", err)
+	}
+	return nil
+}
+
+// AccessTokenIsRevoked reports whether the token was revoked by itself
+// or was issued for a session generation older than the one of the user.
+func AccessTokenIsRevoked(ctx context.Context,
+	db TokenStorage,
+	jti string,
+	userID string,
+	sessionGen int64) (bool, error) {
+	revoked, err := db.AccessTokenIsRevoked(ctx, jti, userID, sessionGen)
+	if err != nil {
+		return false, fmt.Errorf("check access token revocation was failed err: %w", err)
+	}
+	return revoked, nil
+}
+
+// AccessTokenIDIsRevoked reports whether the token was revoked by itself,
+// the session generation is checked by the caller against the cached user.
+func AccessTokenIDIsRevoked(ctx context.Context, db TokenStorage, jti string) (bool, error) {
+	revoked, err := db.AccessTokenIDIsRevoked(ctx, jti)
+	if err != nil {
+		return false, fmt.Errorf("check access token id revocation was failed err: %w", err)
+	}
+	return revoked, nil
+}
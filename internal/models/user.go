@@ -16,26 +16,33 @@ type User struct {
 	ID           string `json:"uuid"`
 	Login        string `json:"login"`
 	PasswordHash string `json:"password"`
+	// SessionGen is increased when the password is changed, access tokens of the previous generations are revoked.
+	SessionGen int64 `json:"-"`
 }
 
 type UserWithdrawalsHistory struct {
 	ProcessedAt time.Time `json:"processed_at"`
 	OrderNumber string    `json:"order"`
-	Sum         float64   `json:"sum"`
+	Sum         Amount    `json:"sum"`
+	Seq         int64     `json:"-"`
 }
 
 type UserBalance struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current   Amount `json:"current"`
+	Withdrawn Amount `json:"withdrawn"`
 }
 
 var ErrLoginIsBusy = errors.New("login is busy")
 var ErrUnknowUser = errors.New("unknow user")
 var ErrNotEnoughAccruals = errors.New("not enough accruals")
+var ErrWithdrawalSumIsNotPositive = errors.New("withdrawal sum must be positive")
+var ErrWithdrawalWasRegisteredEarlier = errors.New("the withdrawal for the order was registered earlier")
 
 type UserStorage interface {
 	AddUser(ctx context.Context, us *UserDTO) (*User, error)
 	GetUser(ctx context.Context, us *UserDTO) (*User, error)
+	GetUserByID(ctx context.Context, userID string) (*User, error)
+	UpdateUserPassword(ctx context.Context, userID string, hash string) error
 	GetUploadedOrders(ctx context.Context, us *User) ([]*Order, error)
 	GetBalance(ctx context.Context, userID string) (*UserBalance, error)
 	GetWithdrawalList(ctx context.Context, userID string) ([]*UserWithdrawalsHistory, error)
@@ -65,6 +72,25 @@ func (u *UserDTO) GetUser(ctx context.Context, db UserStorage) (*User, error) {
 	return us, nil
 }
 
+func GetUserByID(ctx context.Context, db UserStorage, userID string) (*User, error) {
+	if userID == "" {
+		return nil, ErrUnknowUser
+	}
+
+	us, err := db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get user by id was failed err: %w", err)
+	}
+	return us, nil
+}
+
+func (u *User) UpdatePassword(ctx context.Context, db UserStorage, hash string) error {
+	if err := db.UpdateUserPassword(ctx, u.ID, hash); err != nil {
+		return fmt.Errorf("update user password was failed err: %w", err)
+	}
+	return nil
+}
+
 func (u *User) GetUploadedOrders(ctx context.Context, db UserStorage) ([]*Order, error) {
 	ors, err := db.GetUploadedOrders(ctx, u)
 	if err != nil {
@@ -89,7 +115,16 @@ func (u *User) GetWithdrawalList(ctx context.Context, db UserStorage) ([]*UserWi
 	return lws, nil
 }
 
-func (u *User) AddWithdrawn(ctx context.Context, db OrderStorage, orderNumber string, sum float64) error {
+func (u *User) AddWithdrawn(ctx context.Context, db OrderStorage, orderNumber string, sum Amount) error {
+	o := OrderDTO{Number: orderNumber}
+	if !o.NumberIsCorrect() {
+		return ErrOrderNumberIsIncorrect
+	}
+
+	if sum <= 0 {
+		return ErrWithdrawalSumIsNotPositive
+	}
+
 	if err := db.AddWithdrawn(ctx, u.ID, orderNumber, sum); err != nil {
 		return fmt.Errorf("add withdrawn was failed err: %w", err)
 	}
@@ -0,0 +1,56 @@
+// Package notifier delivers messages to users. There is no mail delivery yet,
+// the messages are written to a local file that a delivery agent picks up.
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// PasswordReset is the message with the password reset token for the user.
+type PasswordReset struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Login     string    `json:"login"`
+	Token     string    `json:"token"`
+}
+
+// File appends the messages to the file as JSON lines.
+type File struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (n *File) NotifyPasswordReset(ctx context.Context, m *PasswordReset) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("password reset marshal to json err: %w", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	const perm = 0o600
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, perm)
+	if err != nil {
+		return fmt.Errorf("failed to open notify file err: %w", err)
+	}
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write notify file err: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close notify file err: %w", err)
+	}
+
+	return nil
+}
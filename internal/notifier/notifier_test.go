@@ -0,0 +1,53 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile_NotifyPasswordReset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.jsonl")
+	n := NewFile(path)
+
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	want := []*PasswordReset{
+		{Login: "first", Token: "token1", ExpiresAt: exp},
+		{Login: "second", Token: "token2", ExpiresAt: exp},
+	}
+
+	for _, m := range want {
+		require.NoError(t, n.NotifyPasswordReset(context.Background(), m))
+	}
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var got []*PasswordReset
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var m PasswordReset
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &m))
+		got = append(got, &m)
+	}
+	require.NoError(t, sc.Err())
+
+	require.Len(t, got, len(want))
+	for i := range want {
+		require.Equal(t, want[i].Login, got[i].Login)
+		require.Equal(t, want[i].Token, got[i].Token)
+		require.True(t, want[i].ExpiresAt.Equal(got[i].ExpiresAt))
+	}
+}
+
+func TestFile_NotifyPasswordResetBadPath(t *testing.T) {
+	n := NewFile(filepath.Join(t.TempDir(), "missing", "notify.jsonl"))
+	require.Error(t, n.NotifyPasswordReset(context.Background(), &PasswordReset{Login: "test"}))
+}
@@ -0,0 +1,234 @@
+package security
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// minRSAKeyBits is the smallest RSA key NIST SP 800-131A allows for signatures.
+const minRSAKeyBits = 2048
+
+// Key is a JWT signing or verification key. Keys without a private part only verify tokens
+// signed before the key was retired.
+type Key struct {
+	method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+	ID      string
+}
+
+// KeySet signs tokens with the active key and verifies them with any key of the set by the kid header.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewHMACKeySet returns the keyset with a single shared secret, it is used when no key files are configured.
+func NewHMACKeySet(secret []byte) *KeySet {
+	k := &Key{
+		method:  jwt.SigningMethodHS256,
+		private: secret,
+		public:  secret,
+	}
+
+	return &KeySet{
+		signing: k,
+		keys:    map[string]*Key{k.ID: k},
+	}
+}
+
+// LoadKeySet loads every *.pem file of the directory, the file name without the extension is the kid.
+// A file may contain a PKCS#8 or PKCS#1 private key or a PKIX public key of RSA or Ed25519 type.
+// The signing key must have the private part, it may be omitted if the directory has only one private key.
+func LoadKeySet(dir string, signingKeyID string) (*KeySet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list key files err: %w", err)
+	}
+
+	ks := &KeySet{
+		keys: make(map[string]*Key, len(files)),
+	}
+
+	var private []*Key
+	for _, f := range files {
+		k, err := loadKey(f)
+		if err != nil {
+			return nil, err
+		}
+
+		ks.keys[k.ID] = k
+		if k.private != nil {
+			private = append(private, k)
+		}
+	}
+
+	if len(ks.keys) == 0 {
+		return nil, fmt.Errorf("no keys were found in %s", dir)
+	}
+
+	if signingKeyID == "" {
+		if len(private) != 1 {
+			return nil, fmt.Errorf("signing key id must be set, %d private keys were found in %s", len(private), dir)
+		}
+		signingKeyID = private[0].ID
+	}
+
+	k, ok := ks.keys[signingKeyID]
+	if !ok || k.private == nil {
+		return nil, fmt.Errorf("%w: private key %q was not found in %s", ErrUnknownKey, signingKeyID, dir)
+	}
+	ks.signing = k
+
+	return ks, nil
+}
+
+func loadKey(path string) (*Key, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file err: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+
+	k := &Key{
+		ID: strings.TrimSuffix(filepath.Base(path), ".pem"),
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		k.private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		k.private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		k.public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s has unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key %s err: %w", path, err)
+	}
+
+	switch key := k.private.(type) {
+	case nil:
+	case *rsa.PrivateKey:
+		k.public = &key.PublicKey
+	case ed25519.PrivateKey:
+		k.public = key.Public()
+	default:
+		return nil, fmt.Errorf("%s has unsupported private key type %T", path, key)
+	}
+
+	switch key := k.public.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("%s has %d-bit RSA key, at least %d bits are required",
+				path, key.N.BitLen(), minRSAKeyBits)
+		}
+		k.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		k.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("%s has unsupported public key type %T", path, k.public)
+	}
+
+	return k, nil
+}
+
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	if ks.signing.ID != "" {
+		token.Header["kid"] = ks.signing.ID
+	}
+
+	s, err := token.SignedString(ks.signing.private)
+	if err != nil {
+		return "", fmt.Errorf("get token signed string err: %w", err)
+	}
+	return s, nil
+}
+
+// Keyfunc returns the verification key by the kid header for jwt.Parse.
+// The algorithm of the token must be the algorithm of the key.
+func (ks *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	k, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+
+	if t.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+
+	return k.public, nil
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, shared secrets are never published.
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+
+	for _, k := range ks.keys {
+		jwk := JWK{
+			Kid: k.ID,
+			Use: "sig",
+			Alg: k.method.Alg(),
+		}
+
+		switch key := k.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(key)
+		default:
+			continue
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].Kid < jwks.Keys[j].Kid
+	})
+
+	return jwks
+}
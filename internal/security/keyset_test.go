@@ -0,0 +1,248 @@
+package security
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadKeySet(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, edKey2, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		files        func(dir string)
+		name         string
+		signingKeyID string
+		wantKeyID    string
+		wantErr      bool
+	}{
+		{
+			name:    "empty directory",
+			files:   func(dir string) {},
+			wantErr: true,
+		},
+		{
+			name: "single private key",
+			files: func(dir string) {
+				writeTestKey(t, dir, "k1", edKey, false)
+			},
+			wantKeyID: "k1",
+		},
+		{
+			name: "two private keys without signing key id",
+			files: func(dir string) {
+				writeTestKey(t, dir, "k1", edKey, false)
+				writeTestKey(t, dir, "k2", edKey2, false)
+			},
+			wantErr: true,
+		},
+		{
+			name: "two private keys",
+			files: func(dir string) {
+				writeTestKey(t, dir, "k1", edKey, false)
+				writeTestKey(t, dir, "k2", edKey2, false)
+			},
+			signingKeyID: "k2",
+			wantKeyID:    "k2",
+		},
+		{
+			name: "unknown signing key id",
+			files: func(dir string) {
+				writeTestKey(t, dir, "k1", edKey, false)
+			},
+			signingKeyID: "k2",
+			wantErr:      true,
+		},
+		{
+			name: "signing key without private part",
+			files: func(dir string) {
+				writeTestKey(t, dir, "k1", edKey, true)
+			},
+			signingKeyID: "k1",
+			wantErr:      true,
+		},
+		{
+			name: "not a PEM file",
+			files: func(dir string) {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "k1.pem"), []byte("key"), 0o600))
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.files(dir)
+
+			ks, err := LoadKeySet(dir, tt.signingKeyID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadKeySet() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				require.Equal(t, tt.wantKeyID, ks.signing.ID)
+			}
+		})
+	}
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, newKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	claims := jwt.RegisteredClaims{
+		Subject:   "1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+
+	before := t.TempDir()
+	writeTestKey(t, before, "old", oldKey, false)
+	ks1, err := LoadKeySet(before, "")
+	require.NoError(t, err)
+
+	t1, err := ks1.Sign(claims)
+	require.NoError(t, err)
+
+	// The old key is retired, only its public part is kept to verify tokens signed before the rotation.
+	during := t.TempDir()
+	writeTestKey(t, during, "old", oldKey, true)
+	writeTestKey(t, during, "new", newKey, false)
+	ks2, err := LoadKeySet(during, "new")
+	require.NoError(t, err)
+
+	token, err := jwt.ParseWithClaims(t1, &jwt.RegisteredClaims{}, ks2.Keyfunc)
+	require.NoError(t, err, "token signed by the retired key")
+	require.Equal(t, "old", token.Header["kid"])
+	require.Equal(t, jwt.SigningMethodRS256.Alg(), token.Method.Alg())
+
+	t2, err := ks2.Sign(claims)
+	require.NoError(t, err)
+
+	token, err = jwt.ParseWithClaims(t2, &jwt.RegisteredClaims{}, ks2.Keyfunc)
+	require.NoError(t, err, "token signed by the new key")
+	require.Equal(t, "new", token.Header["kid"])
+	require.Equal(t, jwt.SigningMethodEdDSA.Alg(), token.Method.Alg())
+
+	_, err = jwt.ParseWithClaims(t2, &jwt.RegisteredClaims{}, ks1.Keyfunc)
+	require.ErrorIs(t, err, ErrUnknownKey, "the new key is unknown before the rotation")
+
+	after := t.TempDir()
+	writeTestKey(t, after, "new", newKey, false)
+	ks3, err := LoadKeySet(after, "")
+	require.NoError(t, err)
+
+	_, err = jwt.ParseWithClaims(t1, &jwt.RegisteredClaims{}, ks3.Keyfunc)
+	require.ErrorIs(t, err, ErrUnknownKey, "the old key is not trusted anymore")
+
+	jwks := ks2.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	require.Equal(t, "new", jwks.Keys[0].Kid)
+	require.Equal(t, "OKP", jwks.Keys[0].Kty)
+	require.Equal(t, "EdDSA", jwks.Keys[0].Alg)
+	require.NotEmpty(t, jwks.Keys[0].X)
+	require.Equal(t, "old", jwks.Keys[1].Kid)
+	require.Equal(t, "RSA", jwks.Keys[1].Kty)
+	require.Equal(t, "RS256", jwks.Keys[1].Alg)
+	require.Equal(t, "AQAB", jwks.Keys[1].E)
+	require.NotEmpty(t, jwks.Keys[1].N)
+}
+
+func TestKeySet_AlgorithmConfusion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	writeTestKey(t, dir, "k1", key, false)
+	ks, err := LoadKeySet(dir, "")
+	require.NoError(t, err)
+
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	// The public key is known to everybody, so it must not be accepted as an HMAC secret.
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "1"})
+	token.Header["kid"] = "k1"
+	s, err := token.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}))
+	require.NoError(t, err)
+
+	_, err = jwt.ParseWithClaims(s, &jwt.RegisteredClaims{}, ks.Keyfunc)
+	require.Error(t, err)
+}
+
+func TestKeySet_HMAC(t *testing.T) {
+	ks := NewHMACKeySet([]byte("secret"))
+
+	s, err := ks.Sign(jwt.RegisteredClaims{Subject: "1"})
+	require.NoError(t, err)
+
+	_, err = jwt.ParseWithClaims(s, &jwt.RegisteredClaims{}, ks.Keyfunc)
+	require.NoError(t, err)
+
+	_, err = jwt.ParseWithClaims(s, &jwt.RegisteredClaims{}, NewHMACKeySet([]byte("another")).Keyfunc)
+	require.Error(t, err)
+
+	require.Empty(t, ks.JWKS().Keys, "the secret must not be published")
+}
+
+func writeTestKey(t *testing.T, dir string, kid string, key any, public bool) {
+	t.Helper()
+
+	var block *pem.Block
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if public {
+			b, err := x509.MarshalPKIXPublicKey(&k.PublicKey)
+			require.NoError(t, err)
+			block = &pem.Block{Type: "PUBLIC KEY", Bytes: b}
+		} else {
+			block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+		}
+	case ed25519.PrivateKey:
+		if public {
+			b, err := x509.MarshalPKIXPublicKey(k.Public())
+			require.NoError(t, err)
+			block = &pem.Block{Type: "PUBLIC KEY", Bytes: b}
+		} else {
+			b, err := x509.MarshalPKCS8PrivateKey(k)
+			require.NoError(t, err)
+			block = &pem.Block{Type: "PRIVATE KEY", Bytes: b}
+		}
+	default:
+		t.Fatalf("unsupported key type %T", key)
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(block), 0o600))
+}
+
+func TestLoadKeySet_WeakRSAKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	// A retired key that is too short is rejected as well as the signing one.
+	for _, public := range []bool{false, true} {
+		dir := t.TempDir()
+		writeTestKey(t, dir, "active", edKey, false)
+		writeTestKey(t, dir, "weak", key, public)
+
+		_, err = LoadKeySet(dir, "active")
+		require.ErrorContains(t, err, "1024-bit RSA key", "public: %v", public)
+	}
+}
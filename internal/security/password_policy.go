@@ -0,0 +1,115 @@
+package security
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	PasswordIsEmpty  = "password_empty"
+	PasswordTooShort = "password_too_short"
+	PasswordTooLong  = "password_too_long"
+	PasswordIsCommon = "password_common"
+	PasswordHasLogin = "password_contains_login"
+)
+
+// minLoginInPassword is the length of the login in characters from which the password must not contain it,
+// a shorter login is a part of too many good passwords.
+const minLoginInPassword = 4
+
+// commonPasswords are denied even without the deny-list file.
+var commonPasswords = []string{
+	"password", "password1", "12345678", "123456789", "1234567890", "qwerty123", "qwertyuiop",
+	"11111111", "iloveyou", "admin123", "letmein1", "gophermart",
+}
+
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicy checks new passwords, the length is counted in characters.
+type PasswordPolicy struct {
+	denied    map[string]struct{}
+	minLength int
+	maxLength int
+}
+
+// NewPasswordPolicy reads the deny-list file with one password per line, the file is optional.
+func NewPasswordPolicy(minLength int, maxLength int, denyListFile string) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{
+		minLength: minLength,
+		maxLength: maxLength,
+		denied:    make(map[string]struct{}, len(commonPasswords)),
+	}
+
+	for _, s := range commonPasswords {
+		p.denied[s] = struct{}{}
+	}
+
+	if denyListFile == "" {
+		return p, nil
+	}
+
+	b, err := os.ReadFile(denyListFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password deny-list err: %w", err)
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		if s := strings.TrimSpace(sc.Text()); s != "" {
+			p.denied[strings.ToLower(s)] = struct{}{}
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read password deny-list err: %w", err)
+	}
+
+	return p, nil
+}
+
+func (p *PasswordPolicy) Validate(login string, password string) []PasswordViolation {
+	if password == "" {
+		return []PasswordViolation{{Code: PasswordIsEmpty, Message: "password is required"}}
+	}
+
+	var vs []PasswordViolation
+
+	n := utf8.RuneCountInString(password)
+	if n < p.minLength {
+		vs = append(vs, PasswordViolation{
+			Code:    PasswordTooShort,
+			Message: fmt.Sprintf("password must be at least %d characters long", p.minLength),
+		})
+	}
+
+	if p.maxLength > 0 && n > p.maxLength {
+		vs = append(vs, PasswordViolation{
+			Code:    PasswordTooLong,
+			Message: fmt.Sprintf("password must be at most %d characters long", p.maxLength),
+		})
+	}
+
+	if _, ok := p.denied[strings.ToLower(password)]; ok {
+		vs = append(vs, PasswordViolation{
+			Code:    PasswordIsCommon,
+			Message: "password is too common",
+		})
+	}
+
+	if utf8.RuneCountInString(login) >= minLoginInPassword &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(login)) {
+		vs = append(vs, PasswordViolation{
+			Code:    PasswordHasLogin,
+			Message: "password must not contain the login",
+		})
+	}
+
+	return vs
+}
@@ -0,0 +1,49 @@
+package security
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "deny.txt")
+	require.NoError(t, os.WriteFile(f, []byte("Summer2023!\n\n  winter2023!  \n"), 0o600))
+
+	p, err := NewPasswordPolicy(8, 16, f)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		want     []string
+	}{
+		{name: "Valid password", login: "gopher", password: "correct horse", want: nil},
+		{name: "Empty password", login: "gopher", password: "", want: []string{PasswordIsEmpty}},
+		{name: "Short password", login: "gopher", password: "Zx9!", want: []string{PasswordTooShort}},
+		{name: "Length in characters", login: "gopher", password: "пароль12", want: nil},
+		{name: "Long password", login: "gopher", password: "correct horse battery", want: []string{PasswordTooLong}},
+		{name: "Built-in common password", login: "gopher", password: "QWERTY123", want: []string{PasswordIsCommon}},
+		{name: "Deny-list password", login: "gopher", password: "WINTER2023!", want: []string{PasswordIsCommon}},
+		{name: "Password with login", login: "Gopher", password: "my gopher 1", want: []string{PasswordHasLogin}},
+		{name: "Password with upper case login", login: "gopher", password: "MY GOPHER 1", want: []string{PasswordHasLogin}},
+		{name: "Password with short login", login: "Ann", password: "planning 1", want: nil},
+		{name: "Password with four letter login", login: "Anna", password: "hosanna 12", want: []string{PasswordHasLogin}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var codes []string
+			for _, v := range p.Validate(tt.login, tt.password) {
+				codes = append(codes, v.Code)
+			}
+			require.Equal(t, tt.want, codes)
+		})
+	}
+
+	_, err = NewPasswordPolicy(8, 16, filepath.Join(t.TempDir(), "missing.txt"))
+	require.Error(t, err)
+}
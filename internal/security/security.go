@@ -1,26 +1,168 @@
 package security
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-type hash struct{}
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// Argon2id parameters are the second recommended option of RFC 9106.
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+var ErrUnknownAlgorithm = errors.New("unknown password hashing algorithm")
+var ErrPasswordTooLong = bcrypt.ErrPasswordTooLong
 
-func NewHashController() (*hash, error) {
-	return &hash{}, nil
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	keyLen  uint32
 }
 
-func (h *hash) HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// hash hashes new passwords with the configured algorithm and verifies hashes of any supported algorithm.
+type hash struct {
+	algorithm  string
+	argon2     argon2Params
+	bcryptCost int
+	// dummyHash is the hash of a random password made with the configured algorithm.
+	dummyHash string
+}
+
+func NewHashController(algorithm string) (*hash, error) {
+	switch algorithm {
+	case AlgorithmArgon2id, AlgorithmBcrypt:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, algorithm)
+	}
+
+	h := &hash{
+		algorithm: algorithm,
+		argon2: argon2Params{
+			time:    argon2Time,
+			memory:  argon2Memory,
+			threads: argon2Threads,
+			keyLen:  argon2KeyLen,
+		},
+		bcryptCost: bcrypt.DefaultCost,
+	}
+
+	dummy := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(dummy); err != nil {
+		return nil, fmt.Errorf("generate dummy password err: %w", err)
+	}
+
+	var err error
+	h.dummyHash, err = h.HashPassword(base64.RawStdEncoding.EncodeToString(dummy))
 	if err != nil {
-		return "", fmt.Errorf("get password hash err: %w", err)
+		return nil, fmt.Errorf("get dummy password hash err: %w", err)
 	}
-	return string(bytes), nil
+
+	return h, nil
+}
+
+func (h *hash) HashPassword(password string) (string, error) {
+	if h.algorithm == AlgorithmBcrypt {
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		if err != nil {
+			return "", fmt.Errorf("get password hash err: %w", err)
+		}
+		return string(bytes), nil
+	}
+
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate password salt err: %w", err)
+	}
+
+	p := h.argon2
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, p.keyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
 }
 
 func (h *hash) CheckPasswordHash(hash string, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		return err == nil
+	}
+
+	p, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return false
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, p.keyLen)
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+// CheckDummyHash checks the password against the hash of a random password. It takes as long as the check
+// of a wrong password, so the response to a login that doesn't exist doesn't tell it apart.
+func (h *hash) CheckDummyHash(password string) {
+	h.CheckPasswordHash(h.dummyHash, password)
+}
+
+// NeedsRehash reports whether the hash was made by another algorithm or with outdated parameters.
+func (h *hash) NeedsRehash(hash string) bool {
+	if h.algorithm == AlgorithmBcrypt {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != h.bcryptCost
+	}
+
+	p, _, _, err := decodeArgon2Hash(hash)
+	return err != nil || p != h.argon2
+}
+
+// decodeArgon2Hash parses the PHC string $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>.
+func decodeArgon2Hash(hash string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return p, nil, nil, errors.New("hash is not an argon2id PHC string")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, fmt.Errorf("failed to parse argon2id version err: %w", err)
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, fmt.Errorf("failed to parse argon2id parameters err: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("failed to decode argon2id salt err: %w", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("failed to decode argon2id key err: %w", err)
+	}
+	p.keyLen = uint32(len(key))
+
+	return p, salt, key, nil
 }
@@ -1,9 +1,16 @@
 package security
 
-import "testing"
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
 
 func Test_hash_CheckPasswordHash(t *testing.T) {
-	hashc, err := NewHashController()
+	hashc, err := NewHashController(AlgorithmArgon2id)
 	if err != nil {
 		t.Error(err)
 	}
@@ -13,6 +20,11 @@ func Test_hash_CheckPasswordHash(t *testing.T) {
 		t.Error(err)
 	}
 
+	bh, err := bcrypt.GenerateFromPassword([]byte("test"), bcrypt.MinCost)
+	if err != nil {
+		t.Error(err)
+	}
+
 	type args struct {
 		hash     string
 		password string
@@ -41,6 +53,24 @@ func Test_hash_CheckPasswordHash(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "Positive bcrypt case",
+			h:    hashc,
+			args: args{
+				hash:     string(bh),
+				password: "test",
+			},
+			want: true,
+		},
+		{
+			name: "Broken argon2id hash",
+			h:    hashc,
+			args: args{
+				hash:     "$argon2id$v=19$m=65536,t=3,p=4$broken",
+				password: "test",
+			},
+			want: false,
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -52,3 +82,47 @@ func Test_hash_CheckPasswordHash(t *testing.T) {
 		})
 	}
 }
+
+func Test_hash_NeedsRehash(t *testing.T) {
+	argon, err := NewHashController(AlgorithmArgon2id)
+	require.NoError(t, err)
+
+	bc, err := NewHashController(AlgorithmBcrypt)
+	require.NoError(t, err)
+
+	ah, err := argon.HashPassword("test")
+	require.NoError(t, err)
+
+	bh, err := bc.HashPassword("test")
+	require.NoError(t, err)
+
+	weak := strings.Replace(ah, "t=3", "t=1", 1)
+
+	require.False(t, argon.NeedsRehash(ah))
+	require.True(t, argon.NeedsRehash(bh))
+	require.True(t, argon.NeedsRehash(weak))
+	require.False(t, bc.NeedsRehash(bh))
+	require.True(t, bc.NeedsRehash(ah))
+}
+
+func TestNewHashController(t *testing.T) {
+	_, err := NewHashController("md5")
+	require.True(t, errors.Is(err, ErrUnknownAlgorithm))
+
+	bc, err := NewHashController(AlgorithmBcrypt)
+	require.NoError(t, err)
+
+	_, err = bc.HashPassword(strings.Repeat("a", 73))
+	require.True(t, errors.Is(err, ErrPasswordTooLong))
+}
+
+func Test_hash_DummyHash(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmArgon2id, AlgorithmBcrypt} {
+		h, err := NewHashController(algorithm)
+		require.NoError(t, err)
+
+		// The dummy check costs the same as the check of a user's password.
+		require.False(t, h.NeedsRehash(h.dummyHash), algorithm)
+		require.False(t, h.CheckPasswordHash(h.dummyHash, ""), algorithm)
+	}
+}
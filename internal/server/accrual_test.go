@@ -0,0 +1,428 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/gophermart/internal/accrualfake"
+	"github.com/ArtemShalinFe/gophermart/internal/adapters"
+	"github.com/ArtemShalinFe/gophermart/internal/config"
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+// testAccrualStore keeps orders and accrual jobs the same way the database keeps them.
+type testAccrualStore struct {
+	mx      *sync.Mutex
+	orders  map[string]*models.Order
+	jobs    map[string]*testAccrualJob
+	history map[string][]string
+}
+
+type testAccrualJob struct {
+	enqueued    time.Time
+	nextAttempt time.Time
+	lockedUntil time.Time
+	lockedBy    string
+	attempts    int
+	failures    int
+}
+
+// leased returns the job while it is leased by the lease.
+func (s *testAccrualStore) leased(orderID string, lease models.AccrualLease) (*testAccrualJob, error) {
+	j, ok := s.jobs[orderID]
+	if !ok || j.lockedBy != lease.Owner || j.attempts != lease.Attempt {
+		return nil, models.ErrAccrualLeaseLost
+	}
+	return j, nil
+}
+
+func newTestAccrualStore() *testAccrualStore {
+	return &testAccrualStore{
+		mx:      &sync.Mutex{},
+		orders:  make(map[string]*models.Order),
+		jobs:    make(map[string]*testAccrualJob),
+		history: make(map[string][]string),
+	}
+}
+
+func (s *testAccrualStore) addOrder(number string) *models.Order {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	now := time.Now()
+	o := &models.Order{
+		ID:         fmt.Sprintf("order-%s", number),
+		UserID:     "1",
+		Number:     number,
+		Status:     models.OrderStatusNew,
+		UploadedAt: now,
+	}
+	s.orders[o.ID] = o
+	s.jobs[o.ID] = &testAccrualJob{enqueued: now, nextAttempt: now}
+	s.history[o.ID] = []string{models.OrderStatusNew}
+
+	return o
+}
+
+func (s *testAccrualStore) order(id string) (models.Order, *testAccrualJob, []string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	var j *testAccrualJob
+	if tj, ok := s.jobs[id]; ok {
+		c := *tj
+		j = &c
+	}
+	return *s.orders[id], j, append([]string(nil), s.history[id]...)
+}
+
+func (s *testAccrualStore) expect(mr *MockStorageMockRecorder) {
+	mr.LeaseOrdersForAccrual(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, owner string, limit int, lease time.Duration) ([]*models.AccrualJob, error) {
+			s.mx.Lock()
+			defer s.mx.Unlock()
+
+			now := time.Now()
+			var ids []string
+			for id, j := range s.jobs {
+				if !j.nextAttempt.After(now) && j.lockedUntil.Before(now) {
+					ids = append(ids, id)
+				}
+			}
+			sort.Slice(ids, func(i, k int) bool {
+				return s.jobs[ids[i]].nextAttempt.Before(s.jobs[ids[k]].nextAttempt)
+			})
+			if len(ids) > limit {
+				ids = ids[:limit]
+			}
+
+			js := make([]*models.AccrualJob, 0, len(ids))
+			for _, id := range ids {
+				j := s.jobs[id]
+				j.lockedUntil = now.Add(lease)
+				j.lockedBy = owner
+				j.attempts++
+
+				o := *s.orders[id]
+				js = append(js, &models.AccrualJob{
+					EnqueuedAt: j.enqueued,
+					Order:      &o,
+					Lease:      models.AccrualLease{Owner: owner, Attempt: j.attempts},
+					Attempts:   j.attempts,
+					Failures:   j.failures,
+				})
+			}
+			return js, nil
+		})
+	mr.RescheduleOrderAccrual(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, orderID string, lease models.AccrualLease, delay time.Duration) error {
+			s.mx.Lock()
+			defer s.mx.Unlock()
+
+			j, err := s.leased(orderID, lease)
+			if err != nil {
+				return err
+			}
+			j.lockedUntil = time.Time{}
+			j.lockedBy = ""
+			j.nextAttempt = time.Now().Add(delay)
+			return nil
+		})
+	mr.RetryOrderAccrual(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, orderID string, lease models.AccrualLease, delay time.Duration, _ string) error {
+			s.mx.Lock()
+			defer s.mx.Unlock()
+
+			j, err := s.leased(orderID, lease)
+			if err != nil {
+				return err
+			}
+			j.lockedUntil = time.Time{}
+			j.lockedBy = ""
+			j.failures++
+			j.nextAttempt = time.Now().Add(delay)
+			return nil
+		})
+	mr.UpdateOrder(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, order *models.Order, lease *models.AccrualLease) error {
+			s.mx.Lock()
+			defer s.mx.Unlock()
+
+			if lease != nil {
+				if _, err := s.leased(order.ID, *lease); err != nil {
+					return err
+				}
+			}
+
+			// The order status is an enum in the database.
+			if _, ok := orderStatuses[order.Status]; !ok {
+				return fmt.Errorf("invalid input value for enum order_status: %q", order.Status)
+			}
+
+			prev := s.orders[order.ID]
+			if !prev.StatusIsFinal() {
+				if prev.Status != order.Status {
+					s.history[order.ID] = append(s.history[order.ID], order.Status)
+				}
+				prev.Status = order.Status
+				prev.Accrual = order.Accrual
+				prev.StatusReason = order.StatusReason
+			}
+			if prev.StatusIsFinal() {
+				delete(s.jobs, order.ID)
+			}
+			return nil
+		})
+}
+
+func TestServer_RunOrderAccruals(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fake, accrual := accrualfake.NewServer()
+	defer accrual.Close()
+
+	store := newTestAccrualStore()
+	db := NewMockStorage(ctrl)
+	store.expect(db.EXPECT())
+
+	tests := []struct {
+		name         string
+		number       string
+		steps        []accrualfake.Step
+		wantStatus   string
+		wantAccrual  models.Amount
+		wantHistory  []string
+		wantFailures int
+	}{
+		{
+			name:   "registered, processing, processed",
+			number: "49927398716",
+			steps: []accrualfake.Step{
+				accrualfake.Registered(),
+				accrualfake.Processing(),
+				accrualfake.Processed(72998),
+			},
+			wantStatus:  models.OrderStatusProcessed,
+			wantAccrual: 72998,
+			wantHistory: []string{models.OrderStatusNew, models.OrderStatusProcessing, models.OrderStatusProcessed},
+		},
+		{
+			name:        "invalid",
+			number:      "1234567812345670",
+			steps:       []accrualfake.Step{accrualfake.Invalid()},
+			wantStatus:  models.OrderStatusInvalid,
+			wantHistory: []string{models.OrderStatusNew, models.OrderStatusInvalid},
+		},
+		{
+			name:         "not registered at first",
+			number:       "4026843483168683",
+			steps:        []accrualfake.Step{accrualfake.NotRegistered(), accrualfake.Processed(100)},
+			wantStatus:   models.OrderStatusProcessed,
+			wantAccrual:  100,
+			wantHistory:  []string{models.OrderStatusNew, models.OrderStatusProcessed},
+			wantFailures: 1,
+		},
+		{
+			name:         "never registered",
+			number:       "79927398713",
+			wantStatus:   models.OrderStatusInvalid,
+			wantHistory:  []string{models.OrderStatusNew, models.OrderStatusInvalid},
+			wantFailures: 2,
+		},
+		{
+			name:        "too many requests",
+			number:      "12345678903",
+			steps:       []accrualfake.Step{accrualfake.TooManyRequests("1"), accrualfake.Processed(200)},
+			wantStatus:  models.OrderStatusProcessed,
+			wantAccrual: 200,
+			wantHistory: []string{models.OrderStatusNew, models.OrderStatusProcessed},
+		},
+		{
+			name:         "internal error",
+			number:       "5555555555554444",
+			steps:        []accrualfake.Step{accrualfake.InternalError(), accrualfake.Processed(300)},
+			wantStatus:   models.OrderStatusProcessed,
+			wantAccrual:  300,
+			wantHistory:  []string{models.OrderStatusNew, models.OrderStatusProcessed},
+			wantFailures: 1,
+		},
+		{
+			name:   "not found until the accrual system is fixed",
+			number: "378282246310005",
+			steps: []accrualfake.Step{
+				{Code: http.StatusNotFound}, {Code: http.StatusNotFound}, {Code: http.StatusNotFound},
+				{Code: http.StatusNotFound}, accrualfake.Processed(500),
+			},
+			wantStatus:  models.OrderStatusProcessed,
+			wantAccrual: 500,
+			wantHistory: []string{models.OrderStatusNew, models.OrderStatusProcessed},
+		},
+		{
+			name:        "another order",
+			number:      "30569309025904",
+			steps:       []accrualfake.Step{{Body: `{"order":"79927398713","status":"PROCESSED","accrual":500}`}},
+			wantStatus:  models.OrderStatusInvalid,
+			wantHistory: []string{models.OrderStatusNew, models.OrderStatusInvalid},
+		},
+		{
+			name:   "invalid response",
+			number: "6011111111111117",
+			steps: []accrualfake.Step{
+				{Body: `{"order":"6011111111111117","status":"DONE"}`},
+				{Body: `{"order":"6011111111111117","status":"DONE"}`},
+				{Body: `{"order":"6011111111111117","status":"DONE"}`},
+				{Body: `{"order":"6011111111111117","status":"DONE"}`},
+				accrualfake.Processed(600),
+			},
+			wantStatus:  models.OrderStatusProcessed,
+			wantAccrual: 600,
+			wantHistory: []string{models.OrderStatusNew, models.OrderStatusProcessed},
+		},
+		{
+			name:        "slow response",
+			number:      "4111111111111111",
+			steps:       []accrualfake.Step{accrualfake.Slow(200*time.Millisecond, accrualfake.Processed(400))},
+			wantStatus:  models.OrderStatusProcessed,
+			wantAccrual: 400,
+			wantHistory: []string{models.OrderStatusNew, models.OrderStatusProcessed},
+		},
+	}
+
+	ids := make(map[string]string, len(tests))
+	for _, tt := range tests {
+		fake.Script(tt.number, tt.steps...)
+		ids[tt.number] = store.addOrder(tt.number).ID
+	}
+
+	s := &Server{
+		log:                zap.L().Sugar(),
+		accruals:           &sync.WaitGroup{},
+		instanceID:         "test",
+		accIntervalTimeout: 20 * time.Millisecond,
+		accWorkers:         2,
+		accMaxAttempts:     3,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a := adapters.NewAccrualClient(config.Config{Accrual: accrual.URL}, zap.L().Sugar())
+	s.RunOrderAccruals(ctx, a, db)
+	defer func() {
+		cancel()
+		s.accruals.Wait()
+	}()
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			id := ids[tt.number]
+			require.Eventually(t, func() bool {
+				o, _, _ := store.order(id)
+				return o.StatusIsFinal()
+			}, 10*time.Second, 10*time.Millisecond)
+
+			o, j, history := store.order(id)
+			require.Equal(t, tt.wantStatus, o.Status)
+			require.Equal(t, tt.wantAccrual, o.Accrual)
+			require.Equal(t, tt.wantHistory, history)
+			require.Nil(t, j, "the job of the final order must be deleted")
+			require.GreaterOrEqual(t, fake.Calls(tt.number), len(tt.steps))
+		})
+	}
+}
+
+func TestServer_RunOrderAccrualsCircuitOpen(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fake, accrual := accrualfake.NewServer()
+	defer accrual.Close()
+
+	store := newTestAccrualStore()
+	db := NewMockStorage(ctrl)
+	store.expect(db.EXPECT())
+
+	const number = "49927398716"
+	fake.Script(number, accrualfake.InternalError(), accrualfake.Processed(100))
+	id := store.addOrder(number).ID
+
+	s := &Server{
+		log:                zap.L().Sugar(),
+		accruals:           &sync.WaitGroup{},
+		instanceID:         "test",
+		accIntervalTimeout: 20 * time.Millisecond,
+		accWorkers:         2,
+		accMaxAttempts:     3,
+	}
+
+	cfg := config.Config{Accrual: accrual.URL, AccrualBreakerFailures: 1, AccrualBreakerOpenTimeout: time.Second}
+	a := adapters.NewAccrualClient(cfg, zap.L().Sugar())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.RunOrderAccruals(ctx, a, db)
+	defer func() {
+		cancel()
+		s.accruals.Wait()
+	}()
+
+	require.Eventually(t, func() bool {
+		return a.BreakerState() == adapters.BreakerOpen
+	}, 5*time.Second, 5*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		o, _, _ := store.order(id)
+		return o.StatusIsFinal()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	o, _, _ := store.order(id)
+	require.Equal(t, models.OrderStatusProcessed, o.Status)
+	require.Equal(t, adapters.BreakerClosed, a.BreakerState())
+	require.Equal(t, 2, fake.Calls(number), "no request must be sent while the circuit is open")
+}
+
+func TestServer_AccrueOrderLeaseLost(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fake, accrual := accrualfake.NewServer()
+	defer accrual.Close()
+
+	store := newTestAccrualStore()
+	db := NewMockStorage(ctrl)
+	store.expect(db.EXPECT())
+
+	const number = "49927398716"
+	id := store.addOrder(number).ID
+
+	ctx := context.Background()
+	js, err := db.LeaseOrdersForAccrual(ctx, "stale", 1, 0)
+	require.NoError(t, err)
+	require.Len(t, js, 1)
+	_, err = db.LeaseOrdersForAccrual(ctx, "current", 1, time.Minute)
+	require.NoError(t, err)
+
+	s := &Server{log: zap.L().Sugar(), accIntervalTimeout: time.Second}
+	a := adapters.NewAccrualClient(config.Config{Accrual: accrual.URL}, zap.L().Sugar())
+	limiter := newRateLimiter(0)
+
+	for _, step := range []accrualfake.Step{accrualfake.Processed(100), accrualfake.NotRegistered()} {
+		fake.Script(number, step)
+		err = s.accrueOrder(ctx, a, db, js[0], limiter)
+		require.ErrorIs(t, err, models.ErrAccrualLeaseLost)
+	}
+
+	o, j, history := store.order(id)
+	require.Equal(t, models.OrderStatusNew, o.Status, "the stale result must be dropped")
+	require.Equal(t, []string{models.OrderStatusNew}, history)
+	require.Equal(t, "current", j.lockedBy)
+	require.Zero(t, j.failures)
+}
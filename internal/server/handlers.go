@@ -6,57 +6,159 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"runtime"
+	"strings"
 	"time"
 
+	"github.com/go-chi/chi"
 	"go.uber.org/zap"
 
+	"github.com/ArtemShalinFe/gophermart/internal/config"
 	"github.com/ArtemShalinFe/gophermart/internal/models"
+	"github.com/ArtemShalinFe/gophermart/internal/notifier"
+	"github.com/ArtemShalinFe/gophermart/internal/security"
 )
 
 type Storage interface {
 	AddUser(ctx context.Context, us *models.UserDTO) (*models.User, error)
 	GetUser(ctx context.Context, us *models.UserDTO) (*models.User, error)
+	GetUserByID(ctx context.Context, userID string) (*models.User, error)
+	UpdateUserPassword(ctx context.Context, userID string, hash string) error
+	ChangeUserPassword(ctx context.Context, userID string, hash string) (*models.User, error)
+	AddPasswordResetToken(ctx context.Context, t *models.PasswordResetToken) error
+	GetPasswordResetUser(ctx context.Context, tokenHash string) (*models.User, error)
+	ResetUserPassword(ctx context.Context, tokenHash string, hash string) (*models.User, error)
 	GetBalance(ctx context.Context, userID string) (*models.UserBalance, error)
 	GetWithdrawalList(ctx context.Context, userID string) ([]*models.UserWithdrawalsHistory, error)
-	AddWithdrawn(ctx context.Context, userID string, orderNumber string, sum float64) error
+	AddWithdrawn(ctx context.Context, userID string, orderNumber string, sum models.Amount) error
 	GetUploadedOrders(ctx context.Context, order *models.User) ([]*models.Order, error)
-	GetOrdersForAccrual(ctx context.Context) ([]*models.Order, error)
+	GetUploadedOrdersPage(ctx context.Context, userID string, f *models.ListFilter) ([]*models.Order, error)
+	GetWithdrawalPage(ctx context.Context, userID string, f *models.ListFilter) ([]*models.UserWithdrawalsHistory, error)
+	LeaseOrdersForAccrual(ctx context.Context, owner string, limit int, lease time.Duration) ([]*models.AccrualJob, error)
+	RescheduleOrderAccrual(ctx context.Context, orderID string, lease models.AccrualLease, delay time.Duration) error
+	RetryOrderAccrual(ctx context.Context,
+		orderID string,
+		lease models.AccrualLease,
+		delay time.Duration,
+		reason string) error
 	AddOrder(ctx context.Context, order *models.OrderDTO) (*models.Order, error)
 	GetOrder(ctx context.Context, order *models.OrderDTO) (*models.Order, error)
-	UpdateOrder(ctx context.Context, order *models.Order) error
+	AddOrders(ctx context.Context, userID string, numbers []string) ([]*models.OrderBatchResult, error)
+	GetOrderHistory(ctx context.Context, orderID string) ([]*models.OrderStatusChange, error)
+	UpdateOrder(ctx context.Context, order *models.Order, lease *models.AccrualLease) error
+	StartIdempotentRequest(ctx context.Context, k *models.IdempotencyKey) (*models.IdempotencyKey, bool, error)
+	FinishIdempotentRequest(ctx context.Context, k *models.IdempotencyKey) error
+	CancelIdempotentRequest(ctx context.Context, k *models.IdempotencyKey) error
+	PurgeIdempotencyKeys(ctx context.Context) (int64, error)
+	AddRefreshToken(ctx context.Context, t *models.RefreshToken) error
+	RotateRefreshToken(ctx context.Context, hash string, next *models.RefreshToken) (*models.User, error)
+	RevokeRefreshToken(ctx context.Context, userID string, hash string) error
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	AccessTokenIsRevoked(ctx context.Context, jti string, userID string, sessionGen int64) (bool, error)
+	AccessTokenIDIsRevoked(ctx context.Context, jti string) (bool, error)
+	StartLoginAttempt(ctx context.Context, k models.LoginAttemptKey, p models.LoginLockoutPolicy) (time.Time, error)
+	CancelLoginAttempt(ctx context.Context, k models.LoginAttemptKey, p models.LoginLockoutPolicy) error
+	ResetLoginFailures(ctx context.Context, k models.LoginAttemptKey) error
+	PurgeLoginAttempts(ctx context.Context, olderThan time.Duration) (int64, error)
 }
 
 type HashController interface {
 	HashPassword(password string) (string, error)
 	CheckPasswordHash(hash string, password string) bool
+	CheckDummyHash(password string)
+	NeedsRehash(hash string) bool
+}
+
+type Notifier interface {
+	NotifyPasswordReset(ctx context.Context, m *notifier.PasswordReset) error
 }
 
 const authHeaderName = "Authorization"
 const contentTypeJSON = "application/json"
 const contentType = "Content-Type"
+const orderNumberParam = "number"
 
 var errUserUndefined = "user undefined"
 
+const errMalformedJSON = "request body is not a valid JSON"
+
 type Handlers struct {
-	store     Storage
-	hashc     HashController
-	log       *zap.SugaredLogger
-	secretKey []byte
-	tokenExp  time.Duration
+	store           Storage
+	hashc           HashController
+	passwords       *security.PasswordPolicy
+	notifier        Notifier
+	log             *zap.SugaredLogger
+	users           *userCache
+	keys            *security.KeySet
+	tokenExp        time.Duration
+	refreshTokenExp time.Duration
+	resetTokenExp   time.Duration
+	idempotencyTTL  time.Duration
+	loginPolicy     models.LoginLockoutPolicy
+	ipPolicy        models.LoginLockoutPolicy
+	adminKey        []byte
+	trustedProxies  []*net.IPNet
+	maxRequestBody  int64
+	accrual         AccrualState
+	hashSlots       chan struct{}
 }
 
-func NewHandlers(secretKey []byte,
-	db Storage,
-	log *zap.SugaredLogger,
-	tokenExp time.Duration,
-	hashc HashController) (*Handlers, error) {
+func NewHandlers(cfg config.Config, db Storage, log *zap.SugaredLogger, hashc HashController) (*Handlers, error) {
+	keys := security.NewHMACKeySet(cfg.Key)
+	if cfg.JWTKeysDir != "" {
+		var err error
+		keys, err = security.LoadKeySet(cfg.JWTKeysDir, cfg.JWTSigningKeyID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JWT keys err: %w", err)
+		}
+	}
+
+	passwords, err := security.NewPasswordPolicy(cfg.PasswordMinLength, cfg.PasswordMaxLength, cfg.PasswordDenyList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load password policy err: %w", err)
+	}
+
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load trusted proxies err: %w", err)
+	}
+
+	// The password reset is disabled until the tokens can be delivered, they are never written to the log.
+	var n Notifier
+	if cfg.PasswordResetFile != "" {
+		n = notifier.NewFile(cfg.PasswordResetFile)
+	}
+
 	return &Handlers{
-		store:     db,
-		secretKey: secretKey,
-		log:       log,
-		tokenExp:  tokenExp,
-		hashc:     hashc,
+		store:           db,
+		keys:            keys,
+		log:             log,
+		tokenExp:        cfg.TokenExp,
+		refreshTokenExp: cfg.RefreshTokenExp,
+		hashc:           hashc,
+		passwords:       passwords,
+		notifier:        n,
+		resetTokenExp:   cfg.PasswordResetExp,
+		idempotencyTTL:  cfg.IdempotencyTTL,
+		users:           newUserCache(cfg.UserCacheSize, userCacheTTL),
+		hashSlots:       make(chan struct{}, runtime.GOMAXPROCS(0)),
+		loginPolicy: models.LoginLockoutPolicy{
+			FreeAttempts: cfg.LoginMaxFailures,
+			BaseDelay:    loginLockoutBaseDelay,
+			MaxDelay:     cfg.LoginMaxLockout,
+			Window:       loginFailureWindow,
+		},
+		ipPolicy: models.LoginLockoutPolicy{
+			FreeAttempts: cfg.LoginMaxIPFailures,
+			BaseDelay:    loginLockoutBaseDelay,
+			MaxDelay:     cfg.LoginMaxLockout,
+			Window:       loginFailureWindow,
+		},
+		adminKey:       []byte(cfg.AdminKey),
+		trustedProxies: proxies,
+		maxRequestBody: cfg.MaxRequestBody,
 	}, nil
 }
 
@@ -67,33 +169,38 @@ func (h *Handlers) Register(ctx context.Context, w http.ResponseWriter, r *http.
 		return
 	}
 
-	u.Password, err = h.hashc.HashPassword(u.Password)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		h.log.Errorf("failed to get the password hash err: %w", err)
+	var ok bool
+	u.Password, ok = h.hashNewPassword(w, u.Login, u.Password)
+	if !ok {
 		return
 	}
 
-	if _, err = u.AddUser(ctx, h.store); err != nil {
+	us, err := u.AddUser(ctx, h.store)
+	if err != nil {
 		if errors.Is(err, models.ErrLoginIsBusy) {
-			w.WriteHeader(http.StatusConflict)
+			h.writeError(w, http.StatusConflict, codeLoginIsBusy, "login is already taken")
 			return
 		}
 
 		h.log.Errorf("failed add user in the Register request err: %w ", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeInternalError(w)
 		return
 	}
 
-	token, err := NewJWTToken(h.secretKey, u.Login, h.tokenExp)
+	rt, err := models.NewRefreshToken(us.ID, h.refreshTokenExp)
 	if err != nil {
-		h.log.Errorf("failed to build JWT token in the Register request err: %w ", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		h.log.Errorf("failed to build refresh token in the Register request err: %v", err)
+		h.writeInternalError(w)
 		return
 	}
 
-	w.Header().Set(authHeaderName, token)
-	w.WriteHeader(http.StatusOK)
+	if err := rt.Add(ctx, h.store); err != nil {
+		h.log.Errorf("failed to add refresh token in the Register request err: %v", err)
+		h.writeInternalError(w)
+		return
+	}
+
+	h.writeTokens(w, us, rt)
 }
 
 func (h *Handlers) Login(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -103,38 +210,232 @@ func (h *Handlers) Login(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	us, err := h.getUser(ctx, w, u)
+	lk, ik := h.loginAttemptKeys(r, u.Login)
+
+	until, err := h.startLoginAttempt(ctx, lk, ik)
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("failed to start login attempt in the Login request err: %v", err)
+		return
+	}
+
+	if !until.IsZero() {
+		h.writeTooManyRequests(w, until)
+		return
+	}
+
+	us, err := u.GetUser(ctx, h.store)
+	if err != nil && !errors.Is(err, models.ErrUnknowUser) {
+		h.writeInternalError(w)
+		h.log.Errorf("failed to get user the Login request err: %v", err)
+		return
+	}
+
+	var ok bool
+	if err == nil {
+		ok = h.hashc.CheckPasswordHash(us.PasswordHash, u.Password)
+	} else {
+		// The password is checked for the unknown login too, so the response time doesn't reveal the users.
+		h.hashc.CheckDummyHash(u.Password)
+	}
+
+	if !ok {
+		h.writeError(w, http.StatusUnauthorized, codeInvalidCredentials, "login or password is incorrect")
+		return
+	}
+
+	if err := lk.Reset(ctx, h.store); err != nil {
+		h.log.Errorf("failed to reset login failures in the Login request err: %v", err)
+	}
+	h.cancelAttempt(ctx, ik, h.ipPolicy)
+
+	if h.hashc.NeedsRehash(us.PasswordHash) {
+		h.rehashPassword(ctx, us, u.Password)
+	}
+
+	rt, err := models.NewRefreshToken(us.ID, h.refreshTokenExp)
+	if err != nil {
+		h.log.Errorf("failed to build refresh token in the Login request err: %v", err)
+		h.writeInternalError(w)
+		return
+	}
+
+	if err := rt.Add(ctx, h.store); err != nil {
+		h.log.Errorf("failed to add refresh token in the Login request err: %v", err)
+		h.writeInternalError(w)
+		return
+	}
+
+	h.writeTokens(w, us, rt)
+}
+
+// rehashPassword replaces the hash made with outdated parameters, the login does not fail if it is not possible.
+func (h *Handlers) rehashPassword(ctx context.Context, u *models.User, password string) {
+	hash, err := h.hashc.HashPassword(password)
 	if err != nil {
-		h.log.Errorf("failed to get user the Login request err: %w ", err)
+		h.log.Errorf("failed to rehash the password err: %v", err)
 		return
 	}
 
-	if !h.hashc.CheckPasswordHash(us.PasswordHash, u.Password) {
-		w.WriteHeader(http.StatusUnauthorized)
+	if err := u.UpdatePassword(ctx, h.store, hash); err != nil {
+		h.log.Errorf("failed to update the rehashed password err: %v", err)
 		return
 	}
 
-	token, err := NewJWTToken(h.secretKey, u.Login, h.tokenExp)
+	u.PasswordHash = hash
+}
+
+func (h *Handlers) writePasswordViolations(w http.ResponseWriter, vs []security.PasswordViolation) {
+	p := newProblem(http.StatusBadRequest, codePasswordPolicy, "password does not meet the password policy")
+	p.Violations = vs
+	h.writeProblem(w, p)
+}
+
+func (h *Handlers) RefreshToken(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	token, err := h.getRefreshToken(w, r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		h.log.Errorf("failed to read the RefreshToken request err: %v", err)
+		return
+	}
+
+	if token == "" {
+		h.writeError(w, http.StatusBadRequest, codeRefreshTokenEmpty, "refresh token is required")
+		return
+	}
+
+	next, err := models.NewRefreshToken("", h.refreshTokenExp)
+	if err != nil {
+		h.log.Errorf("failed to build refresh token in the RefreshToken request err: %v", err)
+		h.writeInternalError(w)
+		return
+	}
+
+	rt := &models.RefreshToken{Hash: models.HashRefreshToken(token)}
+	u, err := rt.Rotate(ctx, h.store, next)
+	if err != nil {
+		if errors.Is(err, models.ErrRefreshTokenReused) {
+			h.log.Warnf("refresh token reuse was detected, the token family is revoked")
+			h.writeError(w, http.StatusUnauthorized, codeRefreshTokenReused, "refresh token was used before")
+			return
+		}
+		if errors.Is(err, models.ErrRefreshTokenIsInvalid) {
+			h.writeError(w, http.StatusUnauthorized, codeRefreshTokenInvalid, "refresh token is invalid or expired")
+			return
+		}
+
+		h.log.Errorf("failed to rotate refresh token in the RefreshToken request err: %v", err)
+		h.writeInternalError(w)
+		return
+	}
+
+	h.writeTokens(w, u, next)
+}
+
+func (h *Handlers) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	u, ok := userFromContext(ctx)
+	if !ok {
+		h.writeError(w, http.StatusBadRequest, codeUserUndefined, errUserUndefined)
+		h.log.Errorf(errUserUndefined)
+		return
+	}
+
+	claims, ok := claimsFromContext(ctx)
+	if !ok {
+		h.writeError(w, http.StatusBadRequest, codeUserUndefined, "claims undefined")
+		h.log.Errorf("claims undefined")
+		return
+	}
+
+	token, err := h.getRefreshToken(w, r)
+	if err != nil {
+		h.log.Errorf("failed to read the Logout request err: %v", err)
+		return
+	}
+
+	if token != "" {
+		rt := &models.RefreshToken{
+			UserID: u.ID,
+			Hash:   models.HashRefreshToken(token),
+		}
+		if err := rt.Revoke(ctx, h.store); err != nil {
+			h.writeInternalError(w)
+			h.log.Errorf("failed to revoke refresh token in the Logout request err: %v", err)
+			return
+		}
+	}
+
+	if err := models.RevokeAccessToken(ctx, h.store, claims.ID, claims.ExpiresAt.Time); err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("failed to revoke access token in the Logout request err: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// getRefreshToken reads the refresh token from the body, the body may be empty.
+func (h *Handlers) getRefreshToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeInternalError(w)
+		return "", fmt.Errorf("failed get refresh token from body err: %w", err)
+	}
+
+	if len(b) == 0 {
+		return "", nil
+	}
+
+	req := struct {
+		RefreshToken string `json:"refresh_token"`
+	}{}
+
+	if err := json.Unmarshal(b, &req); err != nil {
+		h.writeError(w, http.StatusBadRequest, codeMalformedRequest, errMalformedJSON)
+		return "", fmt.Errorf("failed unmarhsal refresh token err: %w", err)
+	}
+
+	return req.RefreshToken, nil
+}
+
+func (h *Handlers) writeTokens(w http.ResponseWriter, u *models.User, rt *models.RefreshToken) {
+	token, err := NewJWTToken(h.keys, u.ID, u.SessionGen, h.tokenExp)
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("failed to build JWT token err: %v", err)
+		return
+	}
+
+	b, err := json.Marshal(&models.TokenPair{
+		AccessToken:  token,
+		RefreshToken: rt.Token,
+		ExpiresIn:    int64(h.tokenExp.Seconds()),
+	})
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("tokens marshal to json err: %v", err)
 		return
 	}
 
 	w.Header().Set(authHeaderName, token)
+	w.Header().Set(contentType, contentTypeJSON)
 	w.WriteHeader(http.StatusOK)
+
+	if _, err = w.Write(b); err != nil {
+		h.log.Errorf("failed to write tokens err: %v", err)
+	}
 }
 
 func (h *Handlers) AddOrder(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	u, ok := userFromContext(ctx)
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeError(w, http.StatusBadRequest, codeUserUndefined, errUserUndefined)
 		h.log.Errorf(errUserUndefined)
 		return
 	}
 
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeInternalError(w)
 		h.log.Errorf("failed to read the AddOrder request body err: %w ", err)
 		return
 	}
@@ -145,26 +446,26 @@ func (h *Handlers) AddOrder(ctx context.Context, w http.ResponseWriter, r *http.
 	}
 
 	if !o.NumberIsCorrect() {
-		w.WriteHeader(http.StatusUnprocessableEntity)
+		h.writeError(w, http.StatusUnprocessableEntity, codeOrderNumberInvalid, "order number is incorrect")
 		return
 	}
 
 	if _, err = o.AddOrder(ctx, h.store); err != nil {
 		if !errors.Is(err, models.ErrOrderWasRegisteredEarlier) {
-			w.WriteHeader(http.StatusBadRequest)
+			h.writeInternalError(w)
 			h.log.Errorf("failed to add order in the AddOrder request err: %w ", err)
 			return
 		}
 
 		o, err := o.GetOrder(ctx, h.store)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			h.writeInternalError(w)
 			h.log.Errorf("failed to get the order the AddOrder request body err: %w ", err)
 			return
 		}
 
 		if o.UserID != u.ID {
-			w.WriteHeader(http.StatusConflict)
+			h.writeError(w, http.StatusConflict, codeOrderOwnedByAnotherUser, "order was uploaded by another user")
 			return
 		} else {
 			w.WriteHeader(http.StatusOK)
@@ -175,24 +476,88 @@ func (h *Handlers) AddOrder(ctx context.Context, w http.ResponseWriter, r *http.
 	w.WriteHeader(http.StatusAccepted)
 }
 
+func (h *Handlers) GetOrder(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	u, ok := userFromContext(ctx)
+	if !ok {
+		h.writeError(w, http.StatusBadRequest, codeUserUndefined, errUserUndefined)
+		h.log.Errorf(errUserUndefined)
+		return
+	}
+
+	dto := &models.OrderDTO{Number: chi.URLParam(r, orderNumberParam)}
+	if !dto.NumberIsCorrect() {
+		h.writeError(w, http.StatusUnprocessableEntity, codeOrderNumberInvalid, "order number is incorrect")
+		return
+	}
+
+	o, err := dto.GetOrder(ctx, h.store)
+	if err != nil {
+		if errors.Is(err, models.ErrOrderNotFound) {
+			h.writeError(w, http.StatusNotFound, codeOrderNotFound, "order is not found")
+			return
+		}
+		h.writeInternalError(w)
+		h.log.Errorf("failed to get the order in the GetOrder request err: %v", err)
+		return
+	}
+
+	// Another user's order is not found, so the numbers uploaded by other users are not revealed.
+	if o.UserID != u.ID {
+		h.writeError(w, http.StatusNotFound, codeOrderNotFound, "order is not found")
+		return
+	}
+	o.UserID = ""
+
+	d, err := o.Details(ctx, h.store)
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("failed to get the order history in the GetOrder request err: %v", err)
+		return
+	}
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("order details marshal to json err: %v", err)
+		return
+	}
+
+	w.Header().Set(contentType, contentTypeJSON)
+
+	if _, err = w.Write(b); err != nil {
+		h.log.Errorf("failed to write order details err: %v", err)
+	}
+}
+
 func (h *Handlers) GetOrders(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	u, ok := userFromContext(ctx)
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeError(w, http.StatusBadRequest, codeUserUndefined, errUserUndefined)
 		h.log.Errorf(errUserUndefined)
 		return
 	}
 
+	f, paged, err := parseListFilter(r.URL.Query(), true)
+	if err != nil {
+		h.writeListFilterError(w, err)
+		return
+	}
+
+	if paged {
+		h.getOrdersPage(ctx, w, u, f)
+		return
+	}
+
 	os, err := u.GetUploadedOrders(ctx, h.store)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeInternalError(w)
 		h.log.Errorf("get uploaded orders err: %w", err)
 		return
 	}
 
 	b, err := json.Marshal(os)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeInternalError(w)
 		h.log.Errorf("GetOrders marshal to json err: %w", err)
 		return
 	}
@@ -200,7 +565,6 @@ func (h *Handlers) GetOrders(ctx context.Context, w http.ResponseWriter, r *http
 	w.Header().Set(contentType, contentTypeJSON)
 
 	if _, err = w.Write(b); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
 		h.log.Errorf("GetMetric error: %w", err)
 		return
 	}
@@ -209,21 +573,21 @@ func (h *Handlers) GetOrders(ctx context.Context, w http.ResponseWriter, r *http
 func (h *Handlers) GetBalance(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	u, ok := userFromContext(ctx)
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeError(w, http.StatusBadRequest, codeUserUndefined, errUserUndefined)
 		h.log.Errorf(errUserUndefined)
 		return
 	}
 
 	bl, err := u.GetBalance(ctx, h.store)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeInternalError(w)
 		h.log.Errorf("failed to get user current balance in the GetBalance request err: %w ", err)
 		return
 	}
 
 	b, err := json.Marshal(&bl)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeInternalError(w)
 		h.log.Errorf("GetBalance marshal to json err: %w", err)
 		return
 	}
@@ -231,7 +595,6 @@ func (h *Handlers) GetBalance(ctx context.Context, w http.ResponseWriter, r *htt
 	w.Header().Set(contentType, contentTypeJSON)
 
 	if _, err = w.Write(b); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
 		h.log.Errorf("GetBalance error: %w", err)
 		return
 	}
@@ -240,35 +603,47 @@ func (h *Handlers) GetBalance(ctx context.Context, w http.ResponseWriter, r *htt
 func (h *Handlers) AddBalanceWithdrawn(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	u, ok := userFromContext(ctx)
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeError(w, http.StatusBadRequest, codeUserUndefined, errUserUndefined)
 		h.log.Errorf(errUserUndefined)
 		return
 	}
 
 	req := struct {
-		Order string  `json:"order"`
-		Sum   float64 `json:"sum"`
+		Order string        `json:"order"`
+		Sum   models.Amount `json:"sum"`
 	}{}
 
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeInternalError(w)
 		h.log.Errorf("failed get order and accrual from body err: %w", err)
 		return
 	}
 
 	if err := json.Unmarshal(b, &req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeError(w, http.StatusBadRequest, codeMalformedRequest, errMalformedJSON)
 		h.log.Errorf("failed unmarhsal order and accrual err: %w", err)
 		return
 	}
 
-	if err := u.AddWithdrawn(ctx, h.store, req.Order, req.Sum); err != nil {
-		if errors.Is(err, models.ErrNotEnoughAccruals) {
-			w.WriteHeader(http.StatusPaymentRequired)
+	// The number is trimmed the same way as the body of AddOrder and the numbers of AddOrderBatch.
+	if err := u.AddWithdrawn(ctx, h.store, strings.TrimSpace(req.Order), req.Sum); err != nil {
+		switch {
+		case errors.Is(err, models.ErrOrderNumberIsIncorrect):
+			h.writeError(w, http.StatusUnprocessableEntity, codeOrderNumberInvalid, "order number is incorrect")
+			return
+		case errors.Is(err, models.ErrWithdrawalSumIsNotPositive):
+			h.writeError(w, http.StatusBadRequest, codeWithdrawalSumInvalid, "withdrawal sum must be positive")
+			return
+		case errors.Is(err, models.ErrWithdrawalWasRegisteredEarlier):
+			h.writeError(w, http.StatusConflict, codeWithdrawalRegistered,
+				"the withdrawal for the order was registered earlier")
+			return
+		case errors.Is(err, models.ErrNotEnoughAccruals):
+			h.writeError(w, http.StatusPaymentRequired, codeNotEnoughAccruals, "not enough points on the balance")
 			return
 		}
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeInternalError(w)
 		h.log.Errorf("failed to add withdrawal err: %w", err)
 		return
 	}
@@ -277,14 +652,25 @@ func (h *Handlers) AddBalanceWithdrawn(ctx context.Context, w http.ResponseWrite
 func (h *Handlers) GetBalanceMovementHistory(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	u, ok := userFromContext(ctx)
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeError(w, http.StatusBadRequest, codeUserUndefined, errUserUndefined)
 		h.log.Errorf(errUserUndefined)
 		return
 	}
 
+	f, paged, err := parseListFilter(r.URL.Query(), false)
+	if err != nil {
+		h.writeListFilterError(w, err)
+		return
+	}
+
+	if paged {
+		h.getWithdrawalPage(ctx, w, u, f)
+		return
+	}
+
 	ub, err := u.GetWithdrawalList(ctx, h.store)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeInternalError(w)
 		h.log.Errorf("failed to get user balance history in the GetBalanceMovementHistory request err: %w ", err)
 		return
 	}
@@ -296,7 +682,7 @@ func (h *Handlers) GetBalanceMovementHistory(ctx context.Context, w http.Respons
 
 	b, err := json.Marshal(&ub)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeInternalError(w)
 		h.log.Errorf("GetBalanceMovementHistory marshal to json err: %w", err)
 		return
 	}
@@ -304,45 +690,46 @@ func (h *Handlers) GetBalanceMovementHistory(ctx context.Context, w http.Respons
 	w.Header().Set(contentType, contentTypeJSON)
 
 	if _, err = w.Write(b); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
 		h.log.Errorf("GetBalanceMovementHistory error: %w", err)
 		return
 	}
 }
 
+func (h *Handlers) GetJWKS(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(h.keys.JWKS())
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("GetJWKS marshal to json err: %v", err)
+		return
+	}
+
+	w.Header().Set(contentType, contentTypeJSON)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	if _, err = w.Write(b); err != nil {
+		h.log.Errorf("GetJWKS error: %v", err)
+		return
+	}
+}
+
 func (h *Handlers) getLoginPsw(w http.ResponseWriter, r *http.Request) (*models.UserDTO, error) {
 	var u models.UserDTO
 
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeInternalError(w)
 		return nil, fmt.Errorf("failed get login and password from body err: %w", err)
 	}
 
 	if err := json.Unmarshal(b, &u); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeError(w, http.StatusBadRequest, codeMalformedRequest, errMalformedJSON)
 		return nil, fmt.Errorf("failed unmarhsal login and password err: %w", err)
 	}
 
 	if u.Login == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		h.writeError(w, http.StatusBadRequest, codeLoginEmpty, "login is empty")
 		return nil, errors.New("login is empty")
 	}
 
 	return &u, nil
 }
-
-func (h *Handlers) getUser(ctx context.Context, w http.ResponseWriter, u *models.UserDTO) (*models.User, error) {
-	us, err := u.GetUser(ctx, h.store)
-	if err != nil {
-		if errors.Is(err, models.ErrUnknowUser) {
-			w.WriteHeader(http.StatusUnauthorized)
-			return nil, fmt.Errorf("user is unauthorized err: %w", err)
-		}
-
-		w.WriteHeader(http.StatusInternalServerError)
-		return nil, fmt.Errorf("error getting user: %w ", err)
-	}
-
-	return us, nil
-}
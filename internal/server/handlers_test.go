@@ -2,12 +2,14 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,7 +17,9 @@ import (
 	"go.uber.org/mock/gomock"
 	"go.uber.org/zap"
 
+	"github.com/ArtemShalinFe/gophermart/internal/config"
 	"github.com/ArtemShalinFe/gophermart/internal/models"
+	"github.com/ArtemShalinFe/gophermart/internal/security"
 )
 
 func TestHandlers_Register(t *testing.T) {
@@ -24,17 +28,18 @@ func TestHandlers_Register(t *testing.T) {
 
 	db := NewMockStorage(ctrl)
 	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
 
 	var test = "test"
 
 	u1Dto := &models.UserDTO{
 		Login:    test,
-		Password: test,
+		Password: "correct horse",
 	}
 
 	u2Dto := &models.UserDTO{
 		Login:    "test2",
-		Password: "test3",
+		Password: "battery staple",
 	}
 
 	u1 := &models.User{
@@ -42,18 +47,26 @@ func TestHandlers_Register(t *testing.T) {
 		PasswordHash: test,
 	}
 
+	const tooLongForBcrypt = "correct horse battery staple"
+
 	hc := hashc.EXPECT()
 	hc.HashPassword(u1Dto.Password).Return(u1.PasswordHash, nil)
 	hc.HashPassword(u2Dto.Password).Return(u2Dto.Password, nil)
+	hc.HashPassword(tooLongForBcrypt).Return("", fmt.Errorf("get password hash err: %w", security.ErrPasswordTooLong))
 
 	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
 
-	addUserCall := mr.AddUser(gomock.Any(), u1Dto)
+	addUserCall := mr.AddUser(gomock.Any(), &models.UserDTO{Login: u1.Login, Password: u1.PasswordHash})
 	addUserCall.Return(u1, nil)
 
 	mr.AddUser(gomock.Any(), u2Dto).After(addUserCall).Return(nil, models.ErrLoginIsBusy)
 
-	h, err := NewHandlers([]byte("keyRegister"), db, zap.L().Sugar(), time.Hour*1, hashc)
+	h, err := NewHandlers(testConfig("keyRegister"), db, zap.L().Sugar(), hashc)
 	if err != nil {
 		t.Error(err)
 	}
@@ -63,34 +76,59 @@ func TestHandlers_Register(t *testing.T) {
 	defer testServer.Close()
 
 	var tests = []struct {
-		UserDTO interface{}
-		name    string
-		url     string
-		method  string
-		status  int
+		UserDTO    interface{}
+		code       string
+		name       string
+		url        string
+		method     string
+		violations []string
+		status     int
 	}{
 		{
-			name:   "Test user register",
-			url:    "/api/user/register",
-			status: 200,
-			method: http.MethodPost,
-			UserDTO: &models.UserDTO{
-				Login:    test,
-				Password: test,
-			},
+			name:    "Test user register",
+			url:     "/api/user/register",
+			status:  200,
+			method:  http.MethodPost,
+			UserDTO: u1Dto,
 		},
 		{
-			name:   "Test user register with same login",
-			url:    "/api/user/register",
-			status: 409,
-			method: http.MethodPost,
-			UserDTO: &models.UserDTO{
-				Login:    "test2",
-				Password: "test3",
-			},
+			name:    "Test user register with same login",
+			code:    codeLoginIsBusy,
+			url:     "/api/user/register",
+			status:  409,
+			method:  http.MethodPost,
+			UserDTO: u2Dto,
+		},
+		{
+			name:       "Test user register with short password containing login",
+			code:       codePasswordPolicy,
+			url:        "/api/user/register",
+			status:     400,
+			method:     http.MethodPost,
+			UserDTO:    &models.UserDTO{Login: "test3", Password: "Test3"},
+			violations: []string{security.PasswordTooShort, security.PasswordHasLogin},
+		},
+		{
+			name:       "Test user register with common password",
+			code:       codePasswordPolicy,
+			url:        "/api/user/register",
+			status:     400,
+			method:     http.MethodPost,
+			UserDTO:    &models.UserDTO{Login: "test3", Password: "Password1"},
+			violations: []string{security.PasswordIsCommon},
+		},
+		{
+			name:       "Test user register with password too long for the hash",
+			code:       codePasswordPolicy,
+			url:        "/api/user/register",
+			status:     400,
+			method:     http.MethodPost,
+			UserDTO:    &models.UserDTO{Login: "test3", Password: tooLongForBcrypt},
+			violations: []string{security.PasswordTooLong},
 		},
 		{
 			name:    "Test user register with broken body #1",
+			code:    codeLoginEmpty,
 			url:     "/api/user/register",
 			status:  400,
 			method:  http.MethodPost,
@@ -98,6 +136,7 @@ func TestHandlers_Register(t *testing.T) {
 		},
 		{
 			name:    "Test user register with broken body #2",
+			code:    codeLoginEmpty,
 			url:     "/api/user/register",
 			status:  400,
 			method:  http.MethodPost,
@@ -105,6 +144,7 @@ func TestHandlers_Register(t *testing.T) {
 		},
 		{
 			name:   "Test user register with broken body #3",
+			code:   codeLoginEmpty,
 			url:    "/api/user/register",
 			status: 400,
 			method: http.MethodPost,
@@ -123,7 +163,7 @@ func TestHandlers_Register(t *testing.T) {
 			t.Error(err)
 		}
 
-		resp, _ := testRequest(t, testServer, v.method, v.url, "", bytes.NewBuffer(b))
+		resp, body := testRequest(t, testServer, v.method, v.url, "", bytes.NewBuffer(b))
 		if err := resp.Body.Close(); err != nil {
 			t.Error(err)
 		}
@@ -131,6 +171,23 @@ func TestHandlers_Register(t *testing.T) {
 		require.Equal(t, v.status, resp.StatusCode,
 			fmt.Sprintf("TestRegisterHandler: %s URL: %s, want: %d, have: %d",
 				v.name, v.url, v.status, resp.StatusCode))
+
+		if v.code != "" {
+			requireProblem(t, resp, body, v.status, v.code)
+		}
+
+		if v.violations != nil {
+			var res struct {
+				Violations []security.PasswordViolation `json:"violations"`
+			}
+			require.NoError(t, json.Unmarshal(body, &res), v.name)
+
+			codes := make([]string, 0, len(res.Violations))
+			for _, vi := range res.Violations {
+				codes = append(codes, vi.Code)
+			}
+			require.Equal(t, v.violations, codes, v.name)
+		}
 	}
 }
 
@@ -140,6 +197,7 @@ func TestHandlers_Login(t *testing.T) {
 
 	db := NewMockStorage(ctrl)
 	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
 
 	var test = "test"
 
@@ -160,13 +218,20 @@ func TestHandlers_Login(t *testing.T) {
 
 	hc := hashc.EXPECT()
 	hc.CheckPasswordHash(u1.PasswordHash, u1Dto.Password).Return(true)
+	// The unknown login takes as long as a wrong password.
+	hc.CheckDummyHash(u2Dto.Password)
 
 	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
 
 	mr.GetUser(gomock.Any(), u1Dto).Return(u1, nil)
 	mr.GetUser(gomock.Any(), u2Dto).Return(nil, models.ErrUnknowUser)
 
-	h, err := NewHandlers([]byte("keyLogin"), db, zap.L().Sugar(), time.Hour*1, hashc)
+	h, err := NewHandlers(testConfig("keyLogin"), db, zap.L().Sugar(), hashc)
 	if err != nil {
 		t.Error(err)
 	}
@@ -177,6 +242,7 @@ func TestHandlers_Login(t *testing.T) {
 
 	var tests = []struct {
 		UserDTO any
+		code    string
 		name    string
 		url     string
 		method  string
@@ -191,6 +257,7 @@ func TestHandlers_Login(t *testing.T) {
 		},
 		{
 			name:    "Test user login with broken pass",
+			code:    codeInvalidCredentials,
 			url:     "/api/user/login",
 			status:  401,
 			method:  http.MethodPost,
@@ -198,6 +265,7 @@ func TestHandlers_Login(t *testing.T) {
 		},
 		{
 			name:    "Test user login with broken body #1",
+			code:    codeLoginEmpty,
 			url:     "/api/user/login",
 			status:  400,
 			method:  http.MethodPost,
@@ -205,6 +273,7 @@ func TestHandlers_Login(t *testing.T) {
 		},
 		{
 			name:    "Test user login with broken body #2",
+			code:    codeLoginEmpty,
 			url:     "/api/user/login",
 			status:  400,
 			method:  http.MethodPost,
@@ -220,7 +289,7 @@ func TestHandlers_Login(t *testing.T) {
 			t.Error(err)
 		}
 
-		resp, _ := testRequest(t,
+		resp, body := testRequest(t,
 			testServer,
 			v.method,
 			v.url,
@@ -234,7 +303,55 @@ func TestHandlers_Login(t *testing.T) {
 		require.Equal(t, v.status, resp.StatusCode,
 			fmt.Sprintf("TestLoginHandler: %s URL: %s, want: %d, have: %d",
 				v.name, v.url, v.status, resp.StatusCode))
+
+		if v.code != "" {
+			requireProblem(t, resp, body, v.status, v.code)
+		}
+	}
+}
+
+func TestHandlers_LoginRehash(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := NewMockStorage(ctrl)
+	hashc := NewMockHashController(ctrl)
+
+	u1Dto := &models.UserDTO{Login: "test", Password: "correct horse"}
+	u1 := &models.User{ID: "1", Login: "test", PasswordHash: "$2a$10$outdated"}
+
+	hc := hashc.EXPECT()
+	hc.CheckPasswordHash(u1.PasswordHash, u1Dto.Password).Return(true)
+	hc.NeedsRehash(u1.PasswordHash).Return(true)
+	hc.HashPassword(u1Dto.Password).Return("$argon2id$fresh", nil)
+
+	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.GetUser(gomock.Any(), u1Dto).Return(u1, nil)
+	mr.UpdateUserPassword(gomock.Any(), u1.ID, "$argon2id$fresh").Return(nil)
+
+	h, err := NewHandlers(testConfig("keyLoginRehash"), db, zap.L().Sugar(), hashc)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testServer := httptest.NewServer(initRouter(h))
+	defer testServer.Close()
+
+	b, err := json.Marshal(u1Dto)
+	if err != nil {
+		t.Error(err)
 	}
+
+	resp, _ := testRequest(t, testServer, http.MethodPost, "/api/user/login", "", bytes.NewBuffer(b))
+	if err := resp.Body.Close(); err != nil {
+		t.Error(err)
+	}
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
 func TestHandlers_AddOrder(t *testing.T) {
@@ -243,6 +360,7 @@ func TestHandlers_AddOrder(t *testing.T) {
 
 	db := NewMockStorage(ctrl)
 	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
 
 	o1Dto := &models.OrderDTO{
 		Number: "49927398716",
@@ -293,21 +411,11 @@ func TestHandlers_AddOrder(t *testing.T) {
 		Password: test,
 	}
 
-	u1Claims := &models.UserDTO{
-		Login:    test,
-		Password: "",
-	}
-
 	u2Dto := &models.UserDTO{
 		Login:    "test2",
 		Password: "test2",
 	}
 
-	u2Claims := &models.UserDTO{
-		Login:    "test2",
-		Password: "",
-	}
-
 	u1 := &models.User{
 		ID:           "1",
 		Login:        test,
@@ -324,12 +432,15 @@ func TestHandlers_AddOrder(t *testing.T) {
 	hc.CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
 
 	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
 
 	mr.GetUser(gomock.Any(), u1Dto).AnyTimes().Return(u1, nil)
-	mr.GetUser(gomock.Any(), u1Claims).AnyTimes().Return(u1, nil)
 
 	mr.GetUser(gomock.Any(), u2Dto).AnyTimes().Return(u2, nil)
-	mr.GetUser(gomock.Any(), u2Claims).AnyTimes().Return(u2, nil)
 
 	mr.AddOrder(gomock.Any(), o1Dto).AnyTimes().Return(o1, nil)
 	mr.AddOrder(gomock.Any(), o2Dto).AnyTimes().Return(nil, models.ErrOrderWasRegisteredEarlier)
@@ -339,7 +450,7 @@ func TestHandlers_AddOrder(t *testing.T) {
 	mr.GetOrder(gomock.Any(), o2Dto).AnyTimes().Return(o2, nil)
 	mr.GetOrder(gomock.Any(), o3Dto).AnyTimes().Return(o3, nil)
 
-	h, err := NewHandlers([]byte("keyAddOrder"), db, zap.L().Sugar(), time.Hour*1, hashc)
+	h, err := NewHandlers(testConfig("keyAddOrder"), db, zap.L().Sugar(), hashc)
 	if err != nil {
 		t.Error(err)
 	}
@@ -351,6 +462,7 @@ func TestHandlers_AddOrder(t *testing.T) {
 	var tests = []struct {
 		authReq any
 		body    any
+		code    string
 		name    string
 		url     string
 		method  string
@@ -358,6 +470,7 @@ func TestHandlers_AddOrder(t *testing.T) {
 	}{
 		{
 			name:    "Add order unauthorized",
+			code:    codeUnauthorized,
 			url:     "/api/user/orders",
 			status:  http.StatusUnauthorized,
 			method:  http.MethodPost,
@@ -380,8 +493,18 @@ func TestHandlers_AddOrder(t *testing.T) {
 			authReq: &models.UserDTO{Login: test, Password: test},
 			body:    1234567812345670,
 		},
+		{
+			name:    "Add order with incorrect number",
+			code:    codeOrderNumberInvalid,
+			url:     "/api/user/orders",
+			status:  http.StatusUnprocessableEntity,
+			method:  http.MethodPost,
+			authReq: &models.UserDTO{Login: test, Password: test},
+			body:    12345678,
+		},
 		{
 			name:    "Add added order",
+			code:    codeOrderOwnedByAnotherUser,
 			url:     "/api/user/orders",
 			status:  http.StatusConflict,
 			method:  http.MethodPost,
@@ -398,7 +521,7 @@ func TestHandlers_AddOrder(t *testing.T) {
 			t.Error(err)
 		}
 
-		resp, _ := testRequest(t,
+		resp, body := testRequest(t,
 			testServer,
 			v.method,
 			v.url,
@@ -412,6 +535,10 @@ func TestHandlers_AddOrder(t *testing.T) {
 		require.Equal(t, v.status, resp.StatusCode,
 			fmt.Sprintf("TestAddOrderHandler: %s URL: %s, want: %d, have: %d",
 				v.name, v.url, v.status, resp.StatusCode))
+
+		if v.code != "" {
+			requireProblem(t, resp, body, v.status, v.code)
+		}
 	}
 }
 
@@ -421,6 +548,7 @@ func TestHandlers_GetOrders(t *testing.T) {
 
 	db := NewMockStorage(ctrl)
 	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
 
 	var test = "test"
 
@@ -436,11 +564,6 @@ func TestHandlers_GetOrders(t *testing.T) {
 	var ors []*models.Order
 	ors = append(ors, o1)
 
-	u1Claims := &models.UserDTO{
-		Login:    test,
-		Password: "",
-	}
-
 	u1Dto := &models.UserDTO{
 		Login:    test,
 		Password: test,
@@ -456,12 +579,16 @@ func TestHandlers_GetOrders(t *testing.T) {
 	hc.CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
 
 	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
 
 	mr.GetUser(gomock.Any(), u1Dto).AnyTimes().Return(u1, nil)
-	mr.GetUser(gomock.Any(), u1Claims).AnyTimes().Return(u1, nil)
-	mr.GetUploadedOrders(gomock.Any(), u1).AnyTimes().Return(ors, nil)
+	mr.GetUploadedOrders(gomock.Any(), &models.User{ID: u1.ID}).AnyTimes().Return(ors, nil)
 
-	h, err := NewHandlers([]byte("keyGetOrder"), db, zap.L().Sugar(), time.Hour*1, hashc)
+	h, err := NewHandlers(testConfig("keyGetOrder"), db, zap.L().Sugar(), hashc)
 	if err != nil {
 		t.Error(err)
 	}
@@ -533,19 +660,127 @@ func TestHandlers_GetOrders(t *testing.T) {
 	}
 }
 
-func TestHandlers_GetBalance(t *testing.T) {
+func TestHandlers_GetOrder(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	db := NewMockStorage(ctrl)
 	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+	hashc.EXPECT().CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
 
-	var test = "test"
+	u1 := &models.User{ID: "1", Login: "test", PasswordHash: "test"}
 
-	u1Claims := &models.UserDTO{
-		Login:    test,
-		Password: "",
+	own := &models.Order{ID: "1", UserID: u1.ID, Number: "49927398716", Status: models.OrderStatusProcessed}
+	other := &models.Order{ID: "2", UserID: "2", Number: "12345678903", Status: models.OrderStatusNew}
+
+	history := []*models.OrderStatusChange{
+		{Status: models.OrderStatusNew},
+		{Status: models.OrderStatusProcessing, Response: json.RawMessage(`{"status":"PROCESSING"}`)},
+		{Status: models.OrderStatusProcessed, Accrual: 500, Response: json.RawMessage(`{"status":"PROCESSED"}`)},
+	}
+
+	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
+	mr.GetUser(gomock.Any(), gomock.Any()).AnyTimes().Return(u1, nil)
+	mr.GetOrder(gomock.Any(), &models.OrderDTO{Number: own.Number}).AnyTimes().DoAndReturn(
+		func(context.Context, *models.OrderDTO) (*models.Order, error) {
+			c := *own
+			return &c, nil
+		})
+	mr.GetOrder(gomock.Any(), &models.OrderDTO{Number: other.Number}).AnyTimes().Return(other, nil)
+	mr.GetOrder(gomock.Any(), gomock.Any()).AnyTimes().Return(nil, models.ErrOrderNotFound)
+	mr.GetOrderHistory(gomock.Any(), own.ID).AnyTimes().Return(history, nil)
+
+	h, err := NewHandlers(testConfig("keyGetOrder"), db, zap.L().Sugar(), hashc)
+	require.NoError(t, err)
+
+	testServer := httptest.NewServer(initRouter(h))
+	defer testServer.Close()
+
+	token := GetAuthorizationToken(t, testServer, &models.UserDTO{Login: u1.Login, Password: u1.PasswordHash})
+
+	var tests = []struct {
+		name   string
+		number string
+		jwt    string
+		code   string
+		status int
+	}{
+		{
+			name:   "Get order unauthorized",
+			number: own.Number,
+			status: http.StatusUnauthorized,
+			code:   codeUnauthorized,
+		},
+		{
+			name:   "Get order",
+			number: own.Number,
+			jwt:    token,
+			status: http.StatusOK,
+		},
+		{
+			name:   "Get order of another user",
+			number: other.Number,
+			jwt:    token,
+			status: http.StatusNotFound,
+			code:   codeOrderNotFound,
+		},
+		{
+			name:   "Get unknown order",
+			number: "79927398713",
+			jwt:    token,
+			status: http.StatusNotFound,
+			code:   codeOrderNotFound,
+		},
+		{
+			name:   "Get order with incorrect number",
+			number: "49927398717",
+			jwt:    token,
+			status: http.StatusUnprocessableEntity,
+			code:   codeOrderNumberInvalid,
+		},
+	}
+
+	for _, v := range tests {
+		v := v
+		t.Run(v.name, func(t *testing.T) {
+			resp, body := testRequest(t, testServer, http.MethodGet, "/api/user/orders/"+v.number, v.jwt, nil)
+
+			if v.status != http.StatusOK {
+				requireProblem(t, resp, body, v.status, v.code)
+				return
+			}
+			require.Equal(t, v.status, resp.StatusCode, string(body))
+
+			var d struct {
+				UserID  string                      `json:"userId"`
+				Number  string                      `json:"number"`
+				History []*models.OrderStatusChange `json:"history"`
+			}
+			require.NoError(t, json.Unmarshal(body, &d))
+			require.Empty(t, d.UserID)
+			require.Equal(t, v.number, d.Number)
+			require.Len(t, d.History, len(history))
+			require.Equal(t, models.OrderStatusProcessed, d.History[2].Status)
+			require.JSONEq(t, `{"status":"PROCESSED"}`, string(d.History[2].Response))
+		})
 	}
+}
+
+func TestHandlers_GetBalance(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := NewMockStorage(ctrl)
+	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+
+	var test = "test"
 
 	u1Dto := &models.UserDTO{
 		Login:    test,
@@ -562,14 +797,18 @@ func TestHandlers_GetBalance(t *testing.T) {
 	hc.CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
 
 	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
 
 	mr.GetUser(gomock.Any(), u1Dto).AnyTimes().Return(u1, nil)
-	mr.GetUser(gomock.Any(), u1Claims).AnyTimes().Return(u1, nil)
 
-	ub := models.UserBalance{Current: 99.9, Withdrawn: 999.1}
+	ub := models.UserBalance{Current: 9990, Withdrawn: 99910}
 	mr.GetBalance(gomock.Any(), u1.ID).AnyTimes().Return(&ub, nil)
 
-	h, err := NewHandlers([]byte("keyGetBalance"), db, zap.L().Sugar(), time.Hour*1, hashc)
+	h, err := NewHandlers(testConfig("keyGetBalance"), db, zap.L().Sugar(), hashc)
 	if err != nil {
 		t.Error(err)
 	}
@@ -656,14 +895,10 @@ func TestHandlers_AddBalanceWithdrawn(t *testing.T) {
 
 	db := NewMockStorage(ctrl)
 	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
 
 	var test = "test"
 
-	u1Claims := &models.UserDTO{
-		Login:    test,
-		Password: "",
-	}
-
 	u1Dto := &models.UserDTO{
 		Login:    test,
 		Password: test,
@@ -679,13 +914,20 @@ func TestHandlers_AddBalanceWithdrawn(t *testing.T) {
 	hc.CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
 
 	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
 	mr.GetUser(gomock.Any(), u1Dto).AnyTimes().Return(u1, nil)
-	mr.GetUser(gomock.Any(), u1Claims).AnyTimes().Return(u1, nil)
 
-	mr.AddWithdrawn(gomock.Any(), u1.ID, "1", 10.1).AnyTimes().Return(nil)
-	mr.AddWithdrawn(gomock.Any(), u1.ID, "2", 20.1).AnyTimes().Return(models.ErrNotEnoughAccruals)
+	mr.AddWithdrawn(gomock.Any(), u1.ID, "49927398716", models.Amount(1010)).AnyTimes().Return(nil)
+	mr.AddWithdrawn(gomock.Any(), u1.ID, "1234567812345670", models.Amount(2010)).AnyTimes().
+		Return(models.ErrNotEnoughAccruals)
+	mr.AddWithdrawn(gomock.Any(), u1.ID, "4026843483168683", models.Amount(1010)).AnyTimes().
+		Return(models.ErrWithdrawalWasRegisteredEarlier)
 
-	h, err := NewHandlers([]byte("keyAddBalanceWDN"), db, zap.L().Sugar(), time.Hour*1, hashc)
+	h, err := NewHandlers(testConfig("keyAddBalanceWDN"), db, zap.L().Sugar(), hashc)
 	if err != nil {
 		t.Error(err)
 	}
@@ -696,6 +938,7 @@ func TestHandlers_AddBalanceWithdrawn(t *testing.T) {
 
 	var tests = []struct {
 		authReq any
+		code    string
 		name    string
 		url     string
 		method  string
@@ -705,6 +948,7 @@ func TestHandlers_AddBalanceWithdrawn(t *testing.T) {
 	}{
 		{
 			name:    "AddBalanceWithdrawn unauthorized",
+			code:    codeUnauthorized,
 			url:     "/api/user/balance/withdraw",
 			status:  401,
 			method:  http.MethodPost,
@@ -716,18 +960,69 @@ func TestHandlers_AddBalanceWithdrawn(t *testing.T) {
 			status:  200,
 			method:  http.MethodPost,
 			authReq: &models.UserDTO{Login: test, Password: test},
-			order:   "1",
+			order:   "49927398716",
 			sum:     10.1,
 		},
 		{
 			name:    "AddBalanceWithdrawn not enough accruals",
+			code:    codeNotEnoughAccruals,
 			url:     "/api/user/balance/withdraw",
 			status:  402,
 			method:  http.MethodPost,
 			authReq: &models.UserDTO{Login: test, Password: test},
-			order:   "2",
+			order:   "1234567812345670",
 			sum:     20.1,
 		},
+		{
+			name:    "AddBalanceWithdrawn incorrect order number",
+			code:    codeOrderNumberInvalid,
+			url:     "/api/user/balance/withdraw",
+			status:  http.StatusUnprocessableEntity,
+			method:  http.MethodPost,
+			authReq: &models.UserDTO{Login: test, Password: test},
+			order:   "49927398717",
+			sum:     10.1,
+		},
+		{
+			name:    "AddBalanceWithdrawn empty order number",
+			code:    codeOrderNumberInvalid,
+			url:     "/api/user/balance/withdraw",
+			status:  http.StatusUnprocessableEntity,
+			method:  http.MethodPost,
+			authReq: &models.UserDTO{Login: test, Password: test},
+			order:   "",
+			sum:     10.1,
+		},
+		{
+			name:    "AddBalanceWithdrawn zero sum",
+			code:    codeWithdrawalSumInvalid,
+			url:     "/api/user/balance/withdraw",
+			status:  http.StatusBadRequest,
+			method:  http.MethodPost,
+			authReq: &models.UserDTO{Login: test, Password: test},
+			order:   "49927398716",
+			sum:     0,
+		},
+		{
+			name:    "AddBalanceWithdrawn negative sum",
+			code:    codeWithdrawalSumInvalid,
+			url:     "/api/user/balance/withdraw",
+			status:  http.StatusBadRequest,
+			method:  http.MethodPost,
+			authReq: &models.UserDTO{Login: test, Password: test},
+			order:   "49927398716",
+			sum:     -10.1,
+		},
+		{
+			name:    "AddBalanceWithdrawn same order twice",
+			code:    codeWithdrawalRegistered,
+			url:     "/api/user/balance/withdraw",
+			status:  http.StatusConflict,
+			method:  http.MethodPost,
+			authReq: &models.UserDTO{Login: test, Password: test},
+			order:   "4026843483168683",
+			sum:     10.1,
+		},
 	}
 
 	for _, v := range tests {
@@ -746,7 +1041,7 @@ func TestHandlers_AddBalanceWithdrawn(t *testing.T) {
 			t.Error(err)
 		}
 
-		resp, _ := testRequest(t,
+		resp, body := testRequest(t,
 			testServer,
 			v.method,
 			v.url,
@@ -760,6 +1055,10 @@ func TestHandlers_AddBalanceWithdrawn(t *testing.T) {
 		require.Equal(t, v.status, resp.StatusCode,
 			fmt.Sprintf("TestAddBalanceWithdrawn status code: %s URL: %s, want: %d, have: %d",
 				v.name, v.url, v.status, resp.StatusCode))
+
+		if v.code != "" {
+			requireProblem(t, resp, body, v.status, v.code)
+		}
 	}
 }
 
@@ -769,14 +1068,10 @@ func TestHandlers_GetBalanceMovementHistory(t *testing.T) {
 
 	db := NewMockStorage(ctrl)
 	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
 
 	var test = "test"
 
-	u1Claims := &models.UserDTO{
-		Login:    test,
-		Password: "",
-	}
-
 	u1Dto := &models.UserDTO{
 		Login:    test,
 		Password: test,
@@ -792,8 +1087,12 @@ func TestHandlers_GetBalanceMovementHistory(t *testing.T) {
 	hc.CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
 
 	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
 	mr.GetUser(gomock.Any(), u1Dto).AnyTimes().Return(u1, nil)
-	mr.GetUser(gomock.Any(), u1Claims).AnyTimes().Return(u1, nil)
 
 	currentTime := time.Now()
 
@@ -801,22 +1100,22 @@ func TestHandlers_GetBalanceMovementHistory(t *testing.T) {
 	h1 := &models.UserWithdrawalsHistory{
 		ProcessedAt: currentTime,
 		OrderNumber: "1",
-		Sum:         123.3,
+		Sum:         12330,
 	}
 	h2 := &models.UserWithdrawalsHistory{
 		ProcessedAt: currentTime.AddDate(0, -1, 0),
 		OrderNumber: "2",
-		Sum:         123.3,
+		Sum:         12330,
 	}
 	h3 := &models.UserWithdrawalsHistory{
 		ProcessedAt: currentTime.AddDate(0, -2, 0),
 		OrderNumber: "3",
-		Sum:         123.3,
+		Sum:         12330,
 	}
 	m = append(m, h1, h2, h3)
 	mr.GetWithdrawalList(gomock.Any(), u1.ID).AnyTimes().Return(m, nil)
 
-	h, err := NewHandlers([]byte("keyGetBalanceMovHistory"), db, zap.L().Sugar(), time.Hour*1, hashc)
+	h, err := NewHandlers(testConfig("keyGetBalanceMovHistory"), db, zap.L().Sugar(), hashc)
 	if err != nil {
 		t.Error(err)
 	}
@@ -888,6 +1187,18 @@ func TestHandlers_GetBalanceMovementHistory(t *testing.T) {
 	}
 }
 
+func testConfig(key string) config.Config {
+	return config.Config{
+		Key:               []byte(key),
+		TokenExp:          time.Hour,
+		RefreshTokenExp:   time.Hour,
+		IdempotencyTTL:    time.Hour,
+		PasswordMinLength: 8,
+		PasswordMaxLength: 128,
+		MaxRequestBody:    64 * 1024,
+	}
+}
+
 func GetAuthorizationToken(t *testing.T, ts *httptest.Server, authReq any) string {
 	t.Helper()
 
@@ -917,10 +1228,13 @@ func testRequest(t *testing.T, ts *httptest.Server,
 		t.Errorf("URL %s test request  error : %v", err, path)
 	}
 	req, err := http.NewRequest(method, r, body)
+	require.NoError(t, err)
 	if jwt != "" {
 		req.Header.Set(authHeaderName, jwt)
 	}
-	require.NoError(t, err)
+	if body != nil {
+		req.Header.Set(contentType, testContentType(path))
+	}
 
 	resp, err := ts.Client().Do(req)
 	require.NoError(t, err)
@@ -935,3 +1249,285 @@ func testRequest(t *testing.T, ts *httptest.Server,
 
 	return resp, respBody
 }
+
+// testContentType returns the media type a client sends to the path, only the order is uploaded as text.
+func testContentType(path string) string {
+	if path == "/api/user/orders" {
+		return contentTypeText
+	}
+	return contentTypeJSON
+}
+
+// testTokenStore keeps refresh tokens and revoked access tokens the same way the database keeps them.
+type testTokenStore struct {
+	mx      *sync.Mutex
+	tokens  map[string]*testRefreshToken
+	revoked map[string]bool
+	users   map[string]*models.User
+	resets  map[string]*models.PasswordResetToken
+}
+
+type testRefreshToken struct {
+	userID  string
+	family  string
+	used    bool
+	revoked bool
+}
+
+func newTestTokenStore(users ...*models.User) *testTokenStore {
+	ts := &testTokenStore{
+		mx:      &sync.Mutex{},
+		tokens:  make(map[string]*testRefreshToken),
+		revoked: make(map[string]bool),
+		users:   make(map[string]*models.User),
+		resets:  make(map[string]*models.PasswordResetToken),
+	}
+	for _, u := range users {
+		ts.users[u.ID] = u
+	}
+	return ts
+}
+
+func (ts *testTokenStore) expect(mr *MockStorageMockRecorder) {
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, t *models.RefreshToken) error {
+			ts.mx.Lock()
+			defer ts.mx.Unlock()
+
+			t.Family = t.Hash
+			ts.tokens[t.Hash] = &testRefreshToken{userID: t.UserID, family: t.Family}
+			return nil
+		})
+	mr.RotateRefreshToken(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, hash string, next *models.RefreshToken) (*models.User, error) {
+			ts.mx.Lock()
+			defer ts.mx.Unlock()
+
+			t, ok := ts.tokens[hash]
+			if !ok {
+				return nil, models.ErrRefreshTokenIsInvalid
+			}
+			if t.used {
+				ts.revokeFamily(t.family)
+				return nil, models.ErrRefreshTokenReused
+			}
+			if t.revoked {
+				return nil, models.ErrRefreshTokenIsInvalid
+			}
+
+			t.used = true
+			next.UserID = t.userID
+			next.Family = t.family
+			ts.tokens[next.Hash] = &testRefreshToken{userID: t.userID, family: t.family}
+			return ts.users[t.userID], nil
+		})
+	mr.RevokeRefreshToken(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, userID string, hash string) error {
+			ts.mx.Lock()
+			defer ts.mx.Unlock()
+
+			if t, ok := ts.tokens[hash]; ok && t.userID == userID {
+				ts.revokeFamily(t.family)
+			}
+			return nil
+		})
+	mr.RevokeAccessToken(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, jti string, _ time.Time) error {
+			ts.mx.Lock()
+			defer ts.mx.Unlock()
+
+			ts.revoked[jti] = true
+			return nil
+		})
+	mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, jti string, userID string, sessionGen int64) (bool, error) {
+			ts.mx.Lock()
+			defer ts.mx.Unlock()
+
+			if ts.revoked[jti] {
+				return true, nil
+			}
+			u, ok := ts.users[userID]
+			return ok && u.SessionGen > sessionGen, nil
+		})
+	mr.AccessTokenIDIsRevoked(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, jti string) (bool, error) {
+			ts.mx.Lock()
+			defer ts.mx.Unlock()
+
+			return ts.revoked[jti], nil
+		})
+}
+
+func (ts *testTokenStore) revokeFamily(family string) {
+	for _, t := range ts.tokens {
+		if t.family == family {
+			t.revoked = true
+		}
+	}
+}
+
+func TestHandlers_RefreshToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := NewMockStorage(ctrl)
+	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+
+	var test = "test"
+
+	u1 := &models.User{
+		ID:           "1",
+		Login:        test,
+		PasswordHash: test,
+	}
+
+	hashc.EXPECT().CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
+
+	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	newTestTokenStore(u1).expect(mr)
+	mr.GetUser(gomock.Any(), gomock.Any()).AnyTimes().Return(u1, nil)
+	mr.GetWithdrawalList(gomock.Any(), u1.ID).AnyTimes().Return(nil, nil)
+
+	h, err := NewHandlers(testConfig("keyRefreshToken"), db, zap.L().Sugar(), hashc)
+	if err != nil {
+		t.Error(err)
+	}
+	r := initRouter(h)
+
+	testServer := httptest.NewServer(r)
+	defer testServer.Close()
+
+	first := login(t, testServer, &models.UserDTO{Login: test, Password: test})
+
+	resp, body := refresh(t, testServer, first.RefreshToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "TestRefreshToken: rotation")
+
+	var second models.TokenPair
+	require.NoError(t, json.Unmarshal(body, &second))
+	require.NotEmpty(t, second.AccessToken)
+	require.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	require.Equal(t, second.AccessToken, resp.Header.Get(authHeaderName))
+
+	resp, _ = testRequest(t, testServer, http.MethodGet, "/api/user/withdrawals", second.AccessToken, nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode, "TestRefreshToken: refreshed access token")
+
+	resp, _ = refresh(t, testServer, first.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "TestRefreshToken: reuse of rotated token")
+
+	resp, _ = refresh(t, testServer, second.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode,
+		"TestRefreshToken: the family must be revoked after the reuse")
+
+	resp, _ = refresh(t, testServer, "unknown")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "TestRefreshToken: unknown token")
+
+	resp, _ = testRequest(t, testServer, http.MethodPost, "/api/user/token/refresh", "", nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, "TestRefreshToken: empty body")
+
+	third := login(t, testServer, &models.UserDTO{Login: test, Password: test})
+	resp, _ = refresh(t, testServer, third.RefreshToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "TestRefreshToken: other sessions are not revoked")
+}
+
+func TestHandlers_Logout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := NewMockStorage(ctrl)
+	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+
+	var test = "test"
+
+	u1 := &models.User{
+		ID:           "1",
+		Login:        test,
+		PasswordHash: test,
+	}
+
+	hashc.EXPECT().CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
+
+	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	newTestTokenStore(u1).expect(mr)
+	mr.GetUser(gomock.Any(), gomock.Any()).AnyTimes().Return(u1, nil)
+	mr.GetWithdrawalList(gomock.Any(), u1.ID).AnyTimes().Return(nil, nil)
+
+	h, err := NewHandlers(testConfig("keyLogout"), db, zap.L().Sugar(), hashc)
+	if err != nil {
+		t.Error(err)
+	}
+	r := initRouter(h)
+
+	testServer := httptest.NewServer(r)
+	defer testServer.Close()
+
+	tp := login(t, testServer, &models.UserDTO{Login: test, Password: test})
+	other := login(t, testServer, &models.UserDTO{Login: test, Password: test})
+
+	resp, _ := testRequest(t, testServer, http.MethodPost, "/api/user/logout", "", nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "TestLogout: unauthorized")
+
+	b, err := json.Marshal(map[string]string{"refresh_token": tp.RefreshToken})
+	require.NoError(t, err)
+
+	resp, _ = testRequest(t, testServer, http.MethodPost, "/api/user/logout", tp.AccessToken, bytes.NewBuffer(b))
+	require.Equal(t, http.StatusOK, resp.StatusCode, "TestLogout: logout")
+
+	resp, _ = testRequest(t, testServer, http.MethodGet, "/api/user/withdrawals", tp.AccessToken, nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "TestLogout: revoked access token")
+
+	resp, _ = refresh(t, testServer, tp.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "TestLogout: revoked refresh token")
+
+	resp, _ = testRequest(t, testServer, http.MethodGet, "/api/user/withdrawals", other.AccessToken, nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode, "TestLogout: other session")
+
+	resp, _ = refresh(t, testServer, other.RefreshToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "TestLogout: other session refresh")
+}
+
+func login(t *testing.T, ts *httptest.Server, authReq any) *models.TokenPair {
+	t.Helper()
+
+	b, err := json.Marshal(authReq)
+	require.NoError(t, err)
+
+	resp, body := testRequest(t, ts, http.MethodPost, "/api/user/login", "", bytes.NewBuffer(b))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var tp models.TokenPair
+	require.NoError(t, json.Unmarshal(body, &tp))
+	require.Equal(t, tp.AccessToken, resp.Header.Get(authHeaderName))
+
+	return &tp
+}
+
+func refresh(t *testing.T, ts *httptest.Server, token string) (*http.Response, []byte) {
+	t.Helper()
+
+	b, err := json.Marshal(map[string]string{"refresh_token": token})
+	require.NoError(t, err)
+
+	return testRequest(t, ts, http.MethodPost, "/api/user/token/refresh", "", bytes.NewBuffer(b))
+}
+
+func requireProblem(t *testing.T, resp *http.Response, body []byte, status int, code string) {
+	t.Helper()
+
+	require.Equal(t, contentTypeProblemJSON, resp.Header.Get(contentType))
+
+	var p Problem
+	require.NoError(t, json.Unmarshal(body, &p), string(body))
+	require.Equal(t, "about:blank", p.Type)
+	require.Equal(t, http.StatusText(status), p.Title)
+	require.Equal(t, status, p.Status)
+	require.Equal(t, code, p.Code)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/ArtemShalinFe/gophermart/internal/adapters"
+)
+
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+)
+
+type AccrualState interface {
+	BreakerState() string
+}
+
+type health struct {
+	Status  string `json:"status"`
+	Accrual string `json:"accrual,omitempty"`
+}
+
+// GetHealth reports the service as degraded while the circuit to the accrual system is open.
+// Orders are still accepted then, so the response is always 200.
+func (h *Handlers) GetHealth(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	hl := health{Status: healthOK}
+	if h.accrual != nil {
+		hl.Accrual = h.accrual.BreakerState()
+		if hl.Accrual == adapters.BreakerOpen {
+			hl.Status = healthDegraded
+		}
+	}
+
+	b, err := json.Marshal(&hl)
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("GetHealth marshal to json err: %v", err)
+		return
+	}
+
+	w.Header().Set(contentType, contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+
+	if _, err = w.Write(b); err != nil {
+		h.log.Errorf("GetHealth error: %v", err)
+		return
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/gophermart/internal/adapters"
+)
+
+type testAccrualState string
+
+func (s testAccrualState) BreakerState() string {
+	return string(s)
+}
+
+func TestHandlers_GetHealth(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	h, err := NewHandlers(testConfig("keyHealth"), NewMockStorage(ctrl), zap.L().Sugar(), NewMockHashController(ctrl))
+	require.NoError(t, err)
+
+	testServer := httptest.NewServer(initRouter(h))
+	defer testServer.Close()
+
+	tests := []struct {
+		accrual AccrualState
+		want    health
+		name    string
+	}{
+		{
+			name: "without accrual",
+			want: health{Status: healthOK},
+		},
+		{
+			name:    "circuit closed",
+			accrual: testAccrualState(adapters.BreakerClosed),
+			want:    health{Status: healthOK, Accrual: adapters.BreakerClosed},
+		},
+		{
+			name:    "circuit half-open",
+			accrual: testAccrualState(adapters.BreakerHalfOpen),
+			want:    health{Status: healthOK, Accrual: adapters.BreakerHalfOpen},
+		},
+		{
+			name:    "circuit open",
+			accrual: testAccrualState(adapters.BreakerOpen),
+			want:    health{Status: healthDegraded, Accrual: adapters.BreakerOpen},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.accrual = tt.accrual
+
+			resp, body := testRequest(t, testServer, http.MethodGet, "/api/health", "", nil)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, contentTypeJSON, resp.Header.Get(contentType))
+
+			var got health
+			require.NoError(t, json.Unmarshal(body, &got))
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+const idempotentReplayedHeader = "Idempotent-Replayed"
+const maxIdempotencyKeyLen = 255
+
+// idempotencyStaleTimeout is longer than any request can take, so the key is never taken over from a running one.
+const idempotencyStaleTimeout = requestTimeout + 30*time.Second
+
+// IdempotencyMiddleware replays the saved response when a request is repeated with the same Idempotency-Key.
+func (h *Handlers) IdempotencyMiddleware(hr http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			hr.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLen {
+			h.writeError(w, http.StatusBadRequest, codeIdempotencyKeyTooLong,
+				fmt.Sprintf("%s must be at most %d characters long", idempotencyKeyHeader, maxIdempotencyKeyLen))
+			return
+		}
+
+		u, ok := userFromContext(r.Context())
+		if !ok {
+			h.writeError(w, http.StatusBadRequest, codeUserUndefined, errUserUndefined)
+			h.log.Errorf(errUserUndefined)
+			return
+		}
+
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			h.writeInternalError(w)
+			h.log.Errorf("failed to read the idempotent request body err: %v", err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(b))
+
+		k := &models.IdempotencyKey{
+			Key:          key,
+			UserID:       u.ID,
+			Method:       r.Method,
+			Path:         r.URL.Path,
+			RequestHash:  requestHash(r, b),
+			ExpiresAt:    time.Now().Add(h.idempotencyTTL),
+			StaleTimeout: idempotencyStaleTimeout,
+		}
+
+		saved, started, err := k.Start(r.Context(), h.store)
+		if err != nil {
+			h.writeInternalError(w)
+			h.log.Errorf("failed to start idempotent request err: %v", err)
+			return
+		}
+
+		if !started {
+			if saved.RequestHash != k.RequestHash {
+				h.writeError(w, http.StatusConflict, codeIdempotencyKeyReused,
+					fmt.Sprintf("%s was used for another request", idempotencyKeyHeader))
+				return
+			}
+
+			if saved.Status == 0 {
+				h.writeError(w, http.StatusConflict, codeIdempotencyInProgress,
+					"the request with the same idempotency key is still in progress")
+				return
+			}
+
+			if saved.ContentType != "" {
+				w.Header().Set(contentType, saved.ContentType)
+			}
+			w.Header().Set(idempotentReplayedHeader, "true")
+			w.WriteHeader(saved.Status)
+			if _, err := w.Write(saved.Body); err != nil {
+				h.log.Errorf("failed to replay idempotent response err: %v", err)
+			}
+			return
+		}
+
+		// The key of the request that panicked is released, so that the client doesn't wait for the stale timeout.
+		defer func() {
+			if p := recover(); p != nil {
+				if err := k.Cancel(r.Context(), h.store); err != nil {
+					h.log.Errorf("failed to cancel idempotent request err: %v", err)
+				}
+				panic(p)
+			}
+		}()
+
+		rw := newResponseRecorder(w)
+		hr.ServeHTTP(rw, r)
+
+		// Server errors are not saved, so that the client can retry the request with the same key.
+		if rw.status >= http.StatusInternalServerError {
+			if err := k.Cancel(r.Context(), h.store); err != nil {
+				h.log.Errorf("failed to cancel idempotent request err: %v", err)
+			}
+			return
+		}
+
+		k.Status = rw.status
+		k.ContentType = rw.Header().Get(contentType)
+		k.Body = rw.body.Bytes()
+		if err := k.Finish(r.Context(), h.store); err != nil {
+			h.log.Errorf("failed to finish idempotent request err: %v", err)
+		}
+	})
+}
+
+func requestHash(r *http.Request, body []byte) string {
+	hs := sha256.New()
+	hs.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	hs.Write(body)
+	return hex.EncodeToString(hs.Sum(nil))
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	body   *bytes.Buffer
+	status int
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{
+		ResponseWriter: w,
+		body:           &bytes.Buffer{},
+		status:         http.StatusOK,
+	}
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+
+	size, err := r.ResponseWriter.Write(b)
+	if err != nil {
+		return 0, fmt.Errorf("failed response write err: %w", err)
+	}
+	return size, nil
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	r.ResponseWriter.WriteHeader(statusCode)
+	r.status = statusCode
+}
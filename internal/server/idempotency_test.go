@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestHandlers_IdempotencyMiddleware(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := NewMockStorage(ctrl)
+	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+
+	var test = "test"
+
+	u1 := &models.User{
+		ID:           "1",
+		Login:        test,
+		PasswordHash: test,
+	}
+
+	hashc.EXPECT().CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
+
+	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
+	mr.GetUser(gomock.Any(), gomock.Any()).AnyTimes().Return(u1, nil)
+
+	mr.AddWithdrawn(gomock.Any(), u1.ID, "49927398716", models.Amount(1010)).Times(1).Return(nil)
+	mr.AddWithdrawn(gomock.Any(), u1.ID, "1234567812345670", models.Amount(1010)).Times(1).
+		Return(models.ErrNotEnoughAccruals)
+	mr.AddWithdrawn(gomock.Any(), u1.ID, "4026843483168683", models.Amount(1010)).Times(2).
+		Return(fmt.Errorf("failed connect to db"))
+
+	// The keys are kept the same way the database keeps them.
+	mx := &sync.Mutex{}
+	keys := make(map[string]models.IdempotencyKey)
+
+	mr.StartIdempotentRequest(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, k *models.IdempotencyKey) (*models.IdempotencyKey, bool, error) {
+			mx.Lock()
+			defer mx.Unlock()
+
+			if saved, ok := keys[k.Key]; ok {
+				return &saved, false, nil
+			}
+			keys[k.Key] = *k
+			return k, true, nil
+		})
+	mr.FinishIdempotentRequest(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, k *models.IdempotencyKey) error {
+			mx.Lock()
+			defer mx.Unlock()
+
+			keys[k.Key] = *k
+			return nil
+		})
+	mr.CancelIdempotentRequest(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, k *models.IdempotencyKey) error {
+			mx.Lock()
+			defer mx.Unlock()
+
+			delete(keys, k.Key)
+			return nil
+		})
+
+	keys["in-progress"] = models.IdempotencyKey{Key: "in-progress", UserID: u1.ID}
+
+	h, err := NewHandlers(testConfig("keyIdempotency"), db, zap.L().Sugar(), hashc)
+	if err != nil {
+		t.Error(err)
+	}
+	r := initRouter(h)
+
+	testServer := httptest.NewServer(r)
+	defer testServer.Close()
+
+	jwt := GetAuthorizationToken(t, testServer, &models.UserDTO{Login: test, Password: test})
+
+	var tests = []struct {
+		name         string
+		key          string
+		body         string
+		status       int
+		wantReplayed bool
+	}{
+		{
+			name:   "first withdrawal",
+			key:    "k1",
+			body:   `{"order": "49927398716", "sum": 10.1}`,
+			status: http.StatusOK,
+		},
+		{
+			name:         "repeated withdrawal",
+			key:          "k1",
+			body:         `{"order": "49927398716", "sum": 10.1}`,
+			status:       http.StatusOK,
+			wantReplayed: true,
+		},
+		{
+			name:   "same key with another body",
+			key:    "k1",
+			body:   `{"order": "49927398716", "sum": 20.2}`,
+			status: http.StatusConflict,
+		},
+		{
+			name:   "rejected withdrawal",
+			key:    "k2",
+			body:   `{"order": "1234567812345670", "sum": 10.1}`,
+			status: http.StatusPaymentRequired,
+		},
+		{
+			name:         "repeated rejected withdrawal",
+			key:          "k2",
+			body:         `{"order": "1234567812345670", "sum": 10.1}`,
+			status:       http.StatusPaymentRequired,
+			wantReplayed: true,
+		},
+		{
+			name:   "failed withdrawal",
+			key:    "k3",
+			body:   `{"order": "4026843483168683", "sum": 10.1}`,
+			status: http.StatusInternalServerError,
+		},
+		{
+			name:   "failed withdrawal is executed again",
+			key:    "k3",
+			body:   `{"order": "4026843483168683", "sum": 10.1}`,
+			status: http.StatusInternalServerError,
+		},
+		{
+			name:   "request in progress",
+			key:    "in-progress",
+			body:   `{"order": "49927398716", "sum": 10.1}`,
+			status: http.StatusConflict,
+		},
+	}
+
+	for _, v := range tests {
+		v := v
+
+		u, err := url.JoinPath(testServer.URL, "/api/user/balance/withdraw")
+		require.NoError(t, err)
+
+		req, err := http.NewRequest(http.MethodPost, u, bytes.NewBufferString(v.body))
+		require.NoError(t, err)
+		req.Header.Set(authHeaderName, jwt)
+		req.Header.Set(contentType, contentTypeJSON)
+		req.Header.Set(idempotencyKeyHeader, v.key)
+
+		resp, err := testServer.Client().Do(req)
+		require.NoError(t, err)
+
+		_, err = io.Copy(io.Discard, resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		require.Equal(t, v.status, resp.StatusCode,
+			fmt.Sprintf("TestIdempotencyMiddleware: %s, want: %d, have: %d", v.name, v.status, resp.StatusCode))
+		require.Equal(t, v.wantReplayed, resp.Header.Get(idempotentReplayedHeader) == "true",
+			fmt.Sprintf("TestIdempotencyMiddleware replayed: %s", v.name))
+	}
+}
+
+func TestHandlers_IdempotencyMiddlewarePanic(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := NewMockStorage(ctrl)
+	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+
+	mr := db.EXPECT()
+	mr.StartIdempotentRequest(gomock.Any(), gomock.Any()).Times(1).DoAndReturn(
+		func(_ context.Context, k *models.IdempotencyKey) (*models.IdempotencyKey, bool, error) {
+			// The key is not taken over while the server could still be running the request.
+			require.Greater(t, k.StaleTimeout, writeTimeout)
+			return k, true, nil
+		})
+	mr.CancelIdempotentRequest(gomock.Any(), gomock.Any()).Times(1).Return(nil)
+
+	h, err := NewHandlers(testConfig("keyIdempotencyPanic"), db, zap.L().Sugar(), hashc)
+	require.NoError(t, err)
+
+	hr := h.IdempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("withdrawal panicked")
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", bytes.NewBufferString(`{}`))
+	req.Header.Set(idempotencyKeyHeader, "k1")
+	req = req.WithContext(context.WithValue(req.Context(), userKey, &models.User{ID: "1"}))
+
+	// The panic goes on to the recoverer, the key is released on the way.
+	require.PanicsWithValue(t, "withdrawal panicked", func() {
+		hr.ServeHTTP(httptest.NewRecorder(), req)
+	})
+}
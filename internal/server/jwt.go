@@ -2,6 +2,9 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -9,106 +12,152 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 
 	"github.com/ArtemShalinFe/gophermart/internal/models"
+	"github.com/ArtemShalinFe/gophermart/internal/security"
 )
 
+// Claims identifies the user by the standard sub claim, so the login can be changed without reissuing tokens.
 type Claims struct {
 	jwt.RegisteredClaims
-	Login string
+	SessionGen int64 `json:"sgen,omitempty"`
 }
 
-func NewJWTToken(secretKey []byte, login string, tokenExp time.Duration) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+const (
+	jwtIssuer   = "gophermart"
+	jwtAudience = "gophermart-api"
+	jtiLen      = 16
+)
+
+var errTokenIsInvalid = errors.New("token is invalid")
+
+func NewJWTToken(keys *security.KeySet, userID string, sessionGen int64, tokenExp time.Duration) (string, error) {
+	jti, err := newJTI()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	tokenString, err := keys.Sign(Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenExp)),
+			ID:        jti,
+			Subject:   userID,
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{jwtAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(tokenExp)),
 		},
-		Login: login,
+		SessionGen: sessionGen,
 	})
-
-	tokenString, err := token.SignedString(secretKey)
 	if err != nil {
-		return "", fmt.Errorf("get token signed string err: %w", err)
+		return "", fmt.Errorf("sign token err: %w", err)
 	}
 
 	return tokenString, nil
 }
 
-func isAuthorized(tokenString string, secretKey []byte) (bool, error) {
+func newJTI() (string, error) {
+	b := make([]byte, jtiLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate jti err: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// parseJWTToken checks the signature, the expiration and the registered claims of the token.
+func parseJWTToken(tokenString string, keys *security.KeySet) (*Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims,
-		func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-			}
-			return secretKey, nil
-		})
+	token, err := jwt.ParseWithClaims(tokenString, claims, keys.Keyfunc)
 
 	if err != nil {
-		return false, fmt.Errorf("token parse err: %w", err)
+		return nil, fmt.Errorf("token parse err: %w", err)
 	}
 
 	if !token.Valid {
-		return false, fmt.Errorf("token is invalid: %w", err)
+		return nil, errTokenIsInvalid
 	}
 
-	return true, nil
-}
-
-func (h *Handlers) getUserFromJWTToken(r *http.Request) (*models.User, error) {
-	authToken := r.Header.Get(authHeaderName)
+	if !claims.VerifyIssuer(jwtIssuer, true) {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", errTokenIsInvalid, claims.Issuer)
+	}
 
-	claims := &Claims{}
-	_, err := jwt.ParseWithClaims(authToken, claims, func(t *jwt.Token) (interface{}, error) {
-		return h.secretKey, nil
-	})
+	if !claims.VerifyAudience(jwtAudience, true) {
+		return nil, fmt.Errorf("%w: unexpected audience %v", errTokenIsInvalid, claims.Audience)
+	}
 
-	if err != nil {
-		return nil, fmt.Errorf("GetUserFromJWTToken error: %w", err)
+	if claims.IssuedAt == nil {
+		return nil, fmt.Errorf("%w: iat claim is missing", errTokenIsInvalid)
 	}
 
-	udto := models.UserDTO{
-		Login: claims.Login,
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: sub claim is missing", errTokenIsInvalid)
 	}
 
-	u, err := udto.GetUser(r.Context(), h.store)
-	if err != nil {
-		return nil, fmt.Errorf("get user from jwt token was failed err: %w", err)
+	if claims.ID == "" {
+		return nil, fmt.Errorf("%w: jti claim is missing", errTokenIsInvalid)
 	}
-	return u, nil
+
+	return claims, nil
 }
 
 type key int
 
-var userKey key
+const (
+	userKey key = iota
+	claimsKey
+)
 
 func userFromContext(ctx context.Context) (*models.User, bool) {
 	u, ok := ctx.Value(userKey).(*models.User)
 	return u, ok
 }
 
+func claimsFromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey).(*Claims)
+	return c, ok
+}
+
 func (h *Handlers) JwtMiddleware(hr http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authToken := r.Header.Get(authHeaderName)
-
-		authorized, err := isAuthorized(authToken, h.secretKey)
+		claims, err := parseJWTToken(r.Header.Get(authHeaderName), h.keys)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+			h.writeError(w, http.StatusUnauthorized, codeUnauthorized, "access token is missing or invalid")
 			return
 		}
 
-		if !authorized {
-			w.WriteHeader(http.StatusUnauthorized)
+		u := &models.User{ID: claims.Subject}
+		var revoked bool
+		if h.users != nil {
+			// The full user record is loaded only when the user cache is enabled.
+			u, err = h.users.Get(r.Context(), h.store, claims.Subject)
+			if err != nil {
+				if errors.Is(err, models.ErrUnknowUser) {
+					h.writeError(w, http.StatusUnauthorized, codeUnauthorized, "user not found")
+					return
+				}
+				h.writeInternalError(w)
+				h.log.Errorf("failed to get user from JWT in JwtMiddleware err: %v", err)
+				return
+			}
+			revoked = claims.SessionGen < u.SessionGen
+			if !revoked {
+				revoked, err = models.AccessTokenIDIsRevoked(r.Context(), h.store, claims.ID)
+			}
+		} else {
+			revoked, err = models.AccessTokenIsRevoked(r.Context(), h.store, claims.ID, claims.Subject, claims.SessionGen)
+		}
+		if err != nil {
+			h.writeInternalError(w)
+			h.log.Errorf("failed to check JWT revocation in JwtMiddleware err: %v", err)
 			return
 		}
 
-		u, err := h.getUserFromJWTToken(r)
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			h.log.Infof("failed to get user from JWT in JwtMiddleware err: %w ", err)
+		if revoked {
+			h.writeError(w, http.StatusUnauthorized, codeUnauthorized, "access token is revoked")
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), userKey, u)
+		ctx = context.WithValue(ctx, claimsKey, claims)
 		hr.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -1,9 +1,26 @@
 package server
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+	"github.com/ArtemShalinFe/gophermart/internal/security"
 )
 
 func TestNewJWTToken(t *testing.T) {
@@ -44,7 +61,7 @@ func TestNewJWTToken(t *testing.T) {
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := NewJWTToken(tt.args.secretKey, tt.args.login, time.Hour*1)
+			got, err := NewJWTToken(security.NewHMACKeySet(tt.args.secretKey), tt.args.login, 0, time.Hour*1)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewJWTToken() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -56,10 +73,9 @@ func TestNewJWTToken(t *testing.T) {
 	}
 }
 
-func TestIsAuthorized(t *testing.T) {
+func TestParseJWTToken(t *testing.T) {
 	type args struct {
-		login     string
-		password  string
+		userID    string
 		secretKey []byte
 		tokenExp  time.Duration
 	}
@@ -71,62 +87,53 @@ func TestIsAuthorized(t *testing.T) {
 		name      string
 		args      args
 		needSleep bool
-		want      bool
 		wantErr   bool
 	}{
 		{
 			name: "Positive case",
 			args: args{
 				secretKey: key,
-				login:     "test",
-				password:  "test",
+				userID:    "1",
 				tokenExp:  time.Hour * 1,
 			},
 			needSleep: false,
-			want:      true,
 			wantErr:   false,
 		},
 		{
 			name: "Negative case",
 			args: args{
 				secretKey: brokenkey,
-				login:     "test",
-				password:  "test",
+				userID:    "1",
 				tokenExp:  time.Hour * 1,
 			},
 			needSleep: false,
-			want:      false,
 			wantErr:   true,
 		},
 		{
 			name: "Negative case #2",
 			args: args{
-				secretKey: brokenkey,
-				login:     "test1",
-				password:  "test",
+				secretKey: key,
+				userID:    "",
 				tokenExp:  time.Hour * 1,
 			},
 			needSleep: false,
-			want:      false,
 			wantErr:   true,
 		},
 		{
 			name: "Expiration case",
 			args: args{
-				secretKey: brokenkey,
-				login:     "test",
-				password:  "test",
+				secretKey: key,
+				userID:    "1",
 				tokenExp:  time.Millisecond * 1,
 			},
 			needSleep: true,
-			want:      false,
 			wantErr:   true,
 		},
 	}
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
-			tokenString, err := NewJWTToken(key, tt.args.login, tt.args.tokenExp)
+			tokenString, err := NewJWTToken(security.NewHMACKeySet(key), tt.args.userID, 0, tt.args.tokenExp)
 			if err != nil {
 				t.Error(err)
 			}
@@ -135,14 +142,234 @@ func TestIsAuthorized(t *testing.T) {
 				time.Sleep(time.Second * 1)
 			}
 
-			got, err := isAuthorized(tokenString, tt.args.secretKey)
+			claims, err := parseJWTToken(tokenString, security.NewHMACKeySet(tt.args.secretKey))
 			if (err != nil) != tt.wantErr {
-				t.Errorf("IsAuthorized() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("parseJWTToken() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if got != tt.want {
-				t.Errorf("IsAuthorized() = %v, want %v", got, tt.want)
+			if err == nil && claims.Subject != tt.args.userID {
+				t.Errorf("parseJWTToken() sub = %v, want %v", claims.Subject, tt.args.userID)
 			}
 		})
 	}
 }
+
+func TestParseJWTTokenRegisteredClaims(t *testing.T) {
+	key := []byte("TrueKey")
+	now := time.Now()
+
+	valid := func() jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			ID:        "jti",
+			Subject:   "1",
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{jwtAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		}
+	}
+
+	tests := []struct {
+		change  func(c *jwt.RegisteredClaims)
+		name    string
+		method  jwt.SigningMethod
+		wantErr bool
+	}{
+		{
+			name:    "valid",
+			change:  func(c *jwt.RegisteredClaims) {},
+			method:  jwt.SigningMethodHS256,
+			wantErr: false,
+		},
+		{
+			name:    "another issuer",
+			change:  func(c *jwt.RegisteredClaims) { c.Issuer = "another" },
+			method:  jwt.SigningMethodHS256,
+			wantErr: true,
+		},
+		{
+			name:    "another audience",
+			change:  func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"another"} },
+			method:  jwt.SigningMethodHS256,
+			wantErr: true,
+		},
+		{
+			name:    "without iat",
+			change:  func(c *jwt.RegisteredClaims) { c.IssuedAt = nil },
+			method:  jwt.SigningMethodHS256,
+			wantErr: true,
+		},
+		{
+			name:    "issued in the future",
+			change:  func(c *jwt.RegisteredClaims) { c.IssuedAt = jwt.NewNumericDate(now.Add(time.Hour)) },
+			method:  jwt.SigningMethodHS256,
+			wantErr: true,
+		},
+		{
+			name:    "without jti",
+			change:  func(c *jwt.RegisteredClaims) { c.ID = "" },
+			method:  jwt.SigningMethodHS256,
+			wantErr: true,
+		},
+		{
+			name:    "none algorithm",
+			change:  func(c *jwt.RegisteredClaims) {},
+			method:  jwt.SigningMethodNone,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.change(&c)
+
+			var signKey any = key
+			if tt.method == jwt.SigningMethodNone {
+				signKey = jwt.UnsafeAllowNoneSignatureType
+			}
+
+			tokenString, err := jwt.NewWithClaims(tt.method, Claims{RegisteredClaims: c}).SignedString(signKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = parseJWTToken(tokenString, security.NewHMACKeySet(key))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseJWTToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJwtMiddleware_UserLookup(t *testing.T) {
+	tests := []struct {
+		name          string
+		userCacheSize int
+		lookups       int
+		// The session generation is checked against the cached user, the storage only checks the jti then.
+		sessionChecks int
+		jtiChecks     int
+	}{
+		{
+			name:          "without user cache",
+			userCacheSize: 0,
+			lookups:       0,
+			sessionChecks: 2,
+		},
+		{
+			name:          "with user cache",
+			userCacheSize: 10,
+			lookups:       1,
+			jtiChecks:     2,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := NewMockStorage(ctrl)
+			hashc := NewMockHashController(ctrl)
+			hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+
+			u1 := &models.User{ID: "1", Login: "test"}
+
+			mr := db.EXPECT()
+			mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), u1.ID, int64(0)).Times(tt.sessionChecks).Return(false, nil)
+			mr.AccessTokenIDIsRevoked(gomock.Any(), gomock.Any()).Times(tt.jtiChecks).Return(false, nil)
+			mr.GetUserByID(gomock.Any(), u1.ID).Times(tt.lookups).Return(u1, nil)
+			mr.GetWithdrawalList(gomock.Any(), u1.ID).Times(2).Return(nil, nil)
+
+			cfg := testConfig("keyUserLookup")
+			cfg.UserCacheSize = tt.userCacheSize
+			h, err := NewHandlers(cfg, db, zap.L().Sugar(), hashc)
+			if err != nil {
+				t.Error(err)
+			}
+
+			testServer := httptest.NewServer(initRouter(h))
+			defer testServer.Close()
+
+			token, err := NewJWTToken(h.keys, u1.ID, 0, time.Hour)
+			require.NoError(t, err)
+
+			for i := 0; i < 2; i++ {
+				resp, _ := testRequest(t, testServer, http.MethodGet, "/api/user/withdrawals", token, nil)
+				require.Equal(t, http.StatusNoContent, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHandlers_JWKSAndKeyRotation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := NewMockStorage(ctrl)
+	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+
+	u1 := &models.User{ID: "1", Login: "test"}
+
+	mr := db.EXPECT()
+	mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
+	mr.GetWithdrawalList(gomock.Any(), u1.ID).AnyTimes().Return(nil, nil)
+
+	dir := t.TempDir()
+	writeTestEd25519Key(t, dir, "2023")
+
+	cfg := testConfig("keyJWKS")
+	cfg.JWTKeysDir = dir
+	before, err := NewHandlers(cfg, db, zap.L().Sugar(), hashc)
+	require.NoError(t, err)
+
+	oldToken, err := NewJWTToken(before.keys, u1.ID, 0, time.Hour)
+	require.NoError(t, err)
+
+	writeTestEd25519Key(t, dir, "2024")
+	cfg.JWTSigningKeyID = "2024"
+	h, err := NewHandlers(cfg, db, zap.L().Sugar(), hashc)
+	require.NoError(t, err)
+
+	newToken, err := NewJWTToken(h.keys, u1.ID, 0, time.Hour)
+	require.NoError(t, err)
+
+	testServer := httptest.NewServer(initRouter(h))
+	defer testServer.Close()
+
+	resp, body := testRequest(t, testServer, http.MethodGet, "/.well-known/jwks.json", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, contentTypeJSON, resp.Header.Get(contentType))
+
+	var jwks security.JWKS
+	require.NoError(t, json.Unmarshal(body, &jwks))
+	require.Len(t, jwks.Keys, 2)
+	require.Equal(t, "2023", jwks.Keys[0].Kid)
+	require.Equal(t, "2024", jwks.Keys[1].Kid)
+
+	for name, token := range map[string]string{"retired key": oldToken, "active key": newToken} {
+		resp, _ = testRequest(t, testServer, http.MethodGet, "/api/user/withdrawals", token, nil)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode, name)
+	}
+
+	hmacToken, err := NewJWTToken(security.NewHMACKeySet(cfg.Key), u1.ID, 0, time.Hour)
+	require.NoError(t, err)
+
+	resp, _ = testRequest(t, testServer, http.MethodGet, "/api/user/withdrawals", hmacToken, nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "the secret key is not trusted when key files are set")
+}
+
+func writeTestEd25519Key(t *testing.T, dir string, kid string) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, kid+".pem"), pemKey, 0o600))
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rateLimiter is shared by all accrual workers. It spaces requests by a fixed
+// interval and lets any worker pause everybody when the accrual service asks to slow down.
+type rateLimiter struct {
+	next     time.Time
+	mu       sync.Mutex
+	interval time.Duration
+}
+
+func newRateLimiter(rps int) *rateLimiter {
+	l := &rateLimiter{}
+	if rps > 0 {
+		l.interval = time.Second / time.Duration(rps)
+	}
+	return l
+}
+
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	slot := l.next
+	if now := time.Now(); slot.Before(now) {
+		slot = now
+	}
+	l.next = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	d := time.Until(slot)
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("rate limiter wait err: %w", ctx.Err())
+	case <-t.C:
+		return nil
+	}
+}
+
+func (l *rateLimiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(l.next) {
+		l.next = until
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	tests := []struct {
+		name    string
+		rps     int
+		pause   time.Duration
+		calls   int
+		wantMin time.Duration
+	}{
+		{
+			name:    "unlimited",
+			rps:     0,
+			calls:   10,
+			wantMin: 0,
+		},
+		{
+			name:    "limited",
+			rps:     20,
+			calls:   5,
+			wantMin: 4 * 50 * time.Millisecond,
+		},
+		{
+			name:    "paused",
+			rps:     0,
+			pause:   200 * time.Millisecond,
+			calls:   2,
+			wantMin: 200 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(tt.rps)
+			l.Pause(tt.pause)
+
+			start := time.Now()
+			for i := 0; i < tt.calls; i++ {
+				require.NoError(t, l.Wait(context.Background()))
+			}
+
+			require.GreaterOrEqual(t, time.Since(start), tt.wantMin)
+		})
+	}
+}
+
+func TestRateLimiter_WaitCanceled(t *testing.T) {
+	l := newRateLimiter(0)
+	l.Pause(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	require.Error(t, l.Wait(ctx))
+}
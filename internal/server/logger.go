@@ -1,9 +1,7 @@
 package server
 
 import (
-	"bytes"
 	"fmt"
-	"io"
 	"net/http"
 	"time"
 )
@@ -12,23 +10,13 @@ func (h *Handlers) RequestLogger(hr http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rw := NewResponseLoggerWriter(w)
 
-		var buf bytes.Buffer
-
-		tee := io.TeeReader(r.Body, &buf)
-		body, err := io.ReadAll(tee)
-		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			h.log.Errorf("request logger read body err: %w", err)
-			return
-		}
-		r.Body = io.NopCloser(&buf)
-
+		// The body is not logged, it contains passwords and tokens.
 		start := time.Now()
 		hr.ServeHTTP(rw, r)
 		duration := time.Since(start)
 
-		h.log.Infof("HTTP request method: %s, url: %s, body: %s, duration: %s, statusCode: %d, responseSize: %d",
-			r.Method, r.RequestURI, string(body), duration, rw.responseData.status, rw.responseData.size,
+		h.log.Infof("HTTP request method: %s, url: %s, bodySize: %d, duration: %s, statusCode: %d, responseSize: %d",
+			r.Method, r.RequestURI, r.ContentLength, duration, rw.responseData.status, rw.responseData.size,
 		)
 	})
 }
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+const (
+	loginLockoutBaseDelay = time.Second
+	loginFailureWindow    = 24 * time.Hour
+	adminKeyHeader        = "X-Admin-Key"
+	retryAfterHeader      = "Retry-After"
+	forwardedForHeader    = "X-Forwarded-For"
+	realIPHeader          = "X-Real-IP"
+)
+
+func (h *Handlers) loginAttemptKeys(r *http.Request, login string) (models.LoginAttemptKey, models.LoginAttemptKey) {
+	return models.LoginAttemptKey{Kind: models.LoginAttemptLogin, Value: login},
+		models.LoginAttemptKey{Kind: models.LoginAttemptIP, Value: h.clientIP(r)}
+}
+
+// clientIP returns the address of the connection. The forwarded headers are read only when the connection
+// comes from a trusted proxy, the other clients could change them on every attempt.
+// The client is the first address of X-Forwarded-For from the right that is not a trusted proxy.
+func (h *Handlers) clientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !h.trustedProxy(remote) {
+		return remote
+	}
+
+	if fwd := r.Header.Values(forwardedForHeader); len(fwd) > 0 {
+		hops := strings.Split(strings.Join(fwd, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			remote = ip.String()
+			if !h.trustedProxy(remote) {
+				break
+			}
+		}
+		return remote
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get(realIPHeader))); ip != nil {
+		return ip.String()
+	}
+	return remote
+}
+
+func (h *Handlers) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range h.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses the comma separated addresses and CIDR networks, an address is a network of itself.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an IP address", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not a CIDR network err: %w", p, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// startLoginAttempt counts the attempt for the login and the client address before the password is checked.
+// When either of them is locked out, the attempt is counted for none and the time of the lockout is returned.
+func (h *Handlers) startLoginAttempt(ctx context.Context,
+	login models.LoginAttemptKey,
+	ip models.LoginAttemptKey) (time.Time, error) {
+	return h.startAttempt(ctx, login, h.loginPolicy, ip, h.ipPolicy)
+}
+
+func (h *Handlers) startAttempt(ctx context.Context,
+	login models.LoginAttemptKey,
+	loginPolicy models.LoginLockoutPolicy,
+	ip models.LoginAttemptKey,
+	ipPolicy models.LoginLockoutPolicy) (time.Time, error) {
+	until, err := ip.Start(ctx, h.store, ipPolicy)
+	if err != nil || !until.IsZero() {
+		return until, err
+	}
+
+	until, err = login.Start(ctx, h.store, loginPolicy)
+	if err != nil || !until.IsZero() {
+		h.cancelAttempt(ctx, ip, ipPolicy)
+		return until, err
+	}
+
+	return time.Time{}, nil
+}
+
+// cancelAttempt takes back the attempt that didn't fail.
+func (h *Handlers) cancelAttempt(ctx context.Context, k models.LoginAttemptKey, p models.LoginLockoutPolicy) {
+	if err := k.Cancel(ctx, h.store, p); err != nil {
+		h.log.Errorf("failed to cancel login attempt err: %v", err)
+	}
+}
+
+func (h *Handlers) writeTooManyRequests(w http.ResponseWriter, until time.Time) {
+	s := setRetryAfter(w, until)
+	h.writeError(w, http.StatusTooManyRequests, codeTooManyLoginAttempts,
+		fmt.Sprintf("too many failed login attempts, retry in %d seconds", s))
+}
+
+// setRetryAfter sets Retry-After to the whole seconds until the time, it is at least one second.
+func setRetryAfter(w http.ResponseWriter, until time.Time) int {
+	s := int(math.Ceil(time.Until(until).Seconds()))
+	if s < 1 {
+		s = 1
+	}
+
+	w.Header().Set(retryAfterHeader, strconv.Itoa(s))
+	return s
+}
+
+// AdminMiddleware allows the request only with the admin key, admin routes are disabled when the key is not set.
+func (h *Handlers) AdminMiddleware(hr http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(h.adminKey) == 0 {
+			h.writeError(w, http.StatusNotFound, codeNotFound, "")
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(adminKeyHeader)), h.adminKey) != 1 {
+			h.writeError(w, http.StatusForbidden, codeForbidden, "admin key is invalid")
+			return
+		}
+
+		hr.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handlers) UnlockLogin(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Login string `json:"login"`
+		IP    string `json:"ip"`
+	}{}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("failed to read the UnlockLogin request body err: %v", err)
+		return
+	}
+
+	if err := json.Unmarshal(b, &req); err != nil {
+		h.writeError(w, http.StatusBadRequest, codeMalformedRequest, errMalformedJSON)
+		return
+	}
+
+	var keys []models.LoginAttemptKey
+	if req.Login != "" {
+		keys = append(keys, models.LoginAttemptKey{Kind: models.LoginAttemptLogin, Value: req.Login})
+	}
+	if req.IP != "" {
+		keys = append(keys, models.LoginAttemptKey{Kind: models.LoginAttemptIP, Value: req.IP})
+	}
+
+	if len(keys) == 0 {
+		h.writeError(w, http.StatusBadRequest, codeUnlockTargetEmpty, "login or ip is required")
+		return
+	}
+
+	for _, k := range keys {
+		if err := k.Reset(ctx, h.store); err != nil {
+			h.writeInternalError(w)
+			h.log.Errorf("failed to unlock login err: %v", err)
+			return
+		}
+	}
+
+	h.log.Infof("login was unlocked by admin, login: %q, ip: %q", req.Login, req.IP)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+// testLoginAttemptStore counts failed logins the same way the database counts them.
+type testLoginAttemptStore struct {
+	mx       *sync.Mutex
+	failures map[models.LoginAttemptKey]int
+	until    map[models.LoginAttemptKey]time.Time
+}
+
+func newTestLoginAttemptStore() *testLoginAttemptStore {
+	return &testLoginAttemptStore{
+		mx:       &sync.Mutex{},
+		failures: make(map[models.LoginAttemptKey]int),
+		until:    make(map[models.LoginAttemptKey]time.Time),
+	}
+}
+
+func (s *testLoginAttemptStore) expect(mr *MockStorageMockRecorder) {
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, k models.LoginAttemptKey, p models.LoginLockoutPolicy) (time.Time, error) {
+			s.mx.Lock()
+			defer s.mx.Unlock()
+
+			if time.Now().Before(s.until[k]) {
+				return s.until[k], nil
+			}
+			s.failures[k]++
+			if d := p.Delay(s.failures[k]); d > 0 {
+				s.until[k] = time.Now().Add(d)
+			}
+			return time.Time{}, nil
+		})
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, k models.LoginAttemptKey, p models.LoginLockoutPolicy) error {
+			s.mx.Lock()
+			defer s.mx.Unlock()
+
+			if s.failures[k] > 0 {
+				s.failures[k]--
+			}
+			if p.Delay(s.failures[k]) == 0 {
+				delete(s.until, k)
+			}
+			return nil
+		})
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, k models.LoginAttemptKey) error {
+			s.mx.Lock()
+			defer s.mx.Unlock()
+
+			delete(s.failures, k)
+			delete(s.until, k)
+			return nil
+		})
+}
+
+func TestHandlers_LoginLockout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := NewMockStorage(ctrl)
+	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+
+	const password = "password"
+
+	users := map[string]*models.User{
+		"test1": {ID: "1", Login: "test1", PasswordHash: password},
+		"test2": {ID: "2", Login: "test2", PasswordHash: password},
+	}
+
+	hashc.EXPECT().CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(hash string, password string) bool {
+			return hash == password
+		})
+	hashc.EXPECT().CheckDummyHash(gomock.Any()).AnyTimes()
+
+	mr := db.EXPECT()
+	newTestLoginAttemptStore().expect(mr)
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.GetUser(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, u *models.UserDTO) (*models.User, error) {
+			us, ok := users[u.Login]
+			if !ok {
+				return nil, models.ErrUnknowUser
+			}
+			return us, nil
+		})
+
+	cfg := testConfig("keyLoginLockout")
+	cfg.LoginMaxFailures = 3
+	cfg.LoginMaxIPFailures = 6
+	cfg.LoginMaxLockout = time.Minute
+	cfg.AdminKey = "admin"
+
+	h, err := NewHandlers(cfg, db, zap.L().Sugar(), hashc)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testServer := httptest.NewServer(initRouter(h))
+	defer testServer.Close()
+
+	tryLogin := func(login string, password string) *http.Response {
+		b, err := json.Marshal(&models.UserDTO{Login: login, Password: password})
+		require.NoError(t, err)
+
+		resp, _ := testRequest(t, testServer, http.MethodPost, "/api/user/login", "", bytes.NewBuffer(b))
+		return resp
+	}
+
+	for i := 0; i < cfg.LoginMaxFailures; i++ {
+		resp := tryLogin("test1", "wrong")
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "failed login #%d", i+1)
+	}
+
+	resp := tryLogin("test1", password)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "locked out login")
+	retryAfter, err := strconv.Atoi(resp.Header.Get(retryAfterHeader))
+	require.NoError(t, err)
+	require.Equal(t, 1, retryAfter)
+
+	resp = tryLogin("test2", password)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "another login is not locked out")
+
+	resp = unlock(t, testServer, "", `{"login": "test1"}`)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode, "unlock without admin key")
+
+	resp = unlock(t, testServer, "wrong", `{"login": "test1"}`)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode, "unlock with wrong admin key")
+
+	resp = unlock(t, testServer, cfg.AdminKey, `{}`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, "unlock without login and ip")
+
+	resp = unlock(t, testServer, cfg.AdminKey, `{"login": "test1"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "unlock")
+
+	resp = tryLogin("test1", password)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "unlocked login")
+
+	// Unknown logins are counted against the client IP address as well.
+	for i := cfg.LoginMaxFailures; i < cfg.LoginMaxIPFailures; i++ {
+		resp = tryLogin("unknown"+strconv.Itoa(i), "wrong")
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "failed login #%d", i+1)
+	}
+
+	resp = tryLogin("test2", password)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "locked out IP address")
+
+	resp = unlock(t, testServer, cfg.AdminKey, `{"ip": "127.0.0.1"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "unlock IP address")
+
+	resp = tryLogin("test2", password)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "unlocked IP address")
+}
+
+func TestHandlers_AdminDisabled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	h, err := NewHandlers(testConfig("keyAdminDisabled"), NewMockStorage(ctrl), zap.L().Sugar(),
+		NewMockHashController(ctrl))
+	if err != nil {
+		t.Error(err)
+	}
+
+	testServer := httptest.NewServer(initRouter(h))
+	defer testServer.Close()
+
+	resp := unlock(t, testServer, "", `{"login": "test1"}`)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func unlock(t *testing.T, ts *httptest.Server, adminKey string, body string) *http.Response {
+	t.Helper()
+
+	u, err := url.JoinPath(ts.URL, "/api/admin/login/unlock")
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewBufferString(body))
+	require.NoError(t, err)
+	req.Header.Set(contentType, contentTypeJSON)
+	if adminKey != "" {
+		req.Header.Set(adminKeyHeader, adminKey)
+	}
+
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	return resp
+}
+
+func TestHandlers_LoginLockoutInParallel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := NewMockStorage(ctrl)
+	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+	hashc.EXPECT().CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().Return(false)
+
+	mr := db.EXPECT()
+	newTestLoginAttemptStore().expect(mr)
+	mr.GetUser(gomock.Any(), gomock.Any()).AnyTimes().Return(&models.User{ID: "1", Login: "test1"}, nil)
+
+	cfg := testConfig("keyLoginLockoutInParallel")
+	cfg.LoginMaxFailures = 3
+	cfg.LoginMaxIPFailures = 100
+	cfg.LoginMaxLockout = time.Minute
+
+	h, err := NewHandlers(cfg, db, zap.L().Sugar(), hashc)
+	require.NoError(t, err)
+	h.hashSlots = make(chan struct{}, 100)
+
+	testServer := httptest.NewServer(initRouter(h))
+	defer testServer.Close()
+
+	// The attempts are counted before the password is checked, so the parallel ones can't pass the lockout together.
+	const attempts = 20
+	statuses := make(chan int, attempts)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			resp, _ := testRequest(t, testServer, http.MethodPost, "/api/user/login", "",
+				bytes.NewBufferString(`{"login":"test1","password":"wrong"}`))
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	checked := 0
+	for s := range statuses {
+		if s == http.StatusUnauthorized {
+			checked++
+			continue
+		}
+		require.Equal(t, http.StatusTooManyRequests, s)
+	}
+	require.Equal(t, cfg.LoginMaxFailures, checked, "only the free attempts check the password")
+}
+
+func TestHandlers_clientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.168.1.1, ::1")
+	require.NoError(t, err)
+	h := &Handlers{trustedProxies: proxies}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{
+			name:       "not a proxy",
+			remoteAddr: "203.0.113.5:4000",
+			forwarded:  []string{"198.51.100.1"},
+			realIP:     "198.51.100.2",
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "the last untrusted hop",
+			remoteAddr: "10.0.0.2:4000",
+			forwarded:  []string{"198.51.100.1, 198.51.100.7", "192.168.1.1"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "only proxies",
+			remoteAddr: "10.0.0.2:4000",
+			forwarded:  []string{"10.0.0.3, 192.168.1.1"},
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "the hop is not an address",
+			remoteAddr: "10.0.0.2:4000",
+			forwarded:  []string{"unknown, 10.0.0.3"},
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "real ip",
+			remoteAddr: "[::1]:4000",
+			realIP:     "2001:db8::0:1",
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "without headers",
+			remoteAddr: "192.168.1.1:4000",
+			want:       "192.168.1.1",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/user/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, f := range tt.forwarded {
+				r.Header.Add(forwardedForHeader, f)
+			}
+			if tt.realIP != "" {
+				r.Header.Set(realIPHeader, tt.realIP)
+			}
+
+			require.Equal(t, tt.want, h.clientIP(r))
+		})
+	}
+
+	_, err = parseTrustedProxies("10.0.0.0/33")
+	require.Error(t, err)
+	_, err = parseTrustedProxies("proxy")
+	require.Error(t, err)
+}
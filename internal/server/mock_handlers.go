@@ -7,8 +7,10 @@ package server
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	models "github.com/ArtemShalinFe/gophermart/internal/models"
+	notifier "github.com/ArtemShalinFe/gophermart/internal/notifier"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -35,6 +37,36 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 	return m.recorder
 }
 
+// AccessTokenIDIsRevoked mocks base method.
+func (m *MockStorage) AccessTokenIDIsRevoked(ctx context.Context, jti string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AccessTokenIDIsRevoked", ctx, jti)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AccessTokenIDIsRevoked indicates an expected call of AccessTokenIDIsRevoked.
+func (mr *MockStorageMockRecorder) AccessTokenIDIsRevoked(ctx, jti interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AccessTokenIDIsRevoked", reflect.TypeOf((*MockStorage)(nil).AccessTokenIDIsRevoked), ctx, jti)
+}
+
+// AccessTokenIsRevoked mocks base method.
+func (m *MockStorage) AccessTokenIsRevoked(ctx context.Context, jti, userID string, sessionGen int64) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AccessTokenIsRevoked", ctx, jti, userID, sessionGen)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AccessTokenIsRevoked indicates an expected call of AccessTokenIsRevoked.
+func (mr *MockStorageMockRecorder) AccessTokenIsRevoked(ctx, jti, userID, sessionGen interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AccessTokenIsRevoked", reflect.TypeOf((*MockStorage)(nil).AccessTokenIsRevoked), ctx, jti, userID, sessionGen)
+}
+
 // AddOrder mocks base method.
 func (m *MockStorage) AddOrder(ctx context.Context, order *models.OrderDTO) (*models.Order, error) {
 	m.ctrl.T.Helper()
@@ -50,6 +82,49 @@ func (mr *MockStorageMockRecorder) AddOrder(ctx, order interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOrder", reflect.TypeOf((*MockStorage)(nil).AddOrder), ctx, order)
 }
 
+// AddOrders mocks base method.
+func (m *MockStorage) AddOrders(ctx context.Context, userID string, numbers []string) ([]*models.OrderBatchResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddOrders", ctx, userID, numbers)
+	ret0, _ := ret[0].([]*models.OrderBatchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddOrders indicates an expected call of AddOrders.
+func (mr *MockStorageMockRecorder) AddOrders(ctx, userID, numbers interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOrders", reflect.TypeOf((*MockStorage)(nil).AddOrders), ctx, userID, numbers)
+}
+
+// AddPasswordResetToken mocks base method.
+func (m *MockStorage) AddPasswordResetToken(ctx context.Context, t *models.PasswordResetToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddPasswordResetToken", ctx, t)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddPasswordResetToken indicates an expected call of AddPasswordResetToken.
+func (mr *MockStorageMockRecorder) AddPasswordResetToken(ctx, t interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddPasswordResetToken", reflect.TypeOf((*MockStorage)(nil).AddPasswordResetToken), ctx, t)
+}
+
+// AddRefreshToken mocks base method.
+func (m *MockStorage) AddRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddRefreshToken", ctx, t)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddRefreshToken indicates an expected call of AddRefreshToken.
+func (mr *MockStorageMockRecorder) AddRefreshToken(ctx, t interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddRefreshToken", reflect.TypeOf((*MockStorage)(nil).AddRefreshToken), ctx, t)
+}
+
 // AddUser mocks base method.
 func (m *MockStorage) AddUser(ctx context.Context, us *models.UserDTO) (*models.User, error) {
 	m.ctrl.T.Helper()
@@ -66,7 +141,7 @@ func (mr *MockStorageMockRecorder) AddUser(ctx, us interface{}) *gomock.Call {
 }
 
 // AddWithdrawn mocks base method.
-func (m *MockStorage) AddWithdrawn(ctx context.Context, userID, orderNumber string, sum float64) error {
+func (m *MockStorage) AddWithdrawn(ctx context.Context, userID, orderNumber string, sum models.Amount) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AddWithdrawn", ctx, userID, orderNumber, sum)
 	ret0, _ := ret[0].(error)
@@ -79,6 +154,63 @@ func (mr *MockStorageMockRecorder) AddWithdrawn(ctx, userID, orderNumber, sum in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddWithdrawn", reflect.TypeOf((*MockStorage)(nil).AddWithdrawn), ctx, userID, orderNumber, sum)
 }
 
+// CancelIdempotentRequest mocks base method.
+func (m *MockStorage) CancelIdempotentRequest(ctx context.Context, k *models.IdempotencyKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelIdempotentRequest", ctx, k)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CancelIdempotentRequest indicates an expected call of CancelIdempotentRequest.
+func (mr *MockStorageMockRecorder) CancelIdempotentRequest(ctx, k interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelIdempotentRequest", reflect.TypeOf((*MockStorage)(nil).CancelIdempotentRequest), ctx, k)
+}
+
+// CancelLoginAttempt mocks base method.
+func (m *MockStorage) CancelLoginAttempt(ctx context.Context, k models.LoginAttemptKey, p models.LoginLockoutPolicy) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelLoginAttempt", ctx, k, p)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CancelLoginAttempt indicates an expected call of CancelLoginAttempt.
+func (mr *MockStorageMockRecorder) CancelLoginAttempt(ctx, k, p interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelLoginAttempt", reflect.TypeOf((*MockStorage)(nil).CancelLoginAttempt), ctx, k, p)
+}
+
+// ChangeUserPassword mocks base method.
+func (m *MockStorage) ChangeUserPassword(ctx context.Context, userID, hash string) (*models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChangeUserPassword", ctx, userID, hash)
+	ret0, _ := ret[0].(*models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ChangeUserPassword indicates an expected call of ChangeUserPassword.
+func (mr *MockStorageMockRecorder) ChangeUserPassword(ctx, userID, hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChangeUserPassword", reflect.TypeOf((*MockStorage)(nil).ChangeUserPassword), ctx, userID, hash)
+}
+
+// FinishIdempotentRequest mocks base method.
+func (m *MockStorage) FinishIdempotentRequest(ctx context.Context, k *models.IdempotencyKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FinishIdempotentRequest", ctx, k)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FinishIdempotentRequest indicates an expected call of FinishIdempotentRequest.
+func (mr *MockStorageMockRecorder) FinishIdempotentRequest(ctx, k interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FinishIdempotentRequest", reflect.TypeOf((*MockStorage)(nil).FinishIdempotentRequest), ctx, k)
+}
+
 // GetBalance mocks base method.
 func (m *MockStorage) GetBalance(ctx context.Context, userID string) (*models.UserBalance, error) {
 	m.ctrl.T.Helper()
@@ -109,19 +241,34 @@ func (mr *MockStorageMockRecorder) GetOrder(ctx, order interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrder", reflect.TypeOf((*MockStorage)(nil).GetOrder), ctx, order)
 }
 
-// GetOrdersForAccrual mocks base method.
-func (m *MockStorage) GetOrdersForAccrual(ctx context.Context) ([]*models.Order, error) {
+// GetOrderHistory mocks base method.
+func (m *MockStorage) GetOrderHistory(ctx context.Context, orderID string) ([]*models.OrderStatusChange, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOrdersForAccrual", ctx)
-	ret0, _ := ret[0].([]*models.Order)
+	ret := m.ctrl.Call(m, "GetOrderHistory", ctx, orderID)
+	ret0, _ := ret[0].([]*models.OrderStatusChange)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetOrdersForAccrual indicates an expected call of GetOrdersForAccrual.
-func (mr *MockStorageMockRecorder) GetOrdersForAccrual(ctx interface{}) *gomock.Call {
+// GetOrderHistory indicates an expected call of GetOrderHistory.
+func (mr *MockStorageMockRecorder) GetOrderHistory(ctx, orderID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrdersForAccrual", reflect.TypeOf((*MockStorage)(nil).GetOrdersForAccrual), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderHistory", reflect.TypeOf((*MockStorage)(nil).GetOrderHistory), ctx, orderID)
+}
+
+// GetPasswordResetUser mocks base method.
+func (m *MockStorage) GetPasswordResetUser(ctx context.Context, tokenHash string) (*models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPasswordResetUser", ctx, tokenHash)
+	ret0, _ := ret[0].(*models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPasswordResetUser indicates an expected call of GetPasswordResetUser.
+func (mr *MockStorageMockRecorder) GetPasswordResetUser(ctx, tokenHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPasswordResetUser", reflect.TypeOf((*MockStorage)(nil).GetPasswordResetUser), ctx, tokenHash)
 }
 
 // GetUploadedOrders mocks base method.
@@ -139,6 +286,21 @@ func (mr *MockStorageMockRecorder) GetUploadedOrders(ctx, order interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUploadedOrders", reflect.TypeOf((*MockStorage)(nil).GetUploadedOrders), ctx, order)
 }
 
+// GetUploadedOrdersPage mocks base method.
+func (m *MockStorage) GetUploadedOrdersPage(ctx context.Context, userID string, f *models.ListFilter) ([]*models.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUploadedOrdersPage", ctx, userID, f)
+	ret0, _ := ret[0].([]*models.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUploadedOrdersPage indicates an expected call of GetUploadedOrdersPage.
+func (mr *MockStorageMockRecorder) GetUploadedOrdersPage(ctx, userID, f interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUploadedOrdersPage", reflect.TypeOf((*MockStorage)(nil).GetUploadedOrdersPage), ctx, userID, f)
+}
+
 // GetUser mocks base method.
 func (m *MockStorage) GetUser(ctx context.Context, us *models.UserDTO) (*models.User, error) {
 	m.ctrl.T.Helper()
@@ -154,6 +316,21 @@ func (mr *MockStorageMockRecorder) GetUser(ctx, us interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*MockStorage)(nil).GetUser), ctx, us)
 }
 
+// GetUserByID mocks base method.
+func (m *MockStorage) GetUserByID(ctx context.Context, userID string) (*models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserByID", ctx, userID)
+	ret0, _ := ret[0].(*models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserByID indicates an expected call of GetUserByID.
+func (mr *MockStorageMockRecorder) GetUserByID(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByID", reflect.TypeOf((*MockStorage)(nil).GetUserByID), ctx, userID)
+}
+
 // GetWithdrawalList mocks base method.
 func (m *MockStorage) GetWithdrawalList(ctx context.Context, userID string) ([]*models.UserWithdrawalsHistory, error) {
 	m.ctrl.T.Helper()
@@ -169,18 +346,223 @@ func (mr *MockStorageMockRecorder) GetWithdrawalList(ctx, userID interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawalList", reflect.TypeOf((*MockStorage)(nil).GetWithdrawalList), ctx, userID)
 }
 
+// GetWithdrawalPage mocks base method.
+func (m *MockStorage) GetWithdrawalPage(ctx context.Context, userID string, f *models.ListFilter) ([]*models.UserWithdrawalsHistory, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWithdrawalPage", ctx, userID, f)
+	ret0, _ := ret[0].([]*models.UserWithdrawalsHistory)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWithdrawalPage indicates an expected call of GetWithdrawalPage.
+func (mr *MockStorageMockRecorder) GetWithdrawalPage(ctx, userID, f interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawalPage", reflect.TypeOf((*MockStorage)(nil).GetWithdrawalPage), ctx, userID, f)
+}
+
+// LeaseOrdersForAccrual mocks base method.
+func (m *MockStorage) LeaseOrdersForAccrual(ctx context.Context, owner string, limit int, lease time.Duration) ([]*models.AccrualJob, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LeaseOrdersForAccrual", ctx, owner, limit, lease)
+	ret0, _ := ret[0].([]*models.AccrualJob)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LeaseOrdersForAccrual indicates an expected call of LeaseOrdersForAccrual.
+func (mr *MockStorageMockRecorder) LeaseOrdersForAccrual(ctx, owner, limit, lease interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LeaseOrdersForAccrual", reflect.TypeOf((*MockStorage)(nil).LeaseOrdersForAccrual), ctx, owner, limit, lease)
+}
+
+// PurgeIdempotencyKeys mocks base method.
+func (m *MockStorage) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeIdempotencyKeys", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeIdempotencyKeys indicates an expected call of PurgeIdempotencyKeys.
+func (mr *MockStorageMockRecorder) PurgeIdempotencyKeys(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeIdempotencyKeys", reflect.TypeOf((*MockStorage)(nil).PurgeIdempotencyKeys), ctx)
+}
+
+// PurgeLoginAttempts mocks base method.
+func (m *MockStorage) PurgeLoginAttempts(ctx context.Context, olderThan time.Duration) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeLoginAttempts", ctx, olderThan)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeLoginAttempts indicates an expected call of PurgeLoginAttempts.
+func (mr *MockStorageMockRecorder) PurgeLoginAttempts(ctx, olderThan interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeLoginAttempts", reflect.TypeOf((*MockStorage)(nil).PurgeLoginAttempts), ctx, olderThan)
+}
+
+// RescheduleOrderAccrual mocks base method.
+func (m *MockStorage) RescheduleOrderAccrual(ctx context.Context, orderID string, lease models.AccrualLease, delay time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RescheduleOrderAccrual", ctx, orderID, lease, delay)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RescheduleOrderAccrual indicates an expected call of RescheduleOrderAccrual.
+func (mr *MockStorageMockRecorder) RescheduleOrderAccrual(ctx, orderID, lease, delay interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RescheduleOrderAccrual", reflect.TypeOf((*MockStorage)(nil).RescheduleOrderAccrual), ctx, orderID, lease, delay)
+}
+
+// ResetLoginFailures mocks base method.
+func (m *MockStorage) ResetLoginFailures(ctx context.Context, k models.LoginAttemptKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetLoginFailures", ctx, k)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetLoginFailures indicates an expected call of ResetLoginFailures.
+func (mr *MockStorageMockRecorder) ResetLoginFailures(ctx, k interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetLoginFailures", reflect.TypeOf((*MockStorage)(nil).ResetLoginFailures), ctx, k)
+}
+
+// ResetUserPassword mocks base method.
+func (m *MockStorage) ResetUserPassword(ctx context.Context, tokenHash, hash string) (*models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetUserPassword", ctx, tokenHash, hash)
+	ret0, _ := ret[0].(*models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResetUserPassword indicates an expected call of ResetUserPassword.
+func (mr *MockStorageMockRecorder) ResetUserPassword(ctx, tokenHash, hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetUserPassword", reflect.TypeOf((*MockStorage)(nil).ResetUserPassword), ctx, tokenHash, hash)
+}
+
+// RetryOrderAccrual mocks base method.
+func (m *MockStorage) RetryOrderAccrual(ctx context.Context, orderID string, lease models.AccrualLease, delay time.Duration, reason string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RetryOrderAccrual", ctx, orderID, lease, delay, reason)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RetryOrderAccrual indicates an expected call of RetryOrderAccrual.
+func (mr *MockStorageMockRecorder) RetryOrderAccrual(ctx, orderID, lease, delay, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetryOrderAccrual", reflect.TypeOf((*MockStorage)(nil).RetryOrderAccrual), ctx, orderID, lease, delay, reason)
+}
+
+// RevokeAccessToken mocks base method.
+func (m *MockStorage) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAccessToken", ctx, jti, expiresAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeAccessToken indicates an expected call of RevokeAccessToken.
+func (mr *MockStorageMockRecorder) RevokeAccessToken(ctx, jti, expiresAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAccessToken", reflect.TypeOf((*MockStorage)(nil).RevokeAccessToken), ctx, jti, expiresAt)
+}
+
+// RevokeRefreshToken mocks base method.
+func (m *MockStorage) RevokeRefreshToken(ctx context.Context, userID, hash string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeRefreshToken", ctx, userID, hash)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeRefreshToken indicates an expected call of RevokeRefreshToken.
+func (mr *MockStorageMockRecorder) RevokeRefreshToken(ctx, userID, hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeRefreshToken", reflect.TypeOf((*MockStorage)(nil).RevokeRefreshToken), ctx, userID, hash)
+}
+
+// RotateRefreshToken mocks base method.
+func (m *MockStorage) RotateRefreshToken(ctx context.Context, hash string, next *models.RefreshToken) (*models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateRefreshToken", ctx, hash, next)
+	ret0, _ := ret[0].(*models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RotateRefreshToken indicates an expected call of RotateRefreshToken.
+func (mr *MockStorageMockRecorder) RotateRefreshToken(ctx, hash, next interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateRefreshToken", reflect.TypeOf((*MockStorage)(nil).RotateRefreshToken), ctx, hash, next)
+}
+
+// StartIdempotentRequest mocks base method.
+func (m *MockStorage) StartIdempotentRequest(ctx context.Context, k *models.IdempotencyKey) (*models.IdempotencyKey, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StartIdempotentRequest", ctx, k)
+	ret0, _ := ret[0].(*models.IdempotencyKey)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// StartIdempotentRequest indicates an expected call of StartIdempotentRequest.
+func (mr *MockStorageMockRecorder) StartIdempotentRequest(ctx, k interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartIdempotentRequest", reflect.TypeOf((*MockStorage)(nil).StartIdempotentRequest), ctx, k)
+}
+
+// StartLoginAttempt mocks base method.
+func (m *MockStorage) StartLoginAttempt(ctx context.Context, k models.LoginAttemptKey, p models.LoginLockoutPolicy) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StartLoginAttempt", ctx, k, p)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StartLoginAttempt indicates an expected call of StartLoginAttempt.
+func (mr *MockStorageMockRecorder) StartLoginAttempt(ctx, k, p interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartLoginAttempt", reflect.TypeOf((*MockStorage)(nil).StartLoginAttempt), ctx, k, p)
+}
+
 // UpdateOrder mocks base method.
-func (m *MockStorage) UpdateOrder(ctx context.Context, order *models.Order) error {
+func (m *MockStorage) UpdateOrder(ctx context.Context, order *models.Order, lease *models.AccrualLease) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateOrder", ctx, order)
+	ret := m.ctrl.Call(m, "UpdateOrder", ctx, order, lease)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateOrder indicates an expected call of UpdateOrder.
-func (mr *MockStorageMockRecorder) UpdateOrder(ctx, order interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) UpdateOrder(ctx, order, lease interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrder", reflect.TypeOf((*MockStorage)(nil).UpdateOrder), ctx, order)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrder", reflect.TypeOf((*MockStorage)(nil).UpdateOrder), ctx, order, lease)
+}
+
+// UpdateUserPassword mocks base method.
+func (m *MockStorage) UpdateUserPassword(ctx context.Context, userID, hash string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateUserPassword", ctx, userID, hash)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateUserPassword indicates an expected call of UpdateUserPassword.
+func (mr *MockStorageMockRecorder) UpdateUserPassword(ctx, userID, hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserPassword", reflect.TypeOf((*MockStorage)(nil).UpdateUserPassword), ctx, userID, hash)
 }
 
 // MockHashController is a mock of HashController interface.
@@ -206,6 +588,18 @@ func (m *MockHashController) EXPECT() *MockHashControllerMockRecorder {
 	return m.recorder
 }
 
+// CheckDummyHash mocks base method.
+func (m *MockHashController) CheckDummyHash(password string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "CheckDummyHash", password)
+}
+
+// CheckDummyHash indicates an expected call of CheckDummyHash.
+func (mr *MockHashControllerMockRecorder) CheckDummyHash(password interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckDummyHash", reflect.TypeOf((*MockHashController)(nil).CheckDummyHash), password)
+}
+
 // CheckPasswordHash mocks base method.
 func (m *MockHashController) CheckPasswordHash(hash, password string) bool {
 	m.ctrl.T.Helper()
@@ -234,3 +628,54 @@ func (mr *MockHashControllerMockRecorder) HashPassword(password interface{}) *go
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HashPassword", reflect.TypeOf((*MockHashController)(nil).HashPassword), password)
 }
+
+// NeedsRehash mocks base method.
+func (m *MockHashController) NeedsRehash(hash string) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NeedsRehash", hash)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// NeedsRehash indicates an expected call of NeedsRehash.
+func (mr *MockHashControllerMockRecorder) NeedsRehash(hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NeedsRehash", reflect.TypeOf((*MockHashController)(nil).NeedsRehash), hash)
+}
+
+// MockNotifier is a mock of Notifier interface.
+type MockNotifier struct {
+	ctrl     *gomock.Controller
+	recorder *MockNotifierMockRecorder
+}
+
+// MockNotifierMockRecorder is the mock recorder for MockNotifier.
+type MockNotifierMockRecorder struct {
+	mock *MockNotifier
+}
+
+// NewMockNotifier creates a new mock instance.
+func NewMockNotifier(ctrl *gomock.Controller) *MockNotifier {
+	mock := &MockNotifier{ctrl: ctrl}
+	mock.recorder = &MockNotifierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockNotifier) EXPECT() *MockNotifierMockRecorder {
+	return m.recorder
+}
+
+// NotifyPasswordReset mocks base method.
+func (m_2 *MockNotifier) NotifyPasswordReset(ctx context.Context, m *notifier.PasswordReset) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "NotifyPasswordReset", ctx, m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NotifyPasswordReset indicates an expected call of NotifyPasswordReset.
+func (mr *MockNotifierMockRecorder) NotifyPasswordReset(ctx, m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyPasswordReset", reflect.TypeOf((*MockNotifier)(nil).NotifyPasswordReset), ctx, m)
+}
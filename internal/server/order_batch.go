@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+const maxOrderBatchSize = 1000
+
+var errOrderBatchMalformed = errors.New("request body must be a JSON array of order numbers")
+
+// AddOrderBatch uploads the orders given as a JSON array or as numbers on separate lines.
+// The invalid numbers do not fail the whole batch, every number gets its own result.
+func (h *Handlers) AddOrderBatch(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	u, ok := userFromContext(ctx)
+	if !ok {
+		h.writeError(w, http.StatusBadRequest, codeUserUndefined, errUserUndefined)
+		h.log.Errorf(errUserUndefined)
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("failed to read the AddOrderBatch request body err: %v", err)
+		return
+	}
+
+	var numbers []string
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get(contentType)); mt == contentTypeJSON {
+		if numbers, err = parseJSONOrderNumbers(b); err != nil {
+			h.writeError(w, http.StatusBadRequest, codeMalformedRequest, err.Error())
+			return
+		}
+	} else {
+		numbers = parseOrderNumberLines(b)
+	}
+
+	if len(numbers) == 0 {
+		h.writeError(w, http.StatusBadRequest, codeOrderBatchEmpty, "order numbers are required")
+		return
+	}
+	if len(numbers) > maxOrderBatchSize {
+		h.writeError(w, http.StatusBadRequest, codeOrderBatchTooLarge,
+			fmt.Sprintf("at most %d order numbers can be uploaded at once", maxOrderBatchSize))
+		return
+	}
+
+	rs, err := models.AddOrderBatch(ctx, h.store, u.ID, numbers)
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("failed to add orders in the AddOrderBatch request err: %v", err)
+		return
+	}
+
+	res, err := json.Marshal(rs)
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("order batch results marshal to json err: %v", err)
+		return
+	}
+
+	w.Header().Set(contentType, contentTypeJSON)
+
+	if _, err = w.Write(res); err != nil {
+		h.log.Errorf("failed to write order batch results err: %v", err)
+	}
+}
+
+// parseJSONOrderNumbers accepts the numbers both as JSON strings and as JSON numbers.
+func parseJSONOrderNumbers(b []byte) ([]string, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, errOrderBatchMalformed
+	}
+
+	numbers := make([]string, 0, len(raw))
+	for _, v := range raw {
+		v = bytes.TrimSpace(v)
+		if len(v) > 0 && v[0] == '"' {
+			var s string
+			if err := json.Unmarshal(v, &s); err != nil {
+				return nil, errOrderBatchMalformed
+			}
+			numbers = append(numbers, strings.TrimSpace(s))
+			continue
+		}
+		numbers = append(numbers, string(v))
+	}
+
+	return numbers, nil
+}
+
+func parseOrderNumberLines(b []byte) []string {
+	var numbers []string
+	for _, l := range strings.Split(string(b), "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			numbers = append(numbers, l)
+		}
+	}
+	return numbers
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestHandlers_AddOrderBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := NewMockStorage(ctrl)
+	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+	hashc.EXPECT().CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
+
+	u1 := &models.User{ID: "1", Login: "test", PasswordHash: "test"}
+
+	owners := map[string]string{
+		"79927398713": u1.ID,
+		"12345678903": "2",
+	}
+
+	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
+	mr.GetUser(gomock.Any(), gomock.Any()).AnyTimes().Return(u1, nil)
+	mr.AddOrders(gomock.Any(), u1.ID, gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, userID string, numbers []string) ([]*models.OrderBatchResult, error) {
+			var rs []*models.OrderBatchResult
+			for _, n := range numbers {
+				r := models.OrderBatchAccepted
+				if owner, ok := owners[n]; ok && owner == userID {
+					r = models.OrderBatchUploadedByUser
+				} else if ok {
+					r = models.OrderBatchUploadedByAnotherUser
+				}
+				rs = append(rs, &models.OrderBatchResult{Number: n, Result: r})
+			}
+			return rs, nil
+		})
+
+	h, err := NewHandlers(testConfig("keyAddOrderBatch"), db, zap.L().Sugar(), hashc)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(initRouter(h))
+	defer ts.Close()
+
+	token := GetAuthorizationToken(t, ts, &models.UserDTO{Login: u1.Login, Password: u1.PasswordHash})
+
+	want := []*models.OrderBatchResult{
+		{Number: "49927398716", Result: models.OrderBatchAccepted},
+		{Number: "49927398717", Result: models.OrderBatchInvalid},
+		{Number: "79927398713", Result: models.OrderBatchUploadedByUser},
+		{Number: "12345678903", Result: models.OrderBatchUploadedByAnotherUser},
+	}
+
+	var tests = []struct {
+		name        string
+		contentType string
+		body        string
+		code        string
+		status      int
+		want        []*models.OrderBatchResult
+	}{
+		{
+			name:        "JSON array",
+			contentType: contentTypeJSON,
+			body:        `["49927398716", 49927398717, " 79927398713 ", "12345678903"]`,
+			status:      http.StatusOK,
+			want:        want,
+		},
+		{
+			name:        "Numbers on lines",
+			contentType: contentTypeText,
+			body:        "49927398716\r\n49927398717\n\n 79927398713\n12345678903\n",
+			status:      http.StatusOK,
+			want:        want,
+		},
+		{
+			name:        "Malformed JSON",
+			contentType: contentTypeJSON,
+			body:        `{"number": "49927398716"}`,
+			status:      http.StatusBadRequest,
+			code:        codeMalformedRequest,
+		},
+		{
+			name:        "Empty batch",
+			contentType: contentTypeText,
+			body:        "\n\n",
+			status:      http.StatusBadRequest,
+			code:        codeOrderBatchEmpty,
+		},
+		{
+			name:        "Too large batch",
+			contentType: contentTypeText,
+			body:        strings.Repeat("49927398716\n", maxOrderBatchSize+1),
+			status:      http.StatusBadRequest,
+			code:        codeOrderBatchTooLarge,
+		},
+	}
+
+	for _, v := range tests {
+		v := v
+		t.Run(v.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/user/orders/batch", bytes.NewBufferString(v.body))
+			require.NoError(t, err)
+			req.Header.Set(authHeaderName, token)
+			req.Header.Set(contentType, v.contentType)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			var body bytes.Buffer
+			_, err = body.ReadFrom(resp.Body)
+			require.NoError(t, err)
+
+			if v.status != http.StatusOK {
+				requireProblem(t, resp, body.Bytes(), v.status, v.code)
+				return
+			}
+			require.Equal(t, v.status, resp.StatusCode, body.String())
+
+			var rs []*models.OrderBatchResult
+			require.NoError(t, json.Unmarshal(body.Bytes(), &rs))
+			require.Equal(t, v.want, rs)
+		})
+	}
+}
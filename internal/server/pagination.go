@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+const (
+	queryLimit  = "limit"
+	queryCursor = "cursor"
+	queryStatus = "status"
+	queryFrom   = "from"
+	queryTo     = "to"
+	querySort   = "sort"
+)
+
+const dateLayout = "2006-01-02"
+
+var orderStatuses = map[string]struct{}{
+	models.OrderStatusNew:        {},
+	models.OrderStatusProcessing: {},
+	models.OrderStatusInvalid:    {},
+	models.OrderStatusProcessed:  {},
+}
+
+// parseListFilter reads the page parameters, the list is not paginated when none of them is given,
+// so the clients that do not know about the pages get the whole list as before.
+func parseListFilter(q url.Values, withStatus bool) (*models.ListFilter, bool, error) {
+	paged := false
+	for _, k := range []string{queryLimit, queryCursor, queryStatus, queryFrom, queryTo, querySort} {
+		if q.Has(k) {
+			paged = true
+			break
+		}
+	}
+	if !paged {
+		return nil, false, nil
+	}
+
+	f := &models.ListFilter{
+		Limit: models.DefaultPageLimit,
+		Sort:  models.SortDesc,
+	}
+
+	if s := q.Get(queryLimit); s != "" {
+		l, err := strconv.Atoi(s)
+		if err != nil || l < 1 || l > models.MaxPageLimit {
+			return nil, true, fmt.Errorf("%s must be a number from 1 to %d", queryLimit, models.MaxPageLimit)
+		}
+		f.Limit = l
+	}
+
+	if s := q.Get(querySort); s != "" {
+		s = strings.ToLower(s)
+		if s != models.SortAsc && s != models.SortDesc {
+			return nil, true, fmt.Errorf("%s must be %s or %s", querySort, models.SortAsc, models.SortDesc)
+		}
+		f.Sort = s
+	}
+
+	if s := q.Get(queryCursor); s != "" {
+		c, err := models.DecodeCursor(s)
+		if err != nil {
+			return nil, true, err
+		}
+		f.Cursor = c
+	}
+
+	var err error
+	if f.From, err = parseQueryTime(q, queryFrom); err != nil {
+		return nil, true, err
+	}
+	if f.To, err = parseQueryTime(q, queryTo); err != nil {
+		return nil, true, err
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return nil, true, fmt.Errorf("%s must be before %s", queryFrom, queryTo)
+	}
+
+	if q.Has(queryStatus) && !withStatus {
+		return nil, true, fmt.Errorf("%s is not supported by the list", queryStatus)
+	}
+
+	// The statuses may be given both as a comma-separated list and as repeated parameters.
+	for _, v := range q[queryStatus] {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.ToUpper(strings.TrimSpace(s))
+			if _, ok := orderStatuses[s]; !ok {
+				return nil, true, fmt.Errorf("unknown %s %q", queryStatus, s)
+			}
+			f.Statuses = append(f.Statuses, s)
+		}
+	}
+
+	if err := f.CheckCursor(); err != nil {
+		return nil, true, err
+	}
+
+	return f, true, nil
+}
+
+// parseQueryTime accepts RFC 3339 time or a date, the date means the midnight UTC.
+func parseQueryTime(q url.Values, key string) (time.Time, error) {
+	s := q.Get(key)
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be RFC 3339 time or YYYY-MM-DD date", key)
+	}
+	return t, nil
+}
+
+func (h *Handlers) writeListFilterError(w http.ResponseWriter, err error) {
+	if errors.Is(err, models.ErrCursorIsInvalid) {
+		h.writeError(w, http.StatusBadRequest, codeCursorInvalid, err.Error())
+		return
+	}
+	if errors.Is(err, models.ErrCursorMismatch) {
+		h.writeError(w, http.StatusBadRequest, codeCursorMismatch, err.Error())
+		return
+	}
+	h.writeError(w, http.StatusBadRequest, codeInvalidQuery, err.Error())
+}
+
+func (h *Handlers) getOrdersPage(ctx context.Context, w http.ResponseWriter, u *models.User, f *models.ListFilter) {
+	p, err := u.GetUploadedOrdersPage(ctx, h.store, f)
+	if err != nil {
+		if errors.Is(err, models.ErrCursorIsInvalid) {
+			h.writeListFilterError(w, models.ErrCursorIsInvalid)
+			return
+		}
+		h.writeInternalError(w)
+		h.log.Errorf("get uploaded orders page err: %v", err)
+		return
+	}
+
+	h.writePage(w, p)
+}
+
+func (h *Handlers) getWithdrawalPage(ctx context.Context, w http.ResponseWriter, u *models.User, f *models.ListFilter) {
+	p, err := u.GetWithdrawalPage(ctx, h.store, f)
+	if err != nil {
+		if errors.Is(err, models.ErrCursorIsInvalid) {
+			h.writeListFilterError(w, models.ErrCursorIsInvalid)
+			return
+		}
+		h.writeInternalError(w)
+		h.log.Errorf("get withdrawal page err: %v", err)
+		return
+	}
+
+	h.writePage(w, p)
+}
+
+func (h *Handlers) writePage(w http.ResponseWriter, p any) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("page marshal to json err: %v", err)
+		return
+	}
+
+	w.Header().Set(contentType, contentTypeJSON)
+
+	if _, err = w.Write(b); err != nil {
+		h.log.Errorf("failed to write page err: %v", err)
+	}
+}
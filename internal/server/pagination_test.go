@@ -0,0 +1,291 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestParseListFilter(t *testing.T) {
+	cursorTime := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	cursor := (&models.Cursor{
+		Time:     cursorTime,
+		ID:       "1",
+		Sort:     models.SortAsc,
+		From:     time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC),
+		To:       time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC),
+		Statuses: []string{models.OrderStatusInvalid, models.OrderStatusNew, models.OrderStatusProcessed},
+	}).Encode()
+	descCursor := (&models.Cursor{Time: cursorTime, ID: "1", Sort: models.SortDesc}).Encode()
+
+	tests := []struct {
+		name       string
+		query      string
+		withStatus bool
+		wantPaged  bool
+		wantErr    bool
+		want       *models.ListFilter
+	}{
+		{
+			name:      "no parameters",
+			query:     "",
+			wantPaged: false,
+		},
+		{
+			name:      "defaults",
+			query:     "limit=",
+			wantPaged: true,
+			want:      &models.ListFilter{Limit: models.DefaultPageLimit, Sort: models.SortDesc},
+		},
+		{
+			name: "all parameters",
+			query: "limit=10&sort=ASC&status=new,Processed&status=INVALID" +
+				"&from=2023-05-01&to=2023-06-01T10:00:00Z&cursor=" + cursor,
+			withStatus: true,
+			wantPaged:  true,
+			want: &models.ListFilter{
+				Limit:    10,
+				Sort:     models.SortAsc,
+				Statuses: []string{models.OrderStatusNew, models.OrderStatusProcessed, models.OrderStatusInvalid},
+				From:     time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC),
+				To:       time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC),
+				Cursor:   &models.Cursor{Time: cursorTime, ID: "1"},
+			},
+		},
+		{
+			name:      "cursor of the default filter",
+			query:     "limit=10&cursor=" + descCursor,
+			wantPaged: true,
+			want: &models.ListFilter{
+				Limit:  10,
+				Sort:   models.SortDesc,
+				Cursor: &models.Cursor{Time: cursorTime, ID: "1"},
+			},
+		},
+		{
+			name:      "cursor of another sort",
+			query:     "sort=asc&cursor=" + descCursor,
+			wantPaged: true,
+			wantErr:   true,
+		},
+		{
+			name:       "cursor of another status",
+			query:      "status=NEW&cursor=" + descCursor,
+			withStatus: true,
+			wantPaged:  true,
+			wantErr:    true,
+		},
+		{
+			name:      "cursor of another time range",
+			query:     "from=2023-05-02&cursor=" + descCursor,
+			wantPaged: true,
+			wantErr:   true,
+		},
+		{
+			name:      "limit is zero",
+			query:     "limit=0",
+			wantPaged: true,
+			wantErr:   true,
+		},
+		{
+			name:      "limit is too big",
+			query:     "limit=1001",
+			wantPaged: true,
+			wantErr:   true,
+		},
+		{
+			name:      "unknown sort",
+			query:     "sort=random",
+			wantPaged: true,
+			wantErr:   true,
+		},
+		{
+			name:       "unknown status",
+			query:      "status=NEW,DONE",
+			withStatus: true,
+			wantPaged:  true,
+			wantErr:    true,
+		},
+		{
+			name:      "status is not supported",
+			query:     "status=NEW",
+			wantPaged: true,
+			wantErr:   true,
+		},
+		{
+			name:      "invalid date",
+			query:     "from=01.05.2023",
+			wantPaged: true,
+			wantErr:   true,
+		},
+		{
+			name:      "from is after to",
+			query:     "from=2023-06-01&to=2023-05-01",
+			wantPaged: true,
+			wantErr:   true,
+		},
+		{
+			name:      "invalid cursor",
+			query:     "cursor=abc",
+			wantPaged: true,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+
+			f, paged, err := parseListFilter(q, tt.withStatus)
+			require.Equal(t, tt.wantPaged, paged)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			if tt.want == nil {
+				require.Nil(t, f)
+				return
+			}
+			require.Equal(t, tt.want.Limit, f.Limit)
+			require.Equal(t, tt.want.Sort, f.Sort)
+			require.Equal(t, tt.want.Statuses, f.Statuses)
+			require.True(t, tt.want.From.Equal(f.From))
+			require.True(t, tt.want.To.Equal(f.To))
+			if tt.want.Cursor == nil {
+				require.Nil(t, f.Cursor)
+			} else {
+				require.Equal(t, tt.want.Cursor.ID, f.Cursor.ID)
+				require.True(t, tt.want.Cursor.Time.Equal(f.Cursor.Time))
+			}
+		})
+	}
+}
+
+func TestHandlers_GetOrdersPage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := NewMockStorage(ctrl)
+	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+	hashc.EXPECT().CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
+
+	u1 := &models.User{ID: "1", Login: "test", PasswordHash: "test"}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var ors []*models.Order
+	for i := 0; i < 5; i++ {
+		ors = append(ors, &models.Order{
+			ID:         string(rune('a' + i)),
+			Number:     "49927398716",
+			Status:     models.OrderStatusNew,
+			UploadedAt: now.Add(-time.Duration(i) * time.Minute),
+		})
+	}
+
+	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
+	mr.GetUser(gomock.Any(), gomock.Any()).AnyTimes().Return(u1, nil)
+	mr.GetUploadedOrdersPage(gomock.Any(), u1.ID, gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, _ string, f *models.ListFilter) ([]*models.Order, error) {
+			var page []*models.Order
+			for _, o := range ors {
+				if f.Cursor != nil && !o.UploadedAt.Before(f.Cursor.Time) {
+					continue
+				}
+				if len(page) == f.Limit {
+					break
+				}
+				page = append(page, o)
+			}
+			return page, nil
+		})
+
+	h, err := NewHandlers(testConfig("keyGetOrdersPage"), db, zap.L().Sugar(), hashc)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(initRouter(h))
+	defer ts.Close()
+
+	token := GetAuthorizationToken(t, ts, &models.UserDTO{Login: u1.Login, Password: u1.PasswordHash})
+
+	var got []*models.Order
+	query := "limit=2"
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3)
+
+		resp, body := testListRequest(t, ts, "/api/user/orders", query, token)
+		require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+
+		var p models.OrderPage
+		require.NoError(t, json.Unmarshal(body, &p))
+		got = append(got, p.Orders...)
+
+		if p.NextCursor == "" {
+			break
+		}
+		query = "limit=2&cursor=" + p.NextCursor
+	}
+
+	require.Len(t, got, len(ors))
+	for i, o := range ors {
+		require.Equal(t, o.ID, got[i].ID)
+	}
+
+	resp, body := testListRequest(t, ts, "/api/user/orders", "limit=abc", token)
+	requireProblem(t, resp, body, http.StatusBadRequest, codeInvalidQuery)
+
+	resp, body = testListRequest(t, ts, "/api/user/orders", "cursor=abc", token)
+	requireProblem(t, resp, body, http.StatusBadRequest, codeCursorInvalid)
+
+	resp, body = testListRequest(t, ts, "/api/user/orders", "limit=2", token)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	var p models.OrderPage
+	require.NoError(t, json.Unmarshal(body, &p))
+	resp, body = testListRequest(t, ts, "/api/user/orders", "limit=2&sort=asc&cursor="+p.NextCursor, token)
+	requireProblem(t, resp, body, http.StatusBadRequest, codeCursorMismatch)
+
+	resp, body = testListRequest(t, ts, "/api/user/withdrawals", "status=NEW", token)
+	requireProblem(t, resp, body, http.StatusBadRequest, codeInvalidQuery)
+}
+
+// testListRequest adds the query after the path, testRequest would escape it.
+func testListRequest(t *testing.T, ts *httptest.Server,
+	path string, query string, jwt string) (*http.Response, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+path+"?"+query, nil)
+	require.NoError(t, err)
+	req.Header.Set(authHeaderName, jwt)
+
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, body
+}
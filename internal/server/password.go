@@ -0,0 +1,318 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+	"github.com/ArtemShalinFe/gophermart/internal/notifier"
+	"github.com/ArtemShalinFe/gophermart/internal/security"
+)
+
+const errResetTokenInvalid = "password reset token is invalid or expired"
+const errResetDisabled = "password reset is disabled, the tokens can not be delivered"
+
+// Every password reset request is counted, not only the failed ones: a login gets a few tokens an hour,
+// a client address a few dozens.
+var (
+	resetLoginPolicy = models.LoginLockoutPolicy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}
+	resetIPPolicy = models.LoginLockoutPolicy{
+		FreeAttempts: 20,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}
+)
+
+// HashLimitMiddleware rejects the request at once when all password hashing slots are taken. A hash takes
+// a lot of memory, so the requests are not queued to keep the memory of the service bounded.
+func (h *Handlers) HashLimitMiddleware(hr http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case h.hashSlots <- struct{}{}:
+			defer func() { <-h.hashSlots }()
+		default:
+			w.Header().Set(retryAfterHeader, "1")
+			h.writeError(w, http.StatusServiceUnavailable, codeServerBusy, "too many password checks in progress")
+			return
+		}
+
+		hr.ServeHTTP(w, r)
+	})
+}
+
+// hashNewPassword checks the password against the password policy and hashes it,
+// the response is written when the password can not be used.
+func (h *Handlers) hashNewPassword(w http.ResponseWriter, login string, password string) (string, bool) {
+	if vs := h.passwords.Validate(login, password); len(vs) > 0 {
+		h.writePasswordViolations(w, vs)
+		return "", false
+	}
+
+	hash, err := h.hashc.HashPassword(password)
+	if err != nil {
+		if errors.Is(err, security.ErrPasswordTooLong) {
+			h.writePasswordViolations(w, []security.PasswordViolation{{
+				Code:    security.PasswordTooLong,
+				Message: err.Error(),
+			}})
+			return "", false
+		}
+
+		h.writeInternalError(w)
+		h.log.Errorf("failed to get the password hash err: %v", err)
+		return "", false
+	}
+
+	return hash, true
+}
+
+// readJSON reads the request body into v, the response is written when the body can not be read.
+func (h *Handlers) readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeInternalError(w)
+		return fmt.Errorf("failed to read the request body err: %w", err)
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		h.writeError(w, http.StatusBadRequest, codeMalformedRequest, errMalformedJSON)
+		return fmt.Errorf("failed to unmarshal the request body err: %w", err)
+	}
+
+	return nil
+}
+
+// ChangePassword sets the new password if the current one is correct.
+// All sessions of the user are revoked, the caller gets a new token pair.
+func (h *Handlers) ChangePassword(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	cu, ok := userFromContext(ctx)
+	if !ok {
+		h.writeError(w, http.StatusBadRequest, codeUserUndefined, errUserUndefined)
+		h.log.Errorf(errUserUndefined)
+		return
+	}
+
+	req := struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}{}
+
+	if err := h.readJSON(w, r, &req); err != nil {
+		h.log.Errorf("failed to read the ChangePassword request err: %v", err)
+		return
+	}
+
+	if req.CurrentPassword == "" {
+		h.writeError(w, http.StatusBadRequest, codeCurrentPasswordEmpty, "current password is required")
+		return
+	}
+
+	u, err := models.GetUserByID(ctx, h.store, cu.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrUnknowUser) {
+			h.writeError(w, http.StatusUnauthorized, codeUnauthorized, "user not found")
+			return
+		}
+		h.writeInternalError(w)
+		h.log.Errorf("failed to get user in the ChangePassword request err: %v", err)
+		return
+	}
+
+	// The current password is guessed the same way as on login, so the attempts are limited too.
+	lk, ik := h.loginAttemptKeys(r, u.Login)
+
+	until, err := h.startLoginAttempt(ctx, lk, ik)
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("failed to start login attempt in the ChangePassword request err: %v", err)
+		return
+	}
+
+	if !until.IsZero() {
+		h.writeTooManyRequests(w, until)
+		return
+	}
+
+	if !h.hashc.CheckPasswordHash(u.PasswordHash, req.CurrentPassword) {
+		h.writeError(w, http.StatusForbidden, codeCurrentPasswordInvalid, "current password is incorrect")
+		return
+	}
+	h.cancelAttempt(ctx, lk, h.loginPolicy)
+	h.cancelAttempt(ctx, ik, h.ipPolicy)
+
+	hash, ok := h.hashNewPassword(w, u.Login, req.NewPassword)
+	if !ok {
+		return
+	}
+
+	if err := u.ChangePassword(ctx, h.store, hash); err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("failed to change password in the ChangePassword request err: %v", err)
+		return
+	}
+	h.users.Forget(u.ID)
+
+	rt, err := models.NewRefreshToken(u.ID, h.refreshTokenExp)
+	if err != nil {
+		h.log.Errorf("failed to build refresh token in the ChangePassword request err: %v", err)
+		h.writeInternalError(w)
+		return
+	}
+
+	if err := rt.Add(ctx, h.store); err != nil {
+		h.log.Errorf("failed to add refresh token in the ChangePassword request err: %v", err)
+		h.writeInternalError(w)
+		return
+	}
+
+	h.writeTokens(w, u, rt)
+}
+
+// RequestPasswordReset sends the password reset token to the user.
+// The response does not depend on whether the login exists.
+func (h *Handlers) RequestPasswordReset(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if h.notifier == nil {
+		h.writeError(w, http.StatusServiceUnavailable, codeResetDisabled, errResetDisabled)
+		return
+	}
+
+	var req models.UserDTO
+	if err := h.readJSON(w, r, &req); err != nil {
+		h.log.Errorf("failed to read the RequestPasswordReset request err: %v", err)
+		return
+	}
+
+	if req.Login == "" {
+		h.writeError(w, http.StatusBadRequest, codeLoginEmpty, "login is empty")
+		return
+	}
+
+	if !h.countResetRequest(ctx, w, r, req.Login) {
+		return
+	}
+
+	u, err := req.GetUser(ctx, h.store)
+	if err != nil {
+		if errors.Is(err, models.ErrUnknowUser) {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		h.writeInternalError(w)
+		h.log.Errorf("failed to get user in the RequestPasswordReset request err: %v", err)
+		return
+	}
+
+	t, err := models.NewPasswordResetToken(u.ID, h.resetTokenExp)
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("failed to build password reset token err: %v", err)
+		return
+	}
+
+	if err := t.Add(ctx, h.store); err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("failed to add password reset token err: %v", err)
+		return
+	}
+
+	m := &notifier.PasswordReset{
+		Login:     u.Login,
+		Token:     t.Token,
+		ExpiresAt: t.ExpiresAt,
+	}
+	if err := h.notifier.NotifyPasswordReset(ctx, m); err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("failed to send password reset token err: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// countResetRequest counts the reset request for the login and the client address,
+// the response is written when there were too many of them.
+func (h *Handlers) countResetRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, login string) bool {
+	lk := models.LoginAttemptKey{Kind: models.LoginAttemptResetLogin, Value: login}
+	ik := models.LoginAttemptKey{Kind: models.LoginAttemptResetIP, Value: h.clientIP(r)}
+
+	until, err := h.startAttempt(ctx, lk, resetLoginPolicy, ik, resetIPPolicy)
+	if err != nil {
+		h.writeInternalError(w)
+		h.log.Errorf("failed to count the password reset request err: %v", err)
+		return false
+	}
+
+	if !until.IsZero() {
+		s := setRetryAfter(w, until)
+		h.writeError(w, http.StatusTooManyRequests, codeTooManyResetRequests,
+			fmt.Sprintf("too many password reset requests, retry in %d seconds", s))
+		return false
+	}
+
+	return true
+}
+
+// ConfirmPasswordReset sets the new password by the password reset token and revokes all sessions of the user.
+func (h *Handlers) ConfirmPasswordReset(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if h.notifier == nil {
+		h.writeError(w, http.StatusServiceUnavailable, codeResetDisabled, errResetDisabled)
+		return
+	}
+
+	req := struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}{}
+
+	if err := h.readJSON(w, r, &req); err != nil {
+		h.log.Errorf("failed to read the ConfirmPasswordReset request err: %v", err)
+		return
+	}
+
+	if req.Token == "" {
+		h.writeError(w, http.StatusBadRequest, codeResetTokenEmpty, "password reset token is required")
+		return
+	}
+
+	t := &models.PasswordResetToken{Hash: models.HashPasswordResetToken(req.Token)}
+
+	u, err := t.User(ctx, h.store)
+	if err != nil {
+		if errors.Is(err, models.ErrPasswordResetTokenIsInvalid) {
+			h.writeError(w, http.StatusBadRequest, codeResetTokenInvalid, errResetTokenInvalid)
+			return
+		}
+		h.writeInternalError(w)
+		h.log.Errorf("failed to get user in the ConfirmPasswordReset request err: %v", err)
+		return
+	}
+
+	hash, ok := h.hashNewPassword(w, u.Login, req.NewPassword)
+	if !ok {
+		return
+	}
+
+	if _, err := t.Use(ctx, h.store, hash); err != nil {
+		if errors.Is(err, models.ErrPasswordResetTokenIsInvalid) {
+			h.writeError(w, http.StatusBadRequest, codeResetTokenInvalid, errResetTokenInvalid)
+			return
+		}
+		h.writeInternalError(w)
+		h.log.Errorf("failed to reset password in the ConfirmPasswordReset request err: %v", err)
+		return
+	}
+	h.users.Forget(u.ID)
+
+	w.WriteHeader(http.StatusOK)
+}
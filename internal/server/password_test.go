@@ -0,0 +1,439 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+	"github.com/ArtemShalinFe/gophermart/internal/notifier"
+)
+
+const testHashPrefix = "hash:"
+
+// expectPasswords keeps passwords and password reset tokens the same way the database keeps them.
+func (ts *testTokenStore) expectPasswords(mr *MockStorageMockRecorder) {
+	getUser := func(login string) *models.User {
+		for _, u := range ts.users {
+			if u.Login == login {
+				return u
+			}
+		}
+		return nil
+	}
+	changePassword := func(userID string, hash string) {
+		ts.users[userID].PasswordHash = hash
+		ts.users[userID].SessionGen++
+		for _, t := range ts.tokens {
+			if t.userID == userID {
+				t.revoked = true
+			}
+		}
+		for h, t := range ts.resets {
+			if t.UserID == userID {
+				delete(ts.resets, h)
+			}
+		}
+	}
+
+	mr.GetUser(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, us *models.UserDTO) (*models.User, error) {
+			ts.mx.Lock()
+			defer ts.mx.Unlock()
+
+			if u := getUser(us.Login); u != nil {
+				c := *u
+				return &c, nil
+			}
+			return nil, models.ErrUnknowUser
+		})
+	mr.GetUserByID(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, userID string) (*models.User, error) {
+			ts.mx.Lock()
+			defer ts.mx.Unlock()
+
+			if u, ok := ts.users[userID]; ok {
+				c := *u
+				return &c, nil
+			}
+			return nil, models.ErrUnknowUser
+		})
+	mr.ChangeUserPassword(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, userID string, hash string) (*models.User, error) {
+			ts.mx.Lock()
+			defer ts.mx.Unlock()
+
+			changePassword(userID, hash)
+			c := *ts.users[userID]
+			return &c, nil
+		})
+	mr.AddPasswordResetToken(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, t *models.PasswordResetToken) error {
+			ts.mx.Lock()
+			defer ts.mx.Unlock()
+
+			for h, rt := range ts.resets {
+				if rt.UserID == t.UserID {
+					delete(ts.resets, h)
+				}
+			}
+			ts.resets[t.Hash] = t
+			return nil
+		})
+	mr.GetPasswordResetUser(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, hash string) (*models.User, error) {
+			ts.mx.Lock()
+			defer ts.mx.Unlock()
+
+			t, ok := ts.resets[hash]
+			if !ok || t.ExpiresAt.Before(time.Now()) {
+				return nil, models.ErrPasswordResetTokenIsInvalid
+			}
+			c := *ts.users[t.UserID]
+			return &c, nil
+		})
+	mr.ResetUserPassword(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, hash string, pass string) (*models.User, error) {
+			ts.mx.Lock()
+			defer ts.mx.Unlock()
+
+			t, ok := ts.resets[hash]
+			if !ok || t.ExpiresAt.Before(time.Now()) {
+				return nil, models.ErrPasswordResetTokenIsInvalid
+			}
+			changePassword(t.UserID, pass)
+			c := *ts.users[t.UserID]
+			return &c, nil
+		})
+}
+
+// newTestPasswordHandlers returns the handlers and the file the password reset tokens are sent to.
+func newTestPasswordHandlers(t *testing.T, key string, users ...*models.User) (*Handlers, string) {
+	t.Helper()
+
+	ctrl := gomock.NewController(t)
+
+	db := NewMockStorage(ctrl)
+	hashc := NewMockHashController(ctrl)
+
+	hc := hashc.EXPECT()
+	hc.NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+	hc.HashPassword(gomock.Any()).AnyTimes().DoAndReturn(func(password string) (string, error) {
+		return testHashPrefix + password, nil
+	})
+	hc.CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(hash string, password string) bool {
+		return hash == testHashPrefix+password
+	})
+
+	ts := newTestTokenStore(users...)
+
+	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.GetWithdrawalList(gomock.Any(), gomock.Any()).AnyTimes().Return(nil, nil)
+	ts.expect(mr)
+	ts.expectPasswords(mr)
+
+	cfg := testConfig(key)
+	cfg.PasswordResetExp = time.Hour
+	cfg.PasswordResetFile = filepath.Join(t.TempDir(), "notify.jsonl")
+
+	h, err := NewHandlers(cfg, db, zap.L().Sugar(), hashc)
+	require.NoError(t, err)
+
+	return h, cfg.PasswordResetFile
+}
+
+func TestHandlers_ChangePassword(t *testing.T) {
+	for _, size := range []int{0, 10} {
+		size := size
+		t.Run(fmt.Sprintf("user cache %d", size), func(t *testing.T) {
+			testChangePassword(t, size)
+		})
+	}
+}
+
+func testChangePassword(t *testing.T, userCacheSize int) {
+	u1 := &models.User{ID: "1", Login: "gopher", PasswordHash: testHashPrefix + "old password"}
+
+	h, _ := newTestPasswordHandlers(t, "keyChangePassword", u1)
+	h.users = newUserCache(userCacheSize, userCacheTTL)
+
+	testServer := httptest.NewServer(initRouter(h))
+	defer testServer.Close()
+
+	tp := login(t, testServer, &models.UserDTO{Login: "gopher", Password: "old password"})
+	other := login(t, testServer, &models.UserDTO{Login: "gopher", Password: "old password"})
+
+	// A token issued before the change without a refresh token.
+	now := time.Now()
+	old, err := h.keys.Sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "old",
+			Subject:   u1.ID,
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{jwtAudience},
+			IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	})
+	require.NoError(t, err)
+
+	resp, _ := testRequest(t, testServer, http.MethodGet, "/api/user/withdrawals", old, nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode, "TestChangePassword: old token before the change")
+
+	var tests = []struct {
+		name   string
+		token  string
+		body   string
+		status int
+	}{
+		{
+			name:   "unauthorized",
+			body:   `{"current_password":"old password","new_password":"new password"}`,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "broken body",
+			token:  tp.AccessToken,
+			body:   `{"current_password":`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "wrong current password",
+			token:  tp.AccessToken,
+			body:   `{"current_password":"wrong password","new_password":"new password"}`,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "new password violates the policy",
+			token:  tp.AccessToken,
+			body:   `{"current_password":"old password","new_password":"gopher12"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "change",
+			token:  tp.AccessToken,
+			body:   `{"current_password":"old password","new_password":"new password"}`,
+			status: http.StatusOK,
+		},
+	}
+
+	var body []byte
+	for _, v := range tests {
+		resp, body = testRequest(t, testServer, http.MethodPost, "/api/user/password", v.token,
+			strings.NewReader(v.body))
+		require.Equal(t, v.status, resp.StatusCode, "TestChangePassword: %s", v.name)
+	}
+
+	var next models.TokenPair
+	require.NoError(t, json.Unmarshal(body, &next))
+
+	resp, _ = testRequest(t, testServer, http.MethodGet, "/api/user/withdrawals", next.AccessToken, nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode, "TestChangePassword: new access token")
+
+	resp, _ = testRequest(t, testServer, http.MethodGet, "/api/user/withdrawals", tp.AccessToken, nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "TestChangePassword: access token of the request")
+
+	resp, _ = testRequest(t, testServer, http.MethodGet, "/api/user/withdrawals", other.AccessToken, nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode,
+		"TestChangePassword: access token of the other session")
+
+	resp, _ = testRequest(t, testServer, http.MethodGet, "/api/user/withdrawals", old, nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "TestChangePassword: access token issued earlier")
+
+	resp, _ = refresh(t, testServer, other.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "TestChangePassword: other session refresh")
+
+	resp, _ = refresh(t, testServer, next.RefreshToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "TestChangePassword: new refresh token")
+
+	login(t, testServer, &models.UserDTO{Login: "gopher", Password: "new password"})
+}
+
+func TestHandlers_PasswordReset(t *testing.T) {
+	u1 := &models.User{ID: "1", Login: "gopher", PasswordHash: testHashPrefix + "old password"}
+
+	h, notifyFile := newTestPasswordHandlers(t, "keyPasswordReset", u1)
+
+	testServer := httptest.NewServer(initRouter(h))
+	defer testServer.Close()
+
+	tp := login(t, testServer, &models.UserDTO{Login: "gopher", Password: "old password"})
+
+	resetRequest := func(body string) int {
+		resp, _ := testRequest(t, testServer, http.MethodPost, "/api/user/password/reset", "",
+			strings.NewReader(body))
+		return resp.StatusCode
+	}
+	resetConfirm := func(token string, password string) int {
+		b, err := json.Marshal(map[string]string{"token": token, "new_password": password})
+		require.NoError(t, err)
+
+		resp, _ := testRequest(t, testServer, http.MethodPost, "/api/user/password/reset/confirm", "",
+			bytes.NewBuffer(b))
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusBadRequest, resetRequest(`{"login":""}`), "TestPasswordReset: empty login")
+	require.Equal(t, http.StatusAccepted, resetRequest(`{"login":"unknown"}`), "TestPasswordReset: unknown login")
+	require.Equal(t, http.StatusAccepted, resetRequest(`{"login":"gopher"}`), "TestPasswordReset: first token")
+	require.Equal(t, http.StatusAccepted, resetRequest(`{"login":"gopher"}`), "TestPasswordReset: second token")
+
+	msgs := readPasswordResets(t, notifyFile)
+	require.Len(t, msgs, 2, "TestPasswordReset: messages are sent only to known users")
+	require.Equal(t, "gopher", msgs[1].Login)
+
+	require.Equal(t, http.StatusBadRequest, resetConfirm("", "new password"), "TestPasswordReset: empty token")
+	require.Equal(t, http.StatusBadRequest, resetConfirm("unknown", "new password"),
+		"TestPasswordReset: unknown token")
+	require.Equal(t, http.StatusBadRequest, resetConfirm(msgs[0].Token, "new password"),
+		"TestPasswordReset: token replaced by the next one")
+	require.Equal(t, http.StatusBadRequest, resetConfirm(msgs[1].Token, "short"),
+		"TestPasswordReset: new password violates the policy")
+	require.Equal(t, http.StatusOK, resetConfirm(msgs[1].Token, "new password"), "TestPasswordReset: reset")
+	require.Equal(t, http.StatusBadRequest, resetConfirm(msgs[1].Token, "other password"),
+		"TestPasswordReset: the token is single-use")
+
+	resp, _ := refresh(t, testServer, tp.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "TestPasswordReset: sessions are revoked")
+
+	login(t, testServer, &models.UserDTO{Login: "gopher", Password: "new password"})
+}
+
+func TestHandlers_PasswordResetDisabled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+
+	h, err := NewHandlers(testConfig("keyPasswordResetDisabled"), NewMockStorage(ctrl), zap.L().Sugar(), hashc)
+	require.NoError(t, err)
+
+	testServer := httptest.NewServer(initRouter(h))
+	defer testServer.Close()
+
+	for path, body := range map[string]string{
+		"/api/user/password/reset":         `{"login":"gopher"}`,
+		"/api/user/password/reset/confirm": `{"token":"token","new_password":"new password"}`,
+	} {
+		resp, b := testRequest(t, testServer, http.MethodPost, path, "", strings.NewReader(body))
+		requireProblem(t, resp, b, http.StatusServiceUnavailable, codeResetDisabled)
+	}
+}
+
+func TestHandlers_PasswordResetThrottled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := NewMockStorage(ctrl)
+	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+
+	var counted []models.LoginAttemptKey
+	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(ctx context.Context, k models.LoginAttemptKey, p models.LoginLockoutPolicy) (time.Time, error) {
+			if k.Kind == models.LoginAttemptResetLogin && k.Value == "locked" {
+				return time.Now().Add(time.Minute), nil
+			}
+			counted = append(counted, k)
+			return time.Time{}, nil
+		})
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(ctx context.Context, k models.LoginAttemptKey, p models.LoginLockoutPolicy) error {
+			for i := range counted {
+				if counted[i] == k {
+					counted = append(counted[:i], counted[i+1:]...)
+					break
+				}
+			}
+			return nil
+		})
+	mr.GetUser(gomock.Any(), gomock.Any()).AnyTimes().Return(nil, models.ErrUnknowUser)
+
+	cfg := testConfig("keyPasswordResetThrottled")
+	cfg.PasswordResetFile = filepath.Join(t.TempDir(), "notify.jsonl")
+	h, err := NewHandlers(cfg, db, zap.L().Sugar(), hashc)
+	require.NoError(t, err)
+
+	testServer := httptest.NewServer(initRouter(h))
+	defer testServer.Close()
+
+	resp, b := testRequest(t, testServer, http.MethodPost, "/api/user/password/reset", "",
+		strings.NewReader(`{"login":"locked"}`))
+	requireProblem(t, resp, b, http.StatusTooManyRequests, codeTooManyResetRequests)
+	require.NotEmpty(t, resp.Header.Get(retryAfterHeader))
+	require.Empty(t, counted, "the rejected request must not be counted")
+
+	resp, _ = testRequest(t, testServer, http.MethodPost, "/api/user/password/reset", "",
+		strings.NewReader(`{"login":"unknown"}`))
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	require.Equal(t, []models.LoginAttemptKey{
+		{Kind: models.LoginAttemptResetIP, Value: "127.0.0.1"},
+		{Kind: models.LoginAttemptResetLogin, Value: "unknown"},
+	}, counted, "the request for an unknown login is counted too")
+}
+
+func TestHandlers_HashLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	h, err := NewHandlers(testConfig("keyHashLimit"), NewMockStorage(ctrl), zap.L().Sugar(),
+		NewMockHashController(ctrl))
+	require.NoError(t, err)
+
+	// All slots are taken by the passwords being hashed.
+	for i := 0; i < cap(h.hashSlots); i++ {
+		h.hashSlots <- struct{}{}
+	}
+
+	testServer := httptest.NewServer(initRouter(h))
+	defer testServer.Close()
+
+	for _, path := range []string{"/api/user/register", "/api/user/login", "/api/user/password/reset/confirm"} {
+		resp, b := testRequest(t, testServer, http.MethodPost, path, "",
+			strings.NewReader(`{"login":"gopher","password":"password"}`))
+		requireProblem(t, resp, b, http.StatusServiceUnavailable, codeServerBusy)
+		require.Equal(t, "1", resp.Header.Get(retryAfterHeader), path)
+	}
+
+	<-h.hashSlots
+	resp, _ := testRequest(t, testServer, http.MethodPost, "/api/user/register", "", strings.NewReader(`{"login":""}`))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, "the request passes when a slot is free")
+}
+
+func readPasswordResets(t *testing.T, path string) []*notifier.PasswordReset {
+	t.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var msgs []*notifier.PasswordReset
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var m notifier.PasswordReset
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &m))
+		msgs = append(msgs, &m)
+	}
+	require.NoError(t, sc.Err())
+
+	return msgs
+}
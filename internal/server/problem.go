@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ArtemShalinFe/gophermart/internal/security"
+)
+
+const contentTypeProblemJSON = "application/problem+json"
+
+// Stable machine-readable codes of the failures, the clients rely on them instead of the detail text.
+const (
+	codeInternal                = "internal_error"
+	codeMalformedRequest        = "malformed_request"
+	codeUserUndefined           = "user_undefined"
+	codeUnauthorized            = "unauthorized"
+	codeForbidden               = "forbidden"
+	codeNotFound                = "not_found"
+	codeMethodNotAllowed        = "method_not_allowed"
+	codeLoginEmpty              = "login_empty"
+	codeLoginIsBusy             = "login_is_busy"
+	codeInvalidCredentials      = "invalid_credentials"
+	codeTooManyLoginAttempts    = "too_many_login_attempts"
+	codePasswordPolicy          = "password_policy_violation"
+	codeCurrentPasswordEmpty    = "current_password_empty"
+	codeCurrentPasswordInvalid  = "current_password_invalid"
+	codeRefreshTokenEmpty       = "refresh_token_empty"
+	codeRefreshTokenInvalid     = "refresh_token_invalid"
+	codeRefreshTokenReused      = "refresh_token_reused"
+	codeResetTokenEmpty         = "password_reset_token_empty"
+	codeResetTokenInvalid       = "password_reset_token_invalid"
+	codeResetDisabled           = "password_reset_disabled"
+	codeTooManyResetRequests    = "too_many_password_reset_requests"
+	codeOrderNumberInvalid      = "order_number_invalid"
+	codeOrderNotFound           = "order_not_found"
+	codeOrderBatchEmpty         = "order_batch_empty"
+	codeOrderBatchTooLarge      = "order_batch_too_large"
+	codeOrderOwnedByAnotherUser = "order_uploaded_by_another_user"
+	codeWithdrawalSumInvalid    = "withdrawal_sum_not_positive"
+	codeWithdrawalRegistered    = "withdrawal_already_registered"
+	codeNotEnoughAccruals       = "not_enough_accruals"
+	codeUnlockTargetEmpty       = "unlock_target_empty"
+	codeInvalidQuery            = "invalid_query_parameter"
+	codeUnsupportedMediaType    = "unsupported_media_type"
+	codeRequestBodyTooLarge     = "request_body_too_large"
+	codeCursorInvalid           = "cursor_invalid"
+	codeCursorMismatch          = "cursor_filter_mismatch"
+	codeIdempotencyKeyTooLong   = "idempotency_key_too_long"
+	codeIdempotencyKeyReused    = "idempotency_key_reused"
+	codeIdempotencyInProgress   = "idempotent_request_in_progress"
+	codeServerBusy              = "server_busy"
+)
+
+// Problem is the RFC 7807 error body. The type is always about:blank,
+// so the failure is identified by the Code extension member.
+type Problem struct {
+	Type       string                       `json:"type"`
+	Title      string                       `json:"title"`
+	Detail     string                       `json:"detail,omitempty"`
+	Code       string                       `json:"code"`
+	Violations []security.PasswordViolation `json:"violations,omitempty"`
+	Status     int                          `json:"status"`
+}
+
+func newProblem(status int, code string, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// writeProblem answers the request with the problem+json body, every failure response is written by it.
+func (h *Handlers) writeProblem(w http.ResponseWriter, p *Problem) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.log.Errorf("problem marshal to json err: %v", err)
+		return
+	}
+
+	w.Header().Set(contentType, contentTypeProblemJSON)
+	w.WriteHeader(p.Status)
+
+	if _, err = w.Write(b); err != nil {
+		h.log.Errorf("failed to write problem err: %v", err)
+	}
+}
+
+func (h *Handlers) writeError(w http.ResponseWriter, status int, code string, detail string) {
+	h.writeProblem(w, newProblem(status, code, detail))
+}
+
+// writeInternalError hides the cause of the failure from the client, the cause must be logged by the caller.
+func (h *Handlers) writeInternalError(w http.ResponseWriter) {
+	h.writeError(w, http.StatusInternalServerError, codeInternal, "")
+}
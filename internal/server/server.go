@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -16,56 +18,144 @@ import (
 	"github.com/ArtemShalinFe/gophermart/internal/models"
 )
 
+const (
+	accrualLeaseTime    = time.Minute
+	accrualJobTimeout   = 30 * time.Second
+	accrualStoreTimeout = 10 * time.Second
+	accrualMaxBackoff   = time.Hour
+	requestTimeout      = 30 * time.Second
+	writeTimeout        = requestTimeout + 5*time.Second
+	purgeInterval       = time.Hour
+)
+
 type Server struct {
 	httpServer         *http.Server
 	log                *zap.SugaredLogger
+	accruals           *sync.WaitGroup
+	instanceID         string
 	accIntervalTimeout time.Duration
+	accMaxAge          time.Duration
+	accWorkers         int
+	accRateLimit       int
+	accMaxAttempts     int
 }
 
 func InitServer(ctx context.Context, h *Handlers, cfg config.Config, log *zap.SugaredLogger, db Storage) *Server {
+	a := adapters.NewAccrualClient(cfg, log)
+	h.accrual = a
+
 	s := &Server{
 		httpServer: &http.Server{
-			Addr:    cfg.Address,
-			Handler: initRouter(h),
+			Addr:         cfg.Address,
+			Handler:      initRouter(h),
+			WriteTimeout: writeTimeout,
 		},
 		accIntervalTimeout: time.Duration(cfg.AccrualInterval) * time.Second,
+		accWorkers:         cfg.AccrualWorkers,
+		accRateLimit:       cfg.AccrualRateLimit,
+		accMaxAttempts:     cfg.AccrualMaxAttempts,
+		accMaxAge:          cfg.AccrualMaxAge,
+		accruals:           &sync.WaitGroup{},
+		instanceID:         instanceID(),
 		log:                log,
 	}
 
-	a := adapters.NewAccrualClient(cfg, log)
-	go s.RunOrderAccruals(ctx, a, db)
+	s.RunOrderAccruals(ctx, a, db)
+	s.RunPurge(ctx, db)
 
 	return s
 }
 
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "gophermart"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
 func initRouter(h *Handlers) *chi.Mux {
 	router := chi.NewRouter()
 	router.Use(middleware.Recoverer)
+	router.Use(middleware.Timeout(requestTimeout))
 	router.Use(h.RequestLogger)
+	router.Use(h.BodyLimitMiddleware)
+
+	jsonBody := h.ContentTypeMiddleware(contentTypeJSON)
+	textBody := h.ContentTypeMiddleware(contentTypeText)
+	batchBody := h.ContentTypeMiddleware(contentTypeJSON, contentTypeText)
+
+	router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		h.writeError(w, http.StatusNotFound, codeNotFound, "")
+	})
+
+	router.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		h.writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "")
+	})
+
+	router.Get("/api/health", func(w http.ResponseWriter, r *http.Request) {
+		h.GetHealth(r.Context(), w, r)
+	})
+
+	router.Get("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		h.GetJWKS(r.Context(), w, r)
+	})
+
+	router.Route("/api/admin", func(r chi.Router) {
+		r.Use(h.AdminMiddleware)
+
+		r.With(jsonBody).Post("/login/unlock", func(w http.ResponseWriter, r *http.Request) {
+			h.UnlockLogin(r.Context(), w, r)
+		})
+	})
 
 	router.Route("/api/user", func(r chi.Router) {
-		r.Post("/register", func(w http.ResponseWriter, r *http.Request) {
+		r.With(jsonBody, h.HashLimitMiddleware).Post("/register", func(w http.ResponseWriter, r *http.Request) {
 			h.Register(r.Context(), w, r)
 		})
 
-		r.Post("/login", func(w http.ResponseWriter, r *http.Request) {
+		r.With(jsonBody, h.HashLimitMiddleware).Post("/login", func(w http.ResponseWriter, r *http.Request) {
 			h.Login(r.Context(), w, r)
 		})
 
+		r.With(jsonBody).Post("/token/refresh", func(w http.ResponseWriter, r *http.Request) {
+			h.RefreshToken(r.Context(), w, r)
+		})
+
+		r.With(jsonBody).Post("/password/reset", func(w http.ResponseWriter, r *http.Request) {
+			h.RequestPasswordReset(r.Context(), w, r)
+		})
+
+		r.With(jsonBody, h.HashLimitMiddleware).Post("/password/reset/confirm", func(w http.ResponseWriter, r *http.Request) {
+			h.ConfirmPasswordReset(r.Context(), w, r)
+		})
+
 		r.Group(func(r chi.Router) {
 			r.Use(h.JwtMiddleware)
 
+			r.With(jsonBody).Post("/logout", func(w http.ResponseWriter, r *http.Request) {
+				h.Logout(r.Context(), w, r)
+			})
+
+			r.With(jsonBody, h.HashLimitMiddleware).Post("/password", func(w http.ResponseWriter, r *http.Request) {
+				h.ChangePassword(r.Context(), w, r)
+			})
+
 			const orderPath = "/orders"
 
-			r.Post(orderPath, func(w http.ResponseWriter, r *http.Request) {
+			r.With(textBody, h.IdempotencyMiddleware).Post(orderPath, func(w http.ResponseWriter, r *http.Request) {
 				h.AddOrder(r.Context(), w, r)
 			})
 
+			r.With(batchBody, h.IdempotencyMiddleware).Post(orderPath+"/batch", func(w http.ResponseWriter, r *http.Request) {
+				h.AddOrderBatch(r.Context(), w, r)
+			})
+
 			r.Get("/balance", func(w http.ResponseWriter, r *http.Request) {
 				h.GetBalance(r.Context(), w, r)
 			})
 
-			r.Post("/balance/withdraw", func(w http.ResponseWriter, r *http.Request) {
+			r.With(jsonBody, h.IdempotencyMiddleware).Post("/balance/withdraw", func(w http.ResponseWriter, r *http.Request) {
 				h.AddBalanceWithdrawn(r.Context(), w, r)
 			})
 
@@ -73,6 +163,10 @@ func initRouter(h *Handlers) *chi.Mux {
 				h.GetOrders(r.Context(), w, r)
 			})
 
+			r.Get(orderPath+"/{"+orderNumberParam+"}", func(w http.ResponseWriter, r *http.Request) {
+				h.GetOrder(r.Context(), w, r)
+			})
+
 			r.Get("/withdrawals", func(w http.ResponseWriter, r *http.Request) {
 				h.GetBalanceMovementHistory(r.Context(), w, r)
 			})
@@ -95,82 +189,261 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	if err := s.httpServer.Shutdown(ctx); err != nil {
 		return fmt.Errorf("server shutdown err: %w", err)
 	}
-	return nil
+
+	drained := make(chan struct{})
+	go func() {
+		s.accruals.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("accrual workers drain err: %w", ctx.Err())
+	}
 }
 
 func (s *Server) RunOrderAccruals(ctx context.Context, a *adapters.Accrual, db Storage) {
-	ticker := time.NewTicker(s.accIntervalTimeout)
+	jobTimeout := accrualJobTimeout
+	if b := a.Budget(); b > 0 {
+		jobTimeout = b
+	}
+	// The job is leased until it is surely finished, otherwise another instance takes it in the middle.
+	leaseTime := accrualLeaseTime
+	if d := jobTimeout + accrualStoreTimeout; d > leaseTime {
+		leaseTime = d
+	}
+
+	jobs := make(chan *models.AccrualJob)
+	errs := make(chan error, s.accWorkers)
+	limiter := newRateLimiter(s.accRateLimit)
+	wg := &sync.WaitGroup{}
 
-	errs := make(chan error, 1)
-	sleepyChan := make(chan int, 1)
+	wg.Add(1)
+	go func(ctx context.Context, jobs chan<- *models.AccrualJob, errs chan<- error) {
+		defer wg.Done()
+		defer close(jobs)
 
-	const defaultOrderChanSize = 10
-	orders := make(chan *models.Order, defaultOrderChanSize)
+		ticker := time.NewTicker(s.accIntervalTimeout)
+		defer ticker.Stop()
 
-	go func(ctx context.Context, orders chan<- *models.Order, sleepyChan <-chan int, errs chan<- error) {
 		for {
-			select {
-			case <-ctx.Done():
-				return
-			case timeoutSec := <-sleepyChan:
-				time.Sleep(time.Duration(timeoutSec) * time.Second)
-			case <-ticker.C:
+			js, err := models.LeaseOrdersForAccrual(ctx, db, s.instanceID, s.accWorkers, leaseTime)
+			if err != nil {
+				errs <- fmt.Errorf("failed lease orders for accrual err: %w", err)
 			}
 
-			ors, err := models.GetOrdersForAccrual(ctx, db)
-			if err != nil {
-				errs <- fmt.Errorf("failed get orders for accrual err: %w", err)
-				continue
+			for i, j := range js {
+				select {
+				case jobs <- j:
+				case <-ctx.Done():
+					s.releaseOrderAccruals(db, js[i:], errs)
+					return
+				}
 			}
 
-			for _, o := range ors {
-				orders <- o
+			// A full batch means there is more work in the queue, so don't wait for the next tick.
+			if len(js) == s.accWorkers {
+				continue
 			}
-		}
-	}(ctx, orders, sleepyChan, errs)
 
-	go func(ctx context.Context, orders <-chan *models.Order, sleepyChan chan<- int, errs chan<- error) {
-		for o := range orders {
 			select {
 			case <-ctx.Done():
 				return
-			default:
+			case <-ticker.C:
 			}
+		}
+	}(ctx, jobs, errs)
 
-			oa, err := a.GetOrderAccrual(ctx, o)
-			if err != nil {
-				if err.IsOrderNotRegistered() {
+	for i := 0; i < s.accWorkers; i++ {
+		wg.Add(1)
+		go func(ctx context.Context, jobs <-chan *models.AccrualJob, errs chan<- error) {
+			defer wg.Done()
+
+			for j := range jobs {
+				if err := limiter.Wait(ctx); err != nil {
+					s.releaseOrderAccruals(db, []*models.AccrualJob{j}, errs)
 					continue
 				}
-				if err.IsTooManyRequests() {
-					timeoutSec, ok := err.TimeoutSec()
-					if ok {
-						sleepyChan <- timeoutSec
-						time.Sleep(time.Duration(timeoutSec) * time.Second)
-						continue
-					}
+
+				// The job is finished with its own context, so shutdown doesn't interrupt it half way.
+				jobCtx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+				if err := s.accrueOrder(jobCtx, a, db, j, limiter); err != nil {
+					errs <- err
 				}
-				errs <- fmt.Errorf("get order accrual failed err: %w", err)
-				continue
+				cancel()
 			}
+		}(ctx, jobs, errs)
+	}
+
+	go func() {
+		wg.Wait()
+		close(errs)
+	}()
 
-			o.Status = oa.Status
-			o.Accrual = oa.Accrual
+	s.accruals.Add(1)
+	go func(errs <-chan error) {
+		defer s.accruals.Done()
 
-			if err := o.Update(ctx, db); err != nil {
-				errs <- fmt.Errorf("update order failed err: %w", err)
+		for err := range errs {
+			if errors.Is(err, models.ErrAccrualLeaseLost) {
+				s.log.Infof("order accrual result was dropped err: %v", err)
+				continue
 			}
+			s.log.Errorf("failed to run order accruals err: %v", err)
 		}
-	}(ctx, orders, sleepyChan, errs)
+	}(errs)
+}
+
+func (s *Server) RunPurge(ctx context.Context, db Storage) {
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
 
-	go func(ctx context.Context, errs <-chan error) {
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case err := <-errs:
-				s.log.Errorf("failed to run order accruals err: %w", err)
+			case <-ticker.C:
 			}
+
+			n, err := models.PurgeIdempotencyKeys(ctx, db)
+			if err != nil {
+				s.log.Errorf("failed to purge idempotency keys err: %v", err)
+			} else if n > 0 {
+				s.log.Infof("expired idempotency keys were purged: %d", n)
+			}
+
+			n, err = models.PurgeLoginAttempts(ctx, db, loginFailureWindow)
+			if err != nil {
+				s.log.Errorf("failed to purge login attempts err: %v", err)
+			} else if n > 0 {
+				s.log.Infof("stale login attempts were purged: %d", n)
+			}
+		}
+	}()
+}
+
+func (s *Server) releaseOrderAccruals(db Storage, js []*models.AccrualJob, errs chan<- error) {
+	ctx, cancel := context.WithTimeout(context.Background(), accrualStoreTimeout)
+	defer cancel()
+
+	for _, j := range js {
+		if err := j.Reschedule(ctx, db, 0); err != nil {
+			errs <- fmt.Errorf("release order accrual failed err: %w", err)
+		}
+	}
+}
+
+func (s *Server) accrueOrder(reqCtx context.Context,
+	a *adapters.Accrual,
+	db Storage,
+	j *models.AccrualJob,
+	limiter *rateLimiter) error {
+	o := j.Order
+
+	oa, aerr := a.GetOrderAccrual(reqCtx, o)
+
+	// The request may have used up the job context, so the result is stored with a context of its own.
+	ctx, cancel := context.WithTimeout(context.Background(), accrualStoreTimeout)
+	defer cancel()
+
+	if aerr != nil {
+		return s.retryOrderAccrual(ctx, db, j, aerr, limiter)
+	}
+
+	o.Status = oa.OrderStatus()
+	o.Accrual = oa.Accrual
+	o.AccrualResponse = oa.Raw
+
+	if err := j.UpdateOrder(ctx, db); err != nil {
+		return fmt.Errorf("update order failed err: %w", err)
+	}
+
+	if !o.StatusIsFinal() {
+		if err := j.Reschedule(ctx, db, s.accIntervalTimeout); err != nil {
+			return fmt.Errorf("reschedule order accrual failed err: %w", err)
 		}
-	}(ctx, errs)
+	}
+
+	return nil
+}
+
+func (s *Server) retryOrderAccrual(ctx context.Context,
+	db Storage,
+	j *models.AccrualJob,
+	aerr *adapters.AccrualErr,
+	limiter *rateLimiter) error {
+	// The accrual system can't take requests now: the workers pause and the order is not charged with a failed attempt.
+	if aerr.Class() == adapters.AccrualErrThrottled {
+		delay := s.accIntervalTimeout
+		if retryAfter, ok := aerr.RetryAfter(); ok {
+			delay = retryAfter
+			limiter.Pause(delay)
+		}
+
+		if err := j.Reschedule(ctx, db, delay); err != nil {
+			return fmt.Errorf("reschedule order accrual failed err: %w", err)
+		}
+		return nil
+	}
+
+	if aerr.Class() == adapters.AccrualErrPermanent {
+		return s.invalidateOrder(ctx, db, j, fmt.Sprintf("the accrual system refused the order: %v", aerr))
+	}
+
+	// The circuit breaker counts the response out of the contract, so the order keeps its attempts.
+	if aerr.Class() == adapters.AccrualErrProtocol {
+		if err := j.Reschedule(ctx, db, s.accIntervalTimeout); err != nil {
+			return fmt.Errorf("reschedule order accrual failed err: %w", err)
+		}
+		return fmt.Errorf("get order accrual failed with %s error err: %w", aerr.Class(), aerr)
+	}
+
+	failures := j.Failures + 1
+
+	// An outage of the accrual system is waited out, an order it doesn't know is given up after the attempts.
+	if aerr.IsOrderNotRegistered() && s.accrualGaveUp(j, failures) {
+		return s.invalidateOrder(ctx, db, j,
+			fmt.Sprintf("the accrual system has not registered the order after %d attempts", failures))
+	}
+
+	if err := j.Retry(ctx, db, accrualBackoff(s.accIntervalTimeout, failures), aerr.Error()); err != nil {
+		return fmt.Errorf("retry order accrual failed err: %w", err)
+	}
+
+	if aerr.IsOrderNotRegistered() {
+		return nil
+	}
+	return fmt.Errorf("get order accrual failed with %s error err: %w", aerr.Class(), aerr)
+}
+
+func (s *Server) invalidateOrder(ctx context.Context, db Storage, j *models.AccrualJob, reason string) error {
+	o := j.Order
+	o.Status = models.OrderStatusInvalid
+	o.StatusReason = reason
+
+	if err := j.UpdateOrder(ctx, db); err != nil {
+		return fmt.Errorf("invalidate order failed err: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) accrualGaveUp(j *models.AccrualJob, failures int) bool {
+	if s.accMaxAttempts > 0 && failures >= s.accMaxAttempts {
+		return true
+	}
+	return s.accMaxAge > 0 && time.Since(j.EnqueuedAt) >= s.accMaxAge
+}
+
+func accrualBackoff(base time.Duration, failures int) time.Duration {
+	d := base
+	for i := 1; i < failures && d < accrualMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > accrualMaxBackoff {
+		d = accrualMaxBackoff
+	}
+	return d
 }
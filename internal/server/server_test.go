@@ -0,0 +1,84 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestAccrualBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{
+			name:     "first failure",
+			base:     2 * time.Second,
+			failures: 1,
+			want:     2 * time.Second,
+		},
+		{
+			name:     "fourth failure",
+			base:     2 * time.Second,
+			failures: 4,
+			want:     16 * time.Second,
+		},
+		{
+			name:     "capped",
+			base:     2 * time.Second,
+			failures: 100,
+			want:     accrualMaxBackoff,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, accrualBackoff(tt.base, tt.failures))
+		})
+	}
+}
+
+func TestServer_AccrualGaveUp(t *testing.T) {
+	s := &Server{
+		accMaxAttempts: 5,
+		accMaxAge:      time.Hour,
+	}
+
+	tests := []struct {
+		name     string
+		enqueued time.Time
+		failures int
+		want     bool
+	}{
+		{
+			name:     "fresh order",
+			enqueued: time.Now(),
+			failures: 1,
+			want:     false,
+		},
+		{
+			name:     "too many attempts",
+			enqueued: time.Now(),
+			failures: 5,
+			want:     true,
+		},
+		{
+			name:     "too old",
+			enqueued: time.Now().Add(-2 * time.Hour),
+			failures: 1,
+			want:     true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			j := &models.AccrualJob{EnqueuedAt: tt.enqueued}
+			require.Equal(t, tt.want, s.accrualGaveUp(j, tt.failures))
+		})
+	}
+}
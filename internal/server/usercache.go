@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+const userCacheTTL = time.Minute
+
+// userCache is a small in-process cache of users by ID, entries are evicted after userCacheTTL.
+type userCache struct {
+	mu    *sync.Mutex
+	items map[string]userCacheItem
+	size  int
+	ttl   time.Duration
+}
+
+type userCacheItem struct {
+	expires time.Time
+	user    *models.User
+}
+
+func newUserCache(size int, ttl time.Duration) *userCache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &userCache{
+		mu:    &sync.Mutex{},
+		items: make(map[string]userCacheItem, size),
+		size:  size,
+		ttl:   ttl,
+	}
+}
+
+func (c *userCache) Get(ctx context.Context, db models.UserStorage, userID string) (*models.User, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	it, ok := c.items[userID]
+	c.mu.Unlock()
+
+	if ok && now.Before(it.expires) {
+		return it.user, nil
+	}
+
+	u, err := models.GetUserByID(ctx, db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user cache get err: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.items) >= c.size {
+		c.evict(now)
+	}
+	c.items[userID] = userCacheItem{user: u, expires: now.Add(c.ttl)}
+
+	return u, nil
+}
+
+func (c *userCache) Forget(userID string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.items, userID)
+}
+
+func (c *userCache) evict(now time.Time) {
+	for id, it := range c.items {
+		if !now.Before(it.expires) {
+			delete(c.items, id)
+		}
+	}
+
+	// Every entry is still fresh, an arbitrary one is evicted.
+	for id := range c.items {
+		if len(c.items) < c.size {
+			return
+		}
+		delete(c.items, id)
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestUserCache_Get(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := NewMockStorage(ctrl)
+
+	u1 := &models.User{ID: "1", Login: "test1"}
+	u2 := &models.User{ID: "2", Login: "test2"}
+
+	mr := db.EXPECT()
+	mr.GetUserByID(gomock.Any(), u1.ID).Times(2).Return(u1, nil)
+	mr.GetUserByID(gomock.Any(), u2.ID).Times(1).Return(u2, nil)
+	mr.GetUserByID(gomock.Any(), "3").Times(2).Return(nil, models.ErrUnknowUser)
+
+	ctx := context.Background()
+	c := newUserCache(1, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		u, err := c.Get(ctx, db, u1.ID)
+		require.NoError(t, err)
+		require.Equal(t, u1, u)
+	}
+
+	// The cache keeps one user, so the first one is evicted.
+	u, err := c.Get(ctx, db, u2.ID)
+	require.NoError(t, err)
+	require.Equal(t, u2, u)
+
+	c.Forget(u2.ID)
+	u, err = c.Get(ctx, db, u1.ID)
+	require.NoError(t, err)
+	require.Equal(t, u1, u)
+
+	for i := 0; i < 2; i++ {
+		_, err = c.Get(ctx, db, "3")
+		require.ErrorIs(t, err, models.ErrUnknowUser)
+	}
+}
+
+func TestUserCache_Disabled(t *testing.T) {
+	require.Nil(t, newUserCache(0, time.Hour))
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const contentTypeText = "text/plain"
+
+// BodyLimitMiddleware reads the request body up to the limit, so the handlers never read more than it.
+// The white space around the body is trimmed and ContentLength is set to the length of what is left,
+// so every handler reads the body the same way and the body of white space only is empty.
+func (h *Handlers) BodyLimitMiddleware(hr http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := r.Body
+		if h.maxRequestBody > 0 {
+			if r.ContentLength > h.maxRequestBody {
+				h.writeBodyTooLarge(w)
+				return
+			}
+			body = http.MaxBytesReader(w, r.Body, h.maxRequestBody)
+		}
+
+		b, err := io.ReadAll(body)
+		if err != nil {
+			var mbErr *http.MaxBytesError
+			if errors.As(err, &mbErr) {
+				h.writeBodyTooLarge(w)
+				return
+			}
+			h.writeError(w, http.StatusBadRequest, codeMalformedRequest, "failed to read the request body")
+			h.log.Errorf("body limit middleware read body err: %v", err)
+			return
+		}
+		b = bytes.TrimSpace(b)
+		r.Body = io.NopCloser(bytes.NewReader(b))
+		r.ContentLength = int64(len(b))
+
+		hr.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handlers) writeBodyTooLarge(w http.ResponseWriter) {
+	h.writeError(w, http.StatusRequestEntityTooLarge, codeRequestBodyTooLarge,
+		fmt.Sprintf("request body must be at most %d bytes", h.maxRequestBody))
+}
+
+// ContentTypeMiddleware allows only the listed media types, the requests without a body may omit the header.
+// The body is known to be empty by the length BodyLimitMiddleware has set, the body of unknown length is not.
+func (h *Handlers) ContentTypeMiddleware(types ...string) func(http.Handler) http.Handler {
+	return func(hr http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ct := r.Header.Get(contentType)
+			if ct == "" && r.ContentLength == 0 {
+				hr.ServeHTTP(w, r)
+				return
+			}
+
+			mt, _, err := mime.ParseMediaType(ct)
+			if err == nil {
+				for _, t := range types {
+					if mt == t {
+						hr.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+
+			h.writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType,
+				fmt.Sprintf("%s must be %s", contentType, strings.Join(types, " or ")))
+		})
+	}
+}
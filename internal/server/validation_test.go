@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/gophermart/internal/models"
+)
+
+func TestHandlers_RequestValidation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := NewMockStorage(ctrl)
+	hashc := NewMockHashController(ctrl)
+	hashc.EXPECT().NeedsRehash(gomock.Any()).AnyTimes().Return(false)
+	hashc.EXPECT().CheckPasswordHash(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
+
+	u1 := &models.User{ID: "1", Login: "test", PasswordHash: "test"}
+
+	mr := db.EXPECT()
+	mr.StartLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(time.Time{}, nil)
+	mr.CancelLoginAttempt(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.ResetLoginFailures(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AddRefreshToken(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mr.AccessTokenIsRevoked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
+	mr.GetUser(gomock.Any(), gomock.Any()).AnyTimes().Return(u1, nil)
+	mr.AddOrder(gomock.Any(), &models.OrderDTO{Number: "49927398716", UserID: u1.ID}).Times(1).Return(nil, nil)
+	mr.RevokeAccessToken(gomock.Any(), gomock.Any(), gomock.Any()).Times(3).Return(nil)
+
+	cfg := testConfig("keyRequestValidation")
+	cfg.AdminKey = "admin"
+	cfg.MaxRequestBody = 1024
+
+	h, err := NewHandlers(cfg, db, zap.L().Sugar(), hashc)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(initRouter(h))
+	defer ts.Close()
+
+	token := GetAuthorizationToken(t, ts, &models.UserDTO{Login: u1.Login, Password: u1.PasswordHash})
+
+	routes := []struct {
+		path        string
+		contentType string
+	}{
+		{path: "/api/admin/login/unlock", contentType: contentTypeJSON},
+		{path: "/api/user/register", contentType: contentTypeJSON},
+		{path: "/api/user/login", contentType: contentTypeJSON},
+		{path: "/api/user/token/refresh", contentType: contentTypeJSON},
+		{path: "/api/user/password/reset", contentType: contentTypeJSON},
+		{path: "/api/user/password/reset/confirm", contentType: contentTypeJSON},
+		{path: "/api/user/logout", contentType: contentTypeJSON},
+		{path: "/api/user/password", contentType: contentTypeJSON},
+		{path: "/api/user/orders", contentType: contentTypeText},
+		{path: "/api/user/orders/batch", contentType: contentTypeText},
+		{path: "/api/user/orders/batch", contentType: contentTypeJSON},
+		{path: "/api/user/balance/withdraw", contentType: contentTypeJSON},
+	}
+
+	post := func(path string, ct string, body io.Reader) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+path, body)
+		require.NoError(t, err)
+		req.Header.Set(authHeaderName, token)
+		req.Header.Set(adminKeyHeader, cfg.AdminKey)
+		if ct != "" {
+			req.Header.Set(contentType, ct)
+		}
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, b
+	}
+
+	for _, rt := range routes {
+		rt := rt
+		t.Run(rt.path+" "+rt.contentType, func(t *testing.T) {
+			resp, body := post(rt.path, "application/xml", bytes.NewBufferString("<order/>"))
+			requireProblem(t, resp, body, http.StatusUnsupportedMediaType, codeUnsupportedMediaType)
+
+			resp, body = post(rt.path, "", bytes.NewBufferString("{}"))
+			requireProblem(t, resp, body, http.StatusUnsupportedMediaType, codeUnsupportedMediaType)
+
+			large := strings.Repeat("1", int(cfg.MaxRequestBody)+1)
+			resp, body = post(rt.path, rt.contentType, bytes.NewBufferString(large))
+			requireProblem(t, resp, body, http.StatusRequestEntityTooLarge, codeRequestBodyTooLarge)
+
+			// The body of unknown length is cut at the limit as well.
+			resp, body = post(rt.path, rt.contentType, io.MultiReader(strings.NewReader(large)))
+			requireProblem(t, resp, body, http.StatusRequestEntityTooLarge, codeRequestBodyTooLarge)
+		})
+	}
+
+	resp, body := post("/api/user/orders", contentTypeText+"; charset=utf-8", bytes.NewBufferString(" 49927398716\r\n"))
+	require.Equal(t, http.StatusAccepted, resp.StatusCode, string(body))
+
+	// The body is empty when nothing but white space is left of it, whether its length is known or not.
+	for name, b := range map[string]io.Reader{
+		"no body":             nil,
+		"white space":         bytes.NewBufferString(" \r\n"),
+		"chunked white space": io.MultiReader(strings.NewReader("\n")),
+	} {
+		resp, body = post("/api/user/logout", "", b)
+		require.Equal(t, http.StatusOK, resp.StatusCode, "%s: %s", name, body)
+	}
+}